@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"universaldrop/internal/audit"
+	"universaldrop/internal/config"
+)
+
+func runAuditCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "verify" || len(args) > 2 {
+		fmt.Fprintln(stderr, "usage: server audit verify [dir]")
+		return 2
+	}
+	cfg, _ := config.Load()
+	dir := auditDir(cfg)
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	key, err := loadAuditKey(cfg, false)
+	if err != nil {
+		fmt.Fprintf(stderr, "audit verify failed: cannot load audit key: %v\n", err)
+		return 1
+	}
+	report, err := audit.Verify(dir, key)
+	if err != nil {
+		fmt.Fprintf(stderr, "audit verify failed after %d records: %v\n", report.Records, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "audit ok: %d records in %d files, last seq %d, head %s\n", report.Records, report.Files, report.LastSeq, report.LastHash)
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"universaldrop/internal/config"
+)
+
+func runConfigCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || len(args) > 2 || (args[0] != "check" && args[0] != "dump") {
+		fmt.Fprintln(stderr, "usage: server config check|dump [file]")
+		return 2
+	}
+	path := os.Getenv(config.FileEnv)
+	if len(args) == 2 {
+		path = args[1]
+	}
+	cfg, err := config.LoadFrom(path, os.LookupEnv)
+	if args[0] == "dump" {
+		if dumpErr := config.Dump(stdout, cfg); dumpErr != nil {
+			fmt.Fprintf(stderr, "config dump failed: %v\n", dumpErr)
+			return 1
+		}
+	}
+	if err != nil {
+		printConfigProblems(stderr, err)
+		return 1
+	}
+	if args[0] == "check" {
+		fmt.Fprintln(stdout, "config ok")
+	}
+	return 0
+}
+
+func printConfigProblems(w io.Writer, err error) {
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		fmt.Fprintf(w, "config error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "config has %d problem(s):\n", len(invalid.Problems))
+	for _, problem := range invalid.Problems {
+		fmt.Fprintf(w, "  %s\n", problem)
+	}
+}
@@ -2,44 +2,153 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"universaldrop/internal/api"
+	"universaldrop/internal/atrest"
+	"universaldrop/internal/audit"
 	"universaldrop/internal/auth"
+	"universaldrop/internal/clientip"
 	"universaldrop/internal/clock"
 	"universaldrop/internal/config"
+	"universaldrop/internal/listener"
 	"universaldrop/internal/logging"
+	"universaldrop/internal/quota"
+	"universaldrop/internal/redis"
 	"universaldrop/internal/scanner"
+	"universaldrop/internal/secrets"
 	"universaldrop/internal/storage/localfs"
 	"universaldrop/internal/sweeper"
-	"universaldrop/internal/token"
+	"universaldrop/internal/tlsconfig"
+	"universaldrop/internal/tracing"
 )
 
 func main() {
-	cfg := config.Load()
-	logger := log.New(os.Stdout, "", log.LstdFlags)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "audit":
+			os.Exit(runAuditCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+	cfg, err := config.Load()
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger := logging.New(os.Stdout, cfg.Log.Format, level)
+	if err != nil {
+		printConfigProblems(os.Stderr, err)
+		logging.Fatal(logger, map[string]string{
+			"event": "config_invalid",
+			"error": "config_validation_failed",
+		})
+	}
 	clk := clock.RealClock{}
 
-	store, err := localfs.New(cfg.DataDir)
+	atRestKeys, err := loadAtRestKeyring(cfg.Storage)
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "at_rest_key_load_failed",
+			"error": "at_rest_key_invalid",
+		})
+	}
+	store, err := localfs.NewWithOptions(cfg.DataDir, localfs.Options{
+		Keyring:      atRestKeys,
+		SecureDelete: cfg.Storage.SecureDelete,
+	})
 	if err != nil {
 		logging.Fatal(logger, map[string]string{
 			"event": "storage_init_failed",
 		})
 	}
-	secret, err := token.LoadOrCreateHMACSecret(cfg.DataDir)
+	tokenProvider, err := auth.NewSecretProvider(cfg.DataDir, secretSpec(cfg.TokenSecret))
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "token_secret_load_failed",
+			"error": "token_secret_provider_invalid",
+		})
+	}
+	tokenSecret, err := secrets.NewReloader(context.Background(), tokenProvider)
 	if err != nil {
 		logging.Fatal(logger, map[string]string{
 			"event": "token_secret_load_failed",
 			"error": "token_secret_load_failed",
 		})
 	}
+	var turnSecret *secrets.Reloader
+	if cfg.TURNSecret.Provider != "" {
+		turnProvider, err := secrets.NewProvider(secretSpec(cfg.TURNSecret))
+		if err == nil {
+			turnSecret, err = secrets.NewReloader(context.Background(), turnProvider)
+		}
+		if err != nil {
+			logging.Fatal(logger, map[string]string{
+				"event": "turn_secret_load_failed",
+				"error": "turn_secret_load_failed",
+			})
+		}
+		cfg.TURNSharedSecret = turnSecret.Current()
+	}
+	clientIPs, err := clientip.NewResolver(cfg.TrustedProxies, clientip.Options{Header: cfg.TrustedHeader})
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "config_invalid",
+			"error": "trusted_proxies_invalid",
+		})
+	}
+	var sharedState *redis.Client
+	if cfg.SharedState.URL != "" {
+		opts, err := redis.ParseURL(cfg.SharedState.URL)
+		if err != nil {
+			logging.Fatal(logger, map[string]string{
+				"event": "config_invalid",
+				"error": "shared_state_url_invalid",
+			})
+		}
+		sharedState = redis.NewClient(opts)
+	}
+	var quotaStore quota.Store = quota.NewMemoryStore(clk)
+	var quotaFile *quota.FileStore
+	if sharedState != nil {
+		quotaStore = quota.NewRedisStore(sharedState, cfg.SharedState.KeyPrefix+"quota:")
+		if cfg.SharedState.FailureMode == config.SharedStateFailLocal {
+			quotaStore = quota.NewFailoverStore(quotaStore, quota.NewMemoryStore(clk), nil)
+		}
+	} else if cfg.QuotaStore.Backend == "file" {
+		quotaFile, err = quota.NewFileStore(filepath.Join(cfg.DataDir, "quota", "counters.json"), clk)
+		if err != nil {
+			logging.Fatal(logger, map[string]string{
+				"event": "quota_store_init_failed",
+			})
+		}
+		quotaStore = quotaFile
+	}
+	tracer := newTracer(cfg, logger)
+	auditLog := openAuditLog(cfg, clk, logger)
 	liveness := sweeper.NewLiveness()
-	capabilities := auth.NewService(secret, clk, nil)
+	revocations, err := auth.NewFileRevocationStore(filepath.Join(cfg.DataDir, "revocations", "revocations.json"), clk)
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "revocation_store_init_failed",
+		})
+	}
+	capabilities := auth.NewService(tokenSecret.Current(), clk, revocations)
+	var rotationGrace atomic.Int64
+	rotationGrace.Store(int64(tokenRotationGrace(cfg)))
+	tokenSecret.OnChange(func(secret []byte) {
+		capabilities.Rotate(secret, time.Duration(rotationGrace.Load()))
+	})
 
 	server := api.NewServer(api.Dependencies{
 		Config:        cfg,
@@ -48,29 +157,412 @@ func main() {
 		Scanner:       scanner.UnavailableScanner{},
 		Capabilities:  capabilities,
 		SweeperStatus: liveness,
+		ClientIP:      clientIPs,
+		QuotaStore:    quotaStore,
+		SharedState:   sharedState,
+		Tracer:        tracer,
+		Audit:         auditRecorder(auditLog),
 	})
+	if err := server.RebuildQuotaConcurrency(context.Background()); err != nil {
+		logging.Allowlist(logger, map[string]string{
+			"event": "quota_rebuild_failed",
+		})
+	}
+
+	if turnSecret != nil {
+		turnSecret.OnChange(server.SetTURNSharedSecret)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	var workers sync.WaitGroup
+	goBackground := func(fn func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			fn(background)
+		}()
+	}
+
+	var tlsConfig *tls.Config
+	reloadCerts := func() {}
+	if cfg.TLS.CertFile != "" {
+		certs, err := tlsconfig.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			logging.Fatal(logger, map[string]string{
+				"event": "tls_init_failed",
+				"error": "tls_cert_load_failed",
+			})
+		}
+		tlsConfig = tlsconfig.Server(certs)
+		onReload := func(err error) {
+			if err != nil {
+				logging.Allowlist(logger, map[string]string{
+					"event": "tls_cert_reload_failed",
+					"error": "tls_cert_load_failed",
+				})
+				return
+			}
+			logging.Allowlist(logger, map[string]string{
+				"event": "tls_cert_reloaded",
+			})
+		}
+		reloadCerts = func() {
+			if changed, err := certs.Reload(); changed || err != nil {
+				onReload(err)
+			}
+		}
+		goBackground(func(ctx context.Context) { certs.Watch(ctx, cfg.TLS.ReloadInterval, onReload) })
+	}
 
 	httpServer := &http.Server{
 		Addr:              cfg.Address,
 		Handler:           server.Router,
 		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         tlsConfig,
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+	store.SetEraseObserver(server.Metrics().AddSecurelyErased)
+	sweep := sweeper.New(store, clk, cfg.SweepInterval, logger, liveness, server.Metrics(), auditRecorder(auditLog))
+	sweep.Start(background)
+	reloadConfig := func() {
+		reloadCerts()
+		next, err := config.Load()
+		if err != nil {
+			count := "1"
+			var invalid *config.ValidationError
+			if errors.As(err, &invalid) {
+				count = strconv.Itoa(len(invalid.Problems))
+			}
+			logging.Allowlist(logger, map[string]string{
+				"event": "config_reload_failed",
+				"error": "config_validation_failed",
+				"count": count,
+			})
+			return
+		}
+		if turnSecret != nil {
+			next.TURNSharedSecret = turnSecret.Current()
+		}
+		server.Reload(next)
+		rotationGrace.Store(int64(tokenRotationGrace(next)))
+	}
+	goBackground(func(ctx context.Context) { reloadOnSignal(ctx, logger, reloadConfig, tokenSecret, turnSecret) })
+	goBackground(func(ctx context.Context) {
+		config.WatchFile(ctx, os.Getenv(config.FileEnv), cfg.WatchInterval, reloadConfig)
+	})
+	if quotaFile != nil {
+		goBackground(func(ctx context.Context) { quotaFile.Run(ctx, cfg.QuotaStore.FlushInterval) })
+	}
+	goBackground(func(ctx context.Context) { revocations.Run(ctx, cfg.Revocations.FlushInterval) })
+	goBackground(server.RenewQuotaLeases)
+	goBackground(server.RunAuditSummaries)
+	if atRestKeys != nil {
+		goBackground(func(ctx context.Context) { rewrapStorage(ctx, logger, store) })
+	}
 
-	sweep := sweeper.New(store, clk, cfg.SweepInterval, logger, liveness, server.Metrics())
-	sweep.Start(ctx)
+	activated := listener.FromEnv()
+	publicListener := listen(activated, cfg.Address, logger)
+	if cfg.ProxyProtocol {
+		publicListener = clientip.NewProxyListener(publicListener, clientIPs)
+	}
 
-	go func() {
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Printf("server_error=true")
-		}
-	}()
+	go serve(logger, httpServer, publicListener)
+	sideServers := []*http.Server{
+		newSideServer(activated, cfg.Admin.Address, cfg.Admin.ClientCAFile, server.AdminRouter, tlsConfig, logger),
+		newSideServer(activated, cfg.Metrics.Address, cfg.Metrics.ClientCAFile, server.MetricsRouter, tlsConfig, logger),
+	}
+	activated.CloseUnused()
 
 	<-ctx.Done()
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	stop()
+	server.Drain()
+	if cfg.Drain.Delay > 0 {
+		time.Sleep(cfg.Drain.Delay)
+	}
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Drain.Timeout)
+	defer cancelDrain()
+
+	if err := httpServer.Shutdown(drainCtx); err != nil {
+		logging.Allowlist(logger, map[string]string{
+			"event": "drain_deadline_exceeded",
+			"count": strconv.FormatInt(server.InFlightTransfers(), 10),
+		})
+		_ = httpServer.Close()
+	}
+	for _, side := range sideServers {
+		if side == nil {
+			continue
+		}
+		if err := side.Shutdown(drainCtx); err != nil {
+			_ = side.Close()
+		}
+	}
+	stopBackground()
+	sweep.Wait()
+	workers.Wait()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFlush()
+	if err := revocations.Flush(flushCtx); err != nil {
+		logging.Allowlist(logger, map[string]string{
+			"event": "revocation_store_flush_failed",
+		})
+	}
+	if err := quotaStore.Close(); err != nil {
+		logging.Allowlist(logger, map[string]string{
+			"event": "quota_store_flush_failed",
+		})
+	}
+	_ = tracer.Shutdown(flushCtx)
+	if sharedState != nil {
+		_ = sharedState.Close()
+	}
+	logging.Allowlist(logger, map[string]string{
+		"event": "drain_complete",
+	})
+	if auditLog != nil {
+		_ = auditLog.Close()
+	}
+}
 
-	_ = httpServer.Shutdown(shutdownCtx)
+func serve(logger *slog.Logger, httpServer *http.Server, listener net.Listener) {
+	var err error
+	if httpServer.TLSConfig != nil {
+		err = httpServer.ServeTLS(listener, "", "")
+	} else {
+		err = httpServer.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logging.Allowlist(logger, map[string]string{
+			"event": "server_error",
+		})
+	}
+}
+
+func listen(activated *listener.Activated, address string, logger *slog.Logger) net.Listener {
+	spec, err := listener.Parse(address)
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "listen_failed",
+			"error": "listener_address_invalid",
+		})
+	}
+	ln, err := activated.Open(spec)
+	if err != nil {
+		failure := "listen_failed"
+		if errors.Is(err, listener.ErrNotActivated) {
+			failure = "systemd_socket_missing"
+		}
+		logging.Fatal(logger, map[string]string{
+			"event": "listen_failed",
+			"error": failure,
+		})
+	}
+	return ln
+}
+
+func newSideServer(activated *listener.Activated, address, clientCAFile string, handler http.Handler, tlsConfig *tls.Config, logger *slog.Logger) *http.Server {
+	if address == "" {
+		return nil
+	}
+	if clientCAFile != "" {
+		withClients, err := tlsconfig.RequireClientCerts(tlsConfig, clientCAFile)
+		if err != nil {
+			logging.Fatal(logger, map[string]string{
+				"event": "tls_init_failed",
+				"error": "client_ca_load_failed",
+			})
+		}
+		tlsConfig = withClients
+	}
+	ln := listen(activated, address, logger)
+	side := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+	go serve(logger, side, ln)
+	return side
+}
+
+func auditRecorder(auditLog *audit.Log) audit.Recorder {
+	if auditLog == nil {
+		return nil
+	}
+	return auditLog
+}
+
+func openAuditLog(cfg config.Config, clk clock.Clock, logger *slog.Logger) *audit.Log {
+	if cfg.Audit.Disabled {
+		return nil
+	}
+	key, err := loadAuditKey(cfg, true)
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "audit_init_failed",
+			"error": "audit_key_load_failed",
+		})
+	}
+	auditLog, err := audit.Open(auditDir(cfg), audit.Options{
+		MaxFileBytes: cfg.Audit.MaxFileBytes,
+		Clock:        clk,
+		Key:          key,
+	})
+	if err != nil {
+		logging.Fatal(logger, map[string]string{
+			"event": "audit_init_failed",
+		})
+	}
+	return auditLog
+}
+
+func loadAuditKey(cfg config.Config, create bool) ([]byte, error) {
+	spec := secretSpec(cfg.Audit.Key)
+	spec.MinBytes = audit.MinKeyBytes
+	if spec.Provider == "" {
+		spec.Provider = secrets.ProviderFile
+		spec.Path = filepath.Join(cfg.DataDir, "secrets", "audit_hmac.key")
+		if create {
+			spec.CreateBytes = audit.MinKeyBytes
+		}
+	}
+	provider, err := secrets.NewProvider(spec)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Load(context.Background())
+}
+
+func auditDir(cfg config.Config) string {
+	if cfg.Audit.Dir != "" {
+		return cfg.Audit.Dir
+	}
+	return filepath.Join(cfg.DataDir, "audit")
+}
+
+func newTracer(cfg config.Config, logger *slog.Logger) *tracing.Tracer {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case config.TracingExporterOTLP:
+		exporter = tracing.NewOTLPExporter(tracing.OTLPOptions{Endpoint: cfg.Tracing.OTLPEndpoint})
+	case config.TracingExporterFile:
+		path := cfg.Tracing.File
+		if path == "" {
+			path = filepath.Join(cfg.DataDir, "traces", "spans.jsonl")
+		}
+		fileExporter, err := tracing.NewJSONFileExporter(path)
+		if err != nil {
+			logging.Fatal(logger, map[string]string{
+				"event": "tracing_init_failed",
+			})
+		}
+		exporter = fileExporter
+	default:
+		return nil
+	}
+	return tracing.New(exporter, tracing.Options{
+		SamplePercent: cfg.Tracing.SamplePercent,
+		OnDrop: func(int) {
+			logging.Allowlist(logger, map[string]string{
+				"event": "trace_export_dropped",
+			})
+		},
+	})
+}
+
+func loadAtRestKeyring(cfg config.StorageConfig) (*atrest.Keyring, error) {
+	if cfg.AtRestKey.Provider == "" {
+		return nil, nil
+	}
+	spec := secretSpec(cfg.AtRestKey)
+	spec.MinBytes = atrest.KeySize
+	provider, err := secrets.NewProvider(spec)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := provider.Load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for _, raw := range cfg.AtRestPreviousKeys {
+		key, err := secrets.DecodeBase64(raw)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return atrest.NewKeyring(primary, previous...)
+}
+
+func rewrapStorage(ctx context.Context, logger *slog.Logger, store *localfs.Store) {
+	count, err := store.Rewrap(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logging.Allowlist(logger, map[string]string{
+				"event": "at_rest_rewrap_failed",
+				"error": "rewrap_failed",
+				"count": strconv.Itoa(count),
+			})
+		}
+		return
+	}
+	logging.Allowlist(logger, map[string]string{
+		"event": "at_rest_rewrap_complete",
+		"count": strconv.Itoa(count),
+	})
+}
+
+func tokenRotationGrace(cfg config.Config) time.Duration {
+	grace := max(cfg.ClaimTokenTTL, cfg.TransferTokenTTL, cfg.DownloadTokenTTL)
+	if grace <= 0 {
+		grace = config.DefaultTransferTokenTTL
+	}
+	return grace
+}
+
+func secretSpec(source config.SecretSource) secrets.Spec {
+	return secrets.Spec{
+		Provider:      source.Provider,
+		Env:           source.Env,
+		Path:          source.Path,
+		PassphraseEnv: source.PassphraseEnv,
+		Command:       source.Command,
+	}
+}
+
+func reloadOnSignal(ctx context.Context, logger *slog.Logger, reloadConfig func(), reloaders ...*secrets.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reloadConfig()
+			for _, reloader := range reloaders {
+				if reloader == nil {
+					continue
+				}
+				changed, err := reloader.Reload(ctx)
+				if err != nil {
+					logging.Allowlist(logger, map[string]string{
+						"event": "secret_reload_failed",
+						"error": "secret_load_failed",
+					})
+					continue
+				}
+				if changed {
+					logging.Allowlist(logger, map[string]string{
+						"event": "secret_reloaded",
+					})
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,166 @@
+package admission
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+type Priority int
+
+const (
+	Critical Priority = iota
+	Transfer
+	Background
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Critical:
+		return "critical"
+	case Transfer:
+		return "transfer"
+	default:
+		return "background"
+	}
+}
+
+type Level int
+
+const (
+	Normal Level = iota
+	Shedding
+	Overloaded
+)
+
+func (l Level) String() string {
+	switch l {
+	case Shedding:
+		return "shedding"
+	case Overloaded:
+		return "overloaded"
+	default:
+		return "normal"
+	}
+}
+
+const (
+	DefaultShedPercent = 80
+	latencyWeight      = 0.2
+)
+
+type Options struct {
+	MaxInflightBytes  int64
+	MaxGoroutines     int
+	MaxStorageLatency time.Duration
+	ShedPercent       int
+	Goroutines        func() int
+}
+
+type Status struct {
+	Level          Level
+	InflightBytes  int64
+	Goroutines     int
+	StorageLatency time.Duration
+}
+
+type Controller struct {
+	opts     Options
+	inflight atomic.Int64
+	latency  atomic.Int64
+}
+
+func New(opts Options) *Controller {
+	if opts.ShedPercent <= 0 || opts.ShedPercent > 100 {
+		opts.ShedPercent = DefaultShedPercent
+	}
+	if opts.Goroutines == nil {
+		opts.Goroutines = runtime.NumGoroutine
+	}
+	return &Controller{opts: opts}
+}
+
+func (c *Controller) Enabled() bool {
+	return c != nil && (c.opts.MaxInflightBytes > 0 || c.opts.MaxGoroutines > 0 || c.opts.MaxStorageLatency > 0)
+}
+
+func (c *Controller) Admit(p Priority) bool {
+	if p == Critical || !c.Enabled() {
+		return true
+	}
+	level := c.Level()
+	if p == Transfer {
+		return level < Overloaded
+	}
+	return level == Normal
+}
+
+func (c *Controller) Reserve(n int64) (func(), bool) {
+	if !c.Enabled() || n <= 0 {
+		return func() {}, true
+	}
+	max := c.opts.MaxInflightBytes
+	for {
+		current := c.inflight.Load()
+		if max > 0 && current > 0 && current+n > max {
+			return nil, false
+		}
+		if c.inflight.CompareAndSwap(current, current+n) {
+			break
+		}
+	}
+	var released atomic.Bool
+	return func() {
+		if released.CompareAndSwap(false, true) {
+			c.inflight.Add(-n)
+		}
+	}, true
+}
+
+func (c *Controller) ObserveStorage(d time.Duration) {
+	if !c.Enabled() || d < 0 {
+		return
+	}
+	for {
+		current := c.latency.Load()
+		next := int64(d)
+		if current > 0 {
+			next = current + int64(latencyWeight*float64(int64(d)-current))
+		}
+		if c.latency.CompareAndSwap(current, next) {
+			return
+		}
+	}
+}
+
+func (c *Controller) Level() Level {
+	return c.Status().Level
+}
+
+func (c *Controller) Status() Status {
+	if !c.Enabled() {
+		return Status{}
+	}
+	status := Status{
+		InflightBytes:  c.inflight.Load(),
+		StorageLatency: time.Duration(c.latency.Load()),
+	}
+	pressure := 0.0
+	if c.opts.MaxInflightBytes > 0 {
+		pressure = max(pressure, float64(status.InflightBytes)/float64(c.opts.MaxInflightBytes))
+	}
+	if c.opts.MaxGoroutines > 0 {
+		status.Goroutines = c.opts.Goroutines()
+		pressure = max(pressure, float64(status.Goroutines)/float64(c.opts.MaxGoroutines))
+	}
+	if c.opts.MaxStorageLatency > 0 {
+		pressure = max(pressure, float64(status.StorageLatency)/float64(c.opts.MaxStorageLatency))
+	}
+	switch {
+	case pressure >= 1:
+		status.Level = Overloaded
+	case pressure*100 >= float64(c.opts.ShedPercent):
+		status.Level = Shedding
+	}
+	return status
+}
@@ -0,0 +1,87 @@
+package admission
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControllerShedsByPriority(t *testing.T) {
+	goroutines := 10
+	c := New(Options{MaxGoroutines: 100, Goroutines: func() int { return goroutines }})
+
+	for _, p := range []Priority{Critical, Transfer, Background} {
+		if !c.Admit(p) {
+			t.Fatalf("expected %s work admitted under normal load", p)
+		}
+	}
+
+	goroutines = 85
+	if c.Level() != Shedding {
+		t.Fatalf("expected shedding level, got %s", c.Level())
+	}
+	if c.Admit(Background) {
+		t.Fatalf("expected background work shed first")
+	}
+	if !c.Admit(Transfer) || !c.Admit(Critical) {
+		t.Fatalf("expected transfers admitted while shedding")
+	}
+
+	goroutines = 120
+	if c.Level() != Overloaded {
+		t.Fatalf("expected overloaded level, got %s", c.Level())
+	}
+	if c.Admit(Transfer) || c.Admit(Background) {
+		t.Fatalf("expected new transfers rejected when overloaded")
+	}
+	if !c.Admit(Critical) {
+		t.Fatalf("expected in-flight work admitted when overloaded")
+	}
+}
+
+func TestControllerBoundsInflightBytes(t *testing.T) {
+	c := New(Options{MaxInflightBytes: 100})
+
+	release, ok := c.Reserve(85)
+	if !ok {
+		t.Fatalf("expected first reservation to fit")
+	}
+	if _, ok := c.Reserve(20); ok {
+		t.Fatalf("expected reservation beyond the cap to fail")
+	}
+	if c.Level() != Shedding {
+		t.Fatalf("expected shedding near the cap, got %s", c.Level())
+	}
+	release()
+	release()
+	if got := c.Status().InflightBytes; got != 0 {
+		t.Fatalf("expected release to be idempotent, got %d in flight", got)
+	}
+
+	big, ok := c.Reserve(500)
+	if !ok {
+		t.Fatalf("expected oversized reservation admitted when idle")
+	}
+	if c.Level() != Overloaded {
+		t.Fatalf("expected overloaded while oversized reservation held")
+	}
+	big()
+}
+
+func TestControllerTracksStorageLatency(t *testing.T) {
+	c := New(Options{MaxStorageLatency: 100 * time.Millisecond})
+	c.ObserveStorage(200 * time.Millisecond)
+	if c.Level() != Overloaded {
+		t.Fatalf("expected slow storage to overload, got %s", c.Level())
+	}
+	for i := 0; i < 20; i++ {
+		c.ObserveStorage(time.Millisecond)
+	}
+	if c.Level() != Normal {
+		t.Fatalf("expected latency average to recover, got %s (%v)", c.Level(), c.Status().StorageLatency)
+	}
+
+	disabled := New(Options{})
+	if disabled.Enabled() || !disabled.Admit(Background) {
+		t.Fatalf("expected zero limits to disable admission control")
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"universaldrop/internal/admission"
+	"universaldrop/internal/logging"
+)
+
+const (
+	overloadRetryAfter = "1"
+	maxChunkBytes      = 32 << 20
+)
+
+func (s *Server) admit(priority admission.Priority) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !s.admission.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.admission.Admit(priority) {
+				s.shed(w, r, priority.String())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) reserveInflight(w http.ResponseWriter, r *http.Request, bytes int64) (func(), bool) {
+	release, ok := s.admission.Reserve(bytes)
+	if !ok {
+		s.shed(w, r, "inflight_bytes")
+		return nil, false
+	}
+	return release, true
+}
+
+func (s *Server) shed(w http.ResponseWriter, r *http.Request, scope string) {
+	s.metrics.IncAdmissionRejected()
+	logging.Allowlist(s.logger, map[string]string{
+		"event":  "load_shed",
+		"route":  routePattern(r),
+		"scope":  scope,
+		"reason": s.admission.Level().String(),
+	})
+	w.Header().Set("Retry-After", overloadRetryAfter)
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "overloaded"})
+}
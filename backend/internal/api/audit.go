@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"universaldrop/internal/logging"
+)
+
+const auditSummaryInterval = time.Minute
+
+type rejectionKey struct {
+	route  string
+	scope  string
+	reason string
+}
+
+type rejectionCounts struct {
+	mu     sync.Mutex
+	counts map[rejectionKey]uint64
+}
+
+func (c *rejectionCounts) add(key rejectionKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = map[rejectionKey]uint64{}
+	}
+	c.counts[key]++
+}
+
+func (c *rejectionCounts) drain() map[rejectionKey]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = nil
+	return counts
+}
+
+func (s *Server) recordSecurityEvent(fields map[string]string) {
+	logging.Allowlist(s.logger, fields)
+	if s.audit == nil {
+		return
+	}
+	s.auditWritten(s.audit.Record(fields))
+}
+
+func (s *Server) recordUnauthenticatedEvent(fields map[string]string) {
+	logging.Allowlist(s.logger, fields)
+	s.metrics.IncCapabilityRejected(fields["reason"])
+	if s.audit == nil {
+		return
+	}
+	s.rejections.add(rejectionKey{route: fields["route"], scope: fields["scope"], reason: fields["reason"]})
+}
+
+func (s *Server) RunAuditSummaries(ctx context.Context) {
+	ticker := time.NewTicker(auditSummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.FlushAuditSummaries()
+			return
+		case <-ticker.C:
+			s.FlushAuditSummaries()
+		}
+	}
+}
+
+func (s *Server) FlushAuditSummaries() {
+	counts := s.rejections.drain()
+	keys := make([]rejectionKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].scope != keys[j].scope {
+			return keys[i].scope < keys[j].scope
+		}
+		return keys[i].reason < keys[j].reason
+	})
+	for _, key := range keys {
+		s.recordSecurityEvent(map[string]string{
+			"event":  "capability_rejected_summary",
+			"route":  key.route,
+			"scope":  key.scope,
+			"reason": key.reason,
+			"count":  strconv.FormatUint(counts[key], 10),
+		})
+	}
+}
+
+func (s *Server) auditWritten(err error) {
+	if err == nil {
+		return
+	}
+	s.metrics.IncAuditWriteFailures()
+	logging.Allowlist(s.logger, map[string]string{
+		"event": "audit_write_failed",
+		"error": "audit_error",
+	})
+}
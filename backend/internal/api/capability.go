@@ -6,6 +6,7 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"universaldrop/internal/auth"
+	"universaldrop/internal/tracing"
 )
 
 func routePattern(r *http.Request) string {
@@ -21,11 +22,65 @@ func routePattern(r *http.Request) string {
 }
 
 func (s *Server) requireCapability(r *http.Request, token string, req auth.Requirement) (auth.Claims, bool) {
+	return s.authorizeCapability(r, token, req, s.capabilities.Check)
+}
+
+func (s *Server) inspectCapability(r *http.Request, token string, req auth.Requirement) (auth.Claims, bool) {
+	return s.authorizeCapability(r, token, req, s.capabilities.Inspect)
+}
+
+func (s *Server) consumeCapability(r *http.Request, claims auth.Claims) bool {
+	if reason := s.capabilities.Consume(claims); reason != "" {
+		s.logCapabilityRejected(r, claims.Scope, reason)
+		return false
+	}
+	return true
+}
+
+func (s *Server) authorizeCapability(r *http.Request, token string, req auth.Requirement, check func(string, auth.Requirement) (auth.Claims, string)) (auth.Claims, bool) {
 	if token == "" {
 		token = bearerToken(r)
 	}
 	if req.Route == "" {
 		req.Route = routePattern(r)
 	}
-	return s.capabilities.Validate(token, req)
+	_, span := tracing.Start(r.Context(), "auth.check_capability")
+	defer span.End()
+	span.SetAttribute("scope", req.Scope)
+	claims, reason := check(token, req)
+	if reason != "" {
+		span.SetError(reason)
+		s.logCapabilityRejected(r, req.Scope, reason)
+		return auth.Claims{}, false
+	}
+	annotateRequestSpan(r, claims.SessionID, claims.ClaimID, claims.TransferID)
+	return claims, true
+}
+
+func (s *Server) checkClaims(r *http.Request, claims auth.Claims, req auth.Requirement) bool {
+	if req.Route == "" {
+		req.Route = routePattern(r)
+	}
+	_, span := tracing.Start(r.Context(), "auth.check_claims")
+	defer span.End()
+	scope := req.Scope
+	if scope == "" {
+		scope = claims.Scope
+	}
+	span.SetAttribute("scope", scope)
+	if reason := s.capabilities.CheckClaims(claims, req); reason != "" {
+		span.SetError(reason)
+		s.logCapabilityRejected(r, scope, reason)
+		return false
+	}
+	return true
+}
+
+func (s *Server) logCapabilityRejected(r *http.Request, scope string, reason string) {
+	s.recordUnauthenticatedEvent(map[string]string{
+		"event":  "capability_rejected",
+		"route":  routePattern(r),
+		"scope":  scope,
+		"reason": reason,
+	})
 }
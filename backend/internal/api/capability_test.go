@@ -5,14 +5,17 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"universaldrop/internal/auth"
+	"universaldrop/internal/clock"
 	"universaldrop/internal/config"
+	"universaldrop/internal/logging"
 )
 
 func setupTransferFixture(t *testing.T, server *Server, totalBytes int64) (sessionCreateResponse, sessionClaimResponse, sessionApproveResponse, transferInitResponse, string) {
@@ -278,6 +281,48 @@ func TestCapabilityExpiryEnforced(t *testing.T) {
 	}
 }
 
+func TestCapabilitySecretRotationKeepsPreviousSecret(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	caps := auth.NewService(bytes.Repeat([]byte{0x01}, 32), clk, nil)
+	token, err := caps.Issue(auth.IssueSpec{Scope: auth.ScopeSessionClaim, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	requirement := auth.Requirement{Scope: auth.ScopeSessionClaim}
+
+	if !caps.Rotate(bytes.Repeat([]byte{0x02}, 32), 5*time.Minute) {
+		t.Fatalf("expected rotation to apply")
+	}
+	if _, reason := caps.Inspect(token, requirement); reason != "" {
+		t.Fatalf("expected previous secret to validate, got %q", reason)
+	}
+	fresh, err := caps.Issue(auth.IssueSpec{Scope: auth.ScopeSessionClaim, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	if _, reason := caps.Inspect(fresh, requirement); reason != "" {
+		t.Fatalf("expected current secret to validate, got %q", reason)
+	}
+
+	clk.Advance(5 * time.Minute)
+	if _, reason := caps.Inspect(token, requirement); reason != auth.ReasonSignature {
+		t.Fatalf("expected previous secret to stop validating after the grace period, got %q", reason)
+	}
+	if _, reason := caps.Inspect(fresh, requirement); reason != "" {
+		t.Fatalf("expected current secret to keep validating, got %q", reason)
+	}
+
+	if !caps.Rotate(bytes.Repeat([]byte{0x03}, 32), 5*time.Minute) {
+		t.Fatalf("expected second rotation to apply")
+	}
+	if _, reason := caps.Inspect(token, requirement); reason != auth.ReasonSignature {
+		t.Fatalf("expected retired secret to fail signature, got %q", reason)
+	}
+	if caps.Rotate([]byte("short"), 5*time.Minute) {
+		t.Fatalf("expected short secret rotation to be refused")
+	}
+}
+
 func TestCapabilityReplayProtection(t *testing.T) {
 	server := newSessionTestServer(&stubStorage{})
 	createResp, claimResp, _, initResp, receiverToken := setupTransferFixture(t, server, 4)
@@ -326,7 +371,7 @@ func TestAllowlistLogsDoNotIncludeTokens(t *testing.T) {
 	server := NewServer(Dependencies{
 		Config:       testConfig(),
 		Store:        &stubStorage{},
-		Logger:       log.New(&buf, "", 0),
+		Logger:       logging.New(&buf, logging.FormatText, slog.LevelDebug),
 		Capabilities: newTestCapabilities(),
 	})
 	receiverPubKeyB64 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x01}, 32))
@@ -353,6 +398,260 @@ func TestAllowlistLogsDoNotIncludeTokens(t *testing.T) {
 	}
 }
 
+func TestCapabilityIntrospectionDisabledByDefault(t *testing.T) {
+	server := newSessionTestServer(&stubStorage{})
+	createResp, _, _, initResp, _ := setupTransferFixture(t, server, 4)
+
+	rec := introspectCapabilityRecorder(t, server, capabilityIntrospectRequest{
+		Token:      initResp.UploadToken,
+		SessionID:  createResp.SessionID,
+		TransferID: initResp.TransferID,
+	})
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected introspection to be disabled, got %d", rec.Code)
+	}
+}
+
+func TestCapabilityIntrospectionExplainsFailures(t *testing.T) {
+	cfg := testConfig()
+	cfg.DebugCapabilityIntrospection = true
+	server := NewServer(Dependencies{
+		Config:       cfg,
+		Store:        &stubStorage{},
+		Capabilities: newTestCapabilities(),
+	})
+	createResp, claimResp, _, initResp, receiverToken := setupTransferFixture(t, server, 4)
+	senderPubKeyB64 := base64.StdEncoding.EncodeToString([]byte("pubkey"))
+	expiredToken := issueCapabilityToken(t, server, auth.IssueSpec{
+		Scope:             auth.ScopeTransferReceive,
+		TTL:               -time.Minute,
+		SessionID:         createResp.SessionID,
+		ClaimID:           claimResp.ClaimID,
+		TransferID:        initResp.TransferID,
+		PeerID:            createResp.ReceiverPubKeyB64,
+		SenderPubKeyB64:   senderPubKeyB64,
+		ReceiverPubKeyB64: createResp.ReceiverPubKeyB64,
+		Visibility:        auth.VisibilityE2E,
+	})
+
+	cases := []struct {
+		name   string
+		req    capabilityIntrospectRequest
+		reason string
+	}{
+		{
+			name: "valid",
+			req: capabilityIntrospectRequest{
+				Token:      initResp.UploadToken,
+				Scope:      auth.ScopeTransferSend,
+				Route:      "/v1/transfer/chunk",
+				SessionID:  createResp.SessionID,
+				TransferID: initResp.TransferID,
+			},
+		},
+		{
+			name:   "malformed",
+			req:    capabilityIntrospectRequest{Token: "not-a-token"},
+			reason: auth.ReasonMalformed,
+		},
+		{
+			name:   "expired",
+			req:    capabilityIntrospectRequest{Token: expiredToken, Scope: auth.ScopeTransferReceive},
+			reason: auth.ReasonExpired,
+		},
+		{
+			name:   "scope",
+			req:    capabilityIntrospectRequest{Token: initResp.UploadToken, Scope: auth.ScopeTransferReceive},
+			reason: auth.ReasonScope,
+		},
+		{
+			name:   "route",
+			req:    capabilityIntrospectRequest{Token: initResp.UploadToken, Route: "/v1/transfer/manifest"},
+			reason: auth.ReasonRoute,
+		},
+		{
+			name:   "session",
+			req:    capabilityIntrospectRequest{Token: initResp.UploadToken, SessionID: "other"},
+			reason: auth.ReasonSession,
+		},
+		{
+			name: "manifest hash",
+			req: capabilityIntrospectRequest{
+				Token:        initResp.UploadToken,
+				TransferID:   initResp.TransferID,
+				ManifestHash: "wrong",
+			},
+			reason: auth.ReasonManifestHash,
+		},
+		{
+			name: "max bytes",
+			req: capabilityIntrospectRequest{
+				Token:      initResp.UploadToken,
+				TransferID: initResp.TransferID,
+				MaxBytes:   99,
+			},
+			reason: auth.ReasonMaxBytes,
+		},
+		{
+			name: "request bytes",
+			req: capabilityIntrospectRequest{
+				Token:        receiverToken,
+				RequestBytes: 10,
+			},
+			reason: auth.ReasonRequestBytes,
+		},
+	}
+	for _, tc := range cases {
+		resp := introspectCapability(t, server, tc.req)
+		if resp.Reason != tc.reason {
+			t.Fatalf("%s: expected reason %q got %q", tc.name, tc.reason, resp.Reason)
+		}
+		if resp.Valid != (tc.reason == "") {
+			t.Fatalf("%s: expected valid=%v", tc.name, tc.reason == "")
+		}
+	}
+
+	server.capabilities.RevokeTransfer(initResp.TransferID)
+	resp := introspectCapability(t, server, capabilityIntrospectRequest{
+		Token:      initResp.UploadToken,
+		TransferID: initResp.TransferID,
+	})
+	if resp.Reason != auth.ReasonRevoked {
+		t.Fatalf("expected revoked reason got %q", resp.Reason)
+	}
+}
+
+func TestCapabilityIntrospectionDoesNotConsumeSingleUse(t *testing.T) {
+	cfg := testConfig()
+	cfg.DebugCapabilityIntrospection = true
+	server := NewServer(Dependencies{
+		Config:       cfg,
+		Store:        &stubStorage{},
+		Capabilities: newTestCapabilities(),
+	})
+	createResp, _, _, initResp, receiverToken := setupTransferFixture(t, server, 4)
+	uploadChunk(t, server, createResp.SessionID, initResp.TransferID, initResp.UploadToken, 0, []byte("data"))
+	finalizeTransfer(t, server, createResp.SessionID, initResp.TransferID, initResp.UploadToken)
+	downloadResp := mintDownloadToken(t, server, downloadTokenRequest{
+		SessionID:     createResp.SessionID,
+		TransferID:    initResp.TransferID,
+		TransferToken: receiverToken,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp := introspectCapability(t, server, capabilityIntrospectRequest{
+			Token:      downloadResp.DownloadToken,
+			Scope:      auth.ScopeTransferDownload,
+			SessionID:  createResp.SessionID,
+			TransferID: initResp.TransferID,
+			SingleUse:  true,
+		})
+		if !resp.Valid {
+			t.Fatalf("expected download token to remain valid, got %q", resp.Reason)
+		}
+	}
+	rec := downloadRangeRecorder(t, server, createResp.SessionID, initResp.TransferID, downloadResp.DownloadToken, 0, 3)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("expected download 206 got %d", rec.Code)
+	}
+	resp := introspectCapability(t, server, capabilityIntrospectRequest{
+		Token:     downloadResp.DownloadToken,
+		Scope:     auth.ScopeTransferDownload,
+		SingleUse: true,
+	})
+	if resp.Reason != auth.ReasonReplayed {
+		t.Fatalf("expected replayed reason got %q", resp.Reason)
+	}
+}
+
+func TestCapabilityIntrospectionLogsOnlyKnownRoutesAndScopes(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := testConfig()
+	cfg.DebugCapabilityIntrospection = true
+	server := NewServer(Dependencies{
+		Config:       cfg,
+		Store:        &stubStorage{},
+		Logger:       logging.New(&buf, logging.FormatText, slog.LevelDebug),
+		Capabilities: newTestCapabilities(),
+	})
+	createResp, _, _, initResp, _ := setupTransferFixture(t, server, 4)
+
+	buf.Reset()
+	introspectCapability(t, server, capabilityIntrospectRequest{
+		Token: initResp.UploadToken,
+		Scope: "attacker-chosen-scope",
+		Route: "/v1/transfer/chunk",
+	})
+	logs := buf.String()
+	if !strings.Contains(logs, "route=/v1/transfer/chunk") || !strings.Contains(logs, "scope="+auth.ScopeTransferSend) {
+		t.Fatalf("expected the matched route and the token's scope in logs, got %q", logs)
+	}
+
+	buf.Reset()
+	introspectCapability(t, server, capabilityIntrospectRequest{
+		Token: "not-a-token",
+		Scope: "attacker-chosen-scope",
+		Route: "/v1/" + createResp.SessionID,
+	})
+	logs = buf.String()
+	if !strings.Contains(logs, "route=unknown") {
+		t.Fatalf("expected unmatched routes to be logged as unknown, got %q", logs)
+	}
+	if strings.Contains(logs, "attacker-chosen-scope") || strings.Contains(logs, createResp.SessionID) {
+		t.Fatalf("expected client-supplied route and scope to stay out of logs, got %q", logs)
+	}
+}
+
+func TestCapabilityRejectionLogsReason(t *testing.T) {
+	var buf bytes.Buffer
+	server := NewServer(Dependencies{
+		Config:       testConfig(),
+		Store:        &stubStorage{},
+		Logger:       logging.New(&buf, logging.FormatText, slog.LevelDebug),
+		Capabilities: newTestCapabilities(),
+	})
+	createResp, _, _, initResp, _ := setupTransferFixture(t, server, 4)
+	buf.Reset()
+
+	rec := manifestRequestRecorder(t, server, createResp.SessionID, initResp.TransferID, initResp.UploadToken)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected indistinguishable 404 got %d", rec.Code)
+	}
+	logs := buf.String()
+	if !strings.Contains(logs, "event=capability_rejected") || !strings.Contains(logs, "reason="+auth.ReasonScope) {
+		t.Fatalf("expected scope rejection reason in logs, got %q", logs)
+	}
+	if strings.Contains(logs, initResp.UploadToken) {
+		t.Fatalf("expected logs to exclude token")
+	}
+}
+
+func introspectCapabilityRecorder(t *testing.T, server *Server, reqBody capabilityIntrospectRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, err := json.Marshal(reqBody)
+	if err != nil {
+		t.Fatalf("marshal introspect request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/debug/capability", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	server.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func introspectCapability(t *testing.T, server *Server, reqBody capabilityIntrospectRequest) capabilityIntrospectResponse {
+	t.Helper()
+	rec := introspectCapabilityRecorder(t, server, reqBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected introspect 200 got %d", rec.Code)
+	}
+	var payload capabilityIntrospectResponse
+	if err := json.NewDecoder(rec.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode introspect response: %v", err)
+	}
+	return payload
+}
+
 func testConfig() config.Config {
 	return config.Config{
 		Address:               ":0",
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"universaldrop/internal/capacity"
+	"universaldrop/internal/logging"
+)
+
+func (s *Server) rejectForCapacity(w http.ResponseWriter, err error) {
+	reason := "statfs_failed"
+	switch {
+	case errors.Is(err, capacity.ErrLowWatermark):
+		reason = "low_watermark"
+	case errors.Is(err, capacity.ErrInsufficientSpace):
+		reason = "insufficient_space"
+	}
+	logging.Allowlist(s.logger, map[string]string{
+		"event":  "storage_capacity_rejected",
+		"reason": reason,
+	})
+	if reason == "statfs_failed" {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusInsufficientStorage, map[string]string{"error": "insufficient_storage"})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"universaldrop/internal/auth"
+)
+
+type capabilityIntrospectRequest struct {
+	Token        string `json:"token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	Route        string `json:"route,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
+	ClaimID      string `json:"claim_id,omitempty"`
+	TransferID   string `json:"transfer_id,omitempty"`
+	ManifestHash string `json:"manifest_hash,omitempty"`
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
+	RequestBytes int64  `json:"request_bytes,omitempty"`
+	SingleUse    bool   `json:"single_use,omitempty"`
+}
+
+type capabilityIntrospectResponse struct {
+	Valid     bool   `json:"valid"`
+	Reason    string `json:"reason,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	SingleUse bool   `json:"single_use,omitempty"`
+}
+
+func (s *Server) knownRoute(path string) string {
+	if path == "" {
+		return ""
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut} {
+		rctx := chi.NewRouteContext()
+		if s.mux.Match(rctx, method, path) {
+			return rctx.RoutePattern()
+		}
+	}
+	return "unknown"
+}
+
+func (s *Server) handleDebugCapability(w http.ResponseWriter, r *http.Request) {
+	var req capabilityIntrospectRequest
+	if err := decodeJSON(w, r, &req, 16<<10); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	token := req.Token
+	if token == "" {
+		token = bearerToken(r)
+	}
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	requirement := auth.Requirement{
+		Scope:        req.Scope,
+		SessionID:    req.SessionID,
+		ClaimID:      req.ClaimID,
+		TransferID:   req.TransferID,
+		ManifestHash: req.ManifestHash,
+		MaxBytes:     req.MaxBytes,
+		RequestBytes: req.RequestBytes,
+		Route:        req.Route,
+		SingleUse:    req.SingleUse,
+	}
+	if req.TransferID != "" && (requirement.ManifestHash == "" || requirement.MaxBytes == 0) {
+		if meta, err := s.store.GetTransferMeta(r.Context(), req.TransferID); err == nil {
+			if requirement.ManifestHash == "" {
+				requirement.ManifestHash = meta.ManifestHash
+			}
+			if requirement.MaxBytes == 0 {
+				requirement.MaxBytes = meta.TotalBytes
+			}
+		}
+	}
+
+	claims, reason := s.capabilities.Inspect(token, requirement)
+	s.recordSecurityEvent(map[string]string{
+		"event":  "capability_introspected",
+		"route":  s.knownRoute(req.Route),
+		"scope":  claims.Scope,
+		"reason": reason,
+	})
+
+	response := capabilityIntrospectResponse{
+		Valid:     reason == "",
+		Reason:    reason,
+		Scope:     claims.Scope,
+		SingleUse: claims.SingleUse,
+	}
+	if claims.Exp > 0 {
+		response.ExpiresAt = time.Unix(claims.Exp, 0).UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
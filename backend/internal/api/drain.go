@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"universaldrop/internal/logging"
+)
+
+const drainRetryAfter = "30"
+
+func (s *Server) Drain() {
+	if s.draining.Swap(true) {
+		return
+	}
+	logging.Allowlist(s.logger, map[string]string{
+		"event": "drain_started",
+		"count": strconv.FormatInt(s.inflight.Load(), 10),
+	})
+}
+
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+func (s *Server) InFlightTransfers() int64 {
+	return s.inflight.Load()
+}
+
+func (s *Server) refuseWhenDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Retry-After", drainRetryAfter)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "draining"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"universaldrop/internal/domain"
 	"universaldrop/internal/logging"
 	"universaldrop/internal/storage"
+	"universaldrop/internal/tracing"
 	"universaldrop/internal/transfer"
 )
 
@@ -167,7 +168,7 @@ func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
-	ttl := s.cfg.ClaimTokenTTL
+	ttl := s.currentConfig().ClaimTokenTTL
 	if ttl == 0 || ttl < config.MinClaimTokenTTL || ttl > config.MaxClaimTokenTTL {
 		ttl = config.DefaultClaimTokenTTL
 	}
@@ -196,12 +197,18 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
-	ip := clientIP(r)
-	if !s.quotas.AllowSession(ip, "", s.cfg.Quotas.SessionsPerDayIP, s.cfg.Quotas.SessionsPerDaySession) {
-		logging.Allowlist(s.logger, map[string]string{
-			"event":   "quota_blocked",
-			"scope":   "session_create",
-			"ip_hash": anonHash(ip),
+	ip := s.clientKeys(r)
+	subject := quotaSubject{ip: ip, receiver: req.ReceiverPubKeyB64}
+	limits := s.currentConfig().Quotas
+	if !s.quotas.AllowSession(r.Context(), subject, limits) {
+		s.metrics.IncQuotaBlocked("session_create")
+		s.recordSecurityEvent(map[string]string{
+			"event":                 "quota_blocked",
+			"scope":                 "session_create",
+			"ip_hash":               anonHash(ip.addr),
+			"ip_prefix_hash":        anonHash(ip.prefix),
+			"ip_coarse_prefix_hash": anonHash(ip.coarse),
+			"receiver_key_hash":     anonHash(req.ReceiverPubKeyB64),
 		})
 		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "quota_exceeded"})
 		return
@@ -228,7 +235,7 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 			ReceiverPubKeyB64: receiverPubKey,
 			PeerID:            receiverPubKey,
 			Visibility:        auth.VisibilityE2E,
-			AllowedRoutes:     []string{"/v1/session/claim", "/v1/session/poll"},
+			AllowedRoutes:     []string{"/v1/session/claim", "/v1/session/poll", "/v1/quota"},
 			SingleUse:         true,
 		})
 		if err != nil {
@@ -241,7 +248,7 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 			ReceiverPubKeyB64: receiverPubKey,
 			PeerID:            receiverPubKey,
 			Visibility:        auth.VisibilityE2E,
-			AllowedRoutes:     []string{"/v1/session/approve"},
+			AllowedRoutes:     []string{"/v1/session/approve", "/v1/quota"},
 			SingleUse:         true,
 		})
 		if err != nil {
@@ -264,6 +271,7 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
+		s.quotas.RefundSession(r.Context(), subject, limits)
 		logging.Allowlist(s.logger, map[string]string{
 			"event": "session_create_failed",
 			"error": "storage_error",
@@ -277,7 +285,7 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	values.Set("claim_token", claimToken)
 	qrPayload := "udrop://claim?" + values.Encode()
 
-	logging.Allowlist(s.logger, map[string]string{
+	s.recordSecurityEvent(map[string]string{
 		"event":           "session_created",
 		"session_id_hash": anonHash(session.ID),
 	})
@@ -327,19 +335,39 @@ func (s *Server) handleClaimSession(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
-	if _, ok := s.requireCapability(r, req.ClaimToken, auth.Requirement{
+	claimCap, ok := s.inspectCapability(r, req.ClaimToken, auth.Requirement{
 		Scope:             auth.ScopeSessionClaim,
 		SessionID:         session.ID,
 		ReceiverPubKeyB64: session.ReceiverPubKeyB64,
 		Visibility:        auth.VisibilityE2E,
 		SingleUse:         true,
-	}); !ok {
+	})
+	if !ok {
+		writeIndistinguishable(w)
+		return
+	}
+	subject := quotaSubject{sender: req.SenderPubKeyB64}
+	limits := s.currentConfig().Quotas
+	if !s.quotas.AllowSession(r.Context(), subject, limits) {
+		s.metrics.IncQuotaBlocked("session_claim")
+		s.recordSecurityEvent(map[string]string{
+			"event":           "quota_blocked",
+			"scope":           "session_claim",
+			"session_id_hash": anonHash(session.ID),
+			"sender_key_hash": anonHash(req.SenderPubKeyB64),
+		})
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "quota_exceeded"})
+		return
+	}
+	if !s.consumeCapability(r, claimCap) {
+		s.quotas.RefundSession(r.Context(), subject, limits)
 		writeIndistinguishable(w)
 		return
 	}
 
 	claimID, err := randomBase64(18)
 	if err != nil {
+		s.quotas.RefundSession(r.Context(), subject, limits)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
 		return
 	}
@@ -355,11 +383,12 @@ func (s *Server) handleClaimSession(w http.ResponseWriter, r *http.Request) {
 	session.ClaimTokenUsed = true
 	session.Claims = append(session.Claims, claim)
 	if err := s.store.UpdateSession(r.Context(), session); err != nil {
+		s.quotas.RefundSession(r.Context(), subject, limits)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
 		return
 	}
 
-	logging.Allowlist(s.logger, map[string]string{
+	s.recordSecurityEvent(map[string]string{
 		"event":           "session_claimed",
 		"session_id_hash": anonHash(session.ID),
 		"claim_id_hash":   anonHash(claimID),
@@ -440,7 +469,7 @@ func (s *Server) handleApproveSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !req.Approve {
-		logging.Allowlist(s.logger, map[string]string{
+		s.recordSecurityEvent(map[string]string{
 			"event":           "session_rejected",
 			"session_id_hash": anonHash(session.ID),
 			"claim_id_hash":   anonHash(req.ClaimID),
@@ -465,15 +494,15 @@ func (s *Server) handleApproveSession(w http.ResponseWriter, r *http.Request) {
 
 	transferToken, err := s.capabilities.Issue(auth.IssueSpec{
 		Scope:             auth.ScopeTransferReceive,
-		TTL:               s.cfg.TransferTokenTTL,
+		TTL:               s.currentConfig().TransferTokenTTL,
 		SessionID:         session.ID,
 		ClaimID:           claim.ID,
 		PeerID:            session.ReceiverPubKeyB64,
 		SenderPubKeyB64:   claim.SenderPubKeyB64,
 		ReceiverPubKeyB64: session.ReceiverPubKeyB64,
 		Visibility:        auth.VisibilityE2E,
-		MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
-		AllowedRoutes:     []string{"/v1/transfer/manifest", "/v1/transfer/download_token", "/v1/transfer/receipt"},
+		MaxRateBps:        s.currentConfig().Throttles.TransferBandwidthCapBps,
+		AllowedRoutes:     []string{"/v1/transfer/manifest", "/v1/transfer/download_token", "/v1/transfer/receipt", "/v1/quota"},
 	})
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
@@ -481,21 +510,21 @@ func (s *Server) handleApproveSession(w http.ResponseWriter, r *http.Request) {
 	}
 	p2pToken, err := s.capabilities.Issue(auth.IssueSpec{
 		Scope:             auth.ScopeTransferSignal,
-		TTL:               s.cfg.TransferTokenTTL,
+		TTL:               s.currentConfig().TransferTokenTTL,
 		SessionID:         session.ID,
 		ClaimID:           claim.ID,
 		PeerID:            session.ReceiverPubKeyB64,
 		SenderPubKeyB64:   claim.SenderPubKeyB64,
 		ReceiverPubKeyB64: session.ReceiverPubKeyB64,
 		Visibility:        auth.VisibilityE2E,
-		AllowedRoutes:     []string{"/v1/p2p/offer", "/v1/p2p/answer", "/v1/p2p/ice", "/v1/p2p/ice_config", "/v1/p2p/poll"},
+		AllowedRoutes:     []string{"/v1/p2p/offer", "/v1/p2p/answer", "/v1/p2p/ice", "/v1/p2p/ice_config", "/v1/p2p/poll", "/v1/quota"},
 	})
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
 		return
 	}
 
-	logging.Allowlist(s.logger, map[string]string{
+	s.recordSecurityEvent(map[string]string{
 		"event":           "session_approved",
 		"session_id_hash": anonHash(session.ID),
 		"claim_id_hash":   anonHash(claim.ID),
@@ -570,27 +599,27 @@ func (s *Server) handlePollSession(w http.ResponseWriter, r *http.Request) {
 					if ok {
 						transferToken, _ = s.capabilities.Issue(auth.IssueSpec{
 							Scope:             auth.ScopeTransferInit,
-							TTL:               s.cfg.TransferTokenTTL,
+							TTL:               s.currentConfig().TransferTokenTTL,
 							SessionID:         session.ID,
 							ClaimID:           claimID,
 							PeerID:            claim.SenderPubKeyB64,
 							SenderPubKeyB64:   claim.SenderPubKeyB64,
 							ReceiverPubKeyB64: session.ReceiverPubKeyB64,
 							Visibility:        auth.VisibilityE2E,
-							MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
+							MaxRateBps:        s.currentConfig().Throttles.TransferBandwidthCapBps,
 							AllowedRoutes:     []string{"/v1/transfer/init"},
 							SingleUse:         true,
 						})
 						p2pToken, _ = s.capabilities.Issue(auth.IssueSpec{
 							Scope:             auth.ScopeTransferSignal,
-							TTL:               s.cfg.TransferTokenTTL,
+							TTL:               s.currentConfig().TransferTokenTTL,
 							SessionID:         session.ID,
 							ClaimID:           claimID,
 							PeerID:            claim.SenderPubKeyB64,
 							SenderPubKeyB64:   claim.SenderPubKeyB64,
 							ReceiverPubKeyB64: session.ReceiverPubKeyB64,
 							Visibility:        auth.VisibilityE2E,
-							AllowedRoutes:     []string{"/v1/p2p/offer", "/v1/p2p/answer", "/v1/p2p/ice", "/v1/p2p/ice_config", "/v1/p2p/poll"},
+							AllowedRoutes:     []string{"/v1/p2p/offer", "/v1/p2p/answer", "/v1/p2p/ice", "/v1/p2p/ice_config", "/v1/p2p/poll", "/v1/quota"},
 						})
 					}
 				}
@@ -641,7 +670,7 @@ func (s *Server) handlePollSession(w http.ResponseWriter, r *http.Request) {
 			if err == nil {
 				transferToken, _ := s.capabilities.Issue(auth.IssueSpec{
 					Scope:             auth.ScopeTransferReceive,
-					TTL:               s.cfg.TransferTokenTTL,
+					TTL:               s.currentConfig().TransferTokenTTL,
 					SessionID:         session.ID,
 					ClaimID:           claim.ID,
 					TransferID:        claim.TransferID,
@@ -651,8 +680,8 @@ func (s *Server) handlePollSession(w http.ResponseWriter, r *http.Request) {
 					ManifestHash:      meta.ManifestHash,
 					Visibility:        auth.VisibilityE2E,
 					MaxBytes:          meta.TotalBytes,
-					MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
-					AllowedRoutes:     []string{"/v1/transfer/manifest", "/v1/transfer/download_token", "/v1/transfer/receipt"},
+					MaxRateBps:        s.currentConfig().Throttles.TransferBandwidthCapBps,
+					AllowedRoutes:     []string{"/v1/transfer/manifest", "/v1/transfer/download_token", "/v1/transfer/receipt", "/v1/quota"},
 				})
 				summary.TransferToken = transferToken
 			}
@@ -791,9 +820,9 @@ func sasStateForClaims(claims []sessionPollClaimSummary) string {
 }
 
 func (s *Server) downloadTokenTTL() time.Duration {
-	ttl := s.cfg.DownloadTokenTTL
+	ttl := s.currentConfig().DownloadTokenTTL
 	if ttl <= 0 {
-		ttl = s.cfg.TransferTokenTTL
+		ttl = s.currentConfig().TransferTokenTTL
 	}
 	if ttl <= 0 {
 		ttl = config.DefaultTransferTokenTTL
@@ -824,7 +853,7 @@ func (s *Server) handleGetTransferManifest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	logging.Allowlist(s.logger, map[string]string{
+	s.recordSecurityEvent(map[string]string{
 		"event":            "transfer_manifest_read",
 		"transfer_id_hash": anonHash(transferID),
 		"session_id_hash":  anonHash(session.ID),
@@ -852,6 +881,10 @@ func (s *Server) handleInitTransfer(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
+	if err := s.capacity.Check(r.Context(), req.TotalBytes); err != nil {
+		s.rejectForCapacity(w, err)
+		return
+	}
 	session := authz.Session
 	claimID := authz.Claim.ID
 
@@ -877,30 +910,35 @@ func (s *Server) handleInitTransfer(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	ip := clientIP(r)
-	if !s.quotas.BeginTransfer(
-		transferID,
-		ip,
-		session.ID,
-		s.cfg.Quotas.TransfersPerDayIP,
-		s.cfg.Quotas.TransfersPerDaySession,
-		s.cfg.Quotas.ConcurrentTransfersIP,
-		s.cfg.Quotas.ConcurrentTransfersSession,
-	) {
+	ip := s.clientKeys(r)
+	subject := quotaSubject{ip: ip, session: session.ID, sender: authz.Claim.SenderPubKeyB64, receiver: session.ReceiverPubKeyB64}
+	if !s.quotas.BeginTransfer(r.Context(), transferID, subject, s.currentConfig().Quotas) {
 		_ = s.transfers.DeleteOnReceipt(r.Context(), transferID)
-		logging.Allowlist(s.logger, map[string]string{
-			"event":            "quota_blocked",
-			"scope":            "transfer_create",
-			"ip_hash":          anonHash(ip),
-			"session_id_hash":  anonHash(session.ID),
-			"transfer_id_hash": anonHash(transferID),
+		s.metrics.IncQuotaBlocked("transfer_create")
+		s.recordSecurityEvent(map[string]string{
+			"event":                 "quota_blocked",
+			"scope":                 "transfer_create",
+			"ip_hash":               anonHash(ip.addr),
+			"ip_prefix_hash":        anonHash(ip.prefix),
+			"ip_coarse_prefix_hash": anonHash(ip.coarse),
+			"session_id_hash":       anonHash(session.ID),
+			"transfer_id_hash":      anonHash(transferID),
+			"sender_key_hash":       anonHash(subject.sender),
+			"receiver_key_hash":     anonHash(subject.receiver),
 		})
 		writeIndistinguishable(w)
 		return
 	}
+	if err := s.capacity.Reserve(r.Context(), transferID, req.TotalBytes, expiresAt); err != nil {
+		s.quotas.EndTransfer(r.Context(), transferID)
+		_ = s.transfers.DeleteOnReceipt(r.Context(), transferID)
+		s.rejectForCapacity(w, err)
+		return
+	}
 
 	if err := s.setTransferID(r.Context(), session, claimID, transferID); err != nil {
-		s.quotas.EndTransfer(transferID)
+		s.capacity.Release(transferID)
+		s.quotas.EndTransfer(r.Context(), transferID)
 		_ = s.transfers.DeleteOnReceipt(r.Context(), transferID)
 		writeIndistinguishable(w)
 		return
@@ -913,7 +951,7 @@ func (s *Server) handleInitTransfer(w http.ResponseWriter, r *http.Request) {
 	}
 	uploadToken, err := s.capabilities.Issue(auth.IssueSpec{
 		Scope:             auth.ScopeTransferSend,
-		TTL:               s.cfg.TransferTokenTTL,
+		TTL:               s.currentConfig().TransferTokenTTL,
 		SessionID:         session.ID,
 		ClaimID:           claimID,
 		TransferID:        transferID,
@@ -923,8 +961,8 @@ func (s *Server) handleInitTransfer(w http.ResponseWriter, r *http.Request) {
 		ManifestHash:      manifestHash,
 		Visibility:        auth.VisibilityE2E,
 		MaxBytes:          req.TotalBytes,
-		MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
-		AllowedRoutes:     []string{"/v1/transfer/chunk", "/v1/transfer/finalize", "/v1/transfer/scan_init", "/v1/transfer/scan_chunk", "/v1/transfer/scan_finalize"},
+		MaxRateBps:        s.currentConfig().Throttles.TransferBandwidthCapBps,
+		AllowedRoutes:     []string{"/v1/transfer/chunk", "/v1/transfer/finalize", "/v1/transfer/scan_init", "/v1/transfer/scan_chunk", "/v1/transfer/scan_finalize", "/v1/quota"},
 	})
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
@@ -948,39 +986,60 @@ func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ip := clientIP(r)
+	ip := s.clientKeys(r)
 
-	r.Body = http.MaxBytesReader(w, r.Body, 32<<20)
-	data, err := io.ReadAll(r.Body)
-	if err != nil || len(data) == 0 {
+	token := bearerToken(r)
+	authz, ok := s.authorizeTransfer(r, sessionID, transferID, token, auth.ScopeTransferSend, max(r.ContentLength, 0), false)
+	if !ok {
 		writeIndistinguishable(w)
 		return
 	}
-	token := bearerToken(r)
-	authz, ok := s.authorizeTransfer(r, sessionID, transferID, token, auth.ScopeTransferSend, int64(len(data)), false)
+	reserved := r.ContentLength
+	if reserved <= 0 || reserved > maxChunkBytes {
+		reserved = maxChunkBytes
+	}
+	release, ok := s.reserveInflight(w, r, reserved)
 	if !ok {
+		return
+	}
+	defer release()
+	r.Body = http.MaxBytesReader(w, r.Body, maxChunkBytes)
+	_, readSpan := tracing.Start(r.Context(), "upload.read_body")
+	data, err := io.ReadAll(s.bandwidth.Reader(r.Context(), transferID, authz.Cap.MaxRateBps, r.Body))
+	readSpan.SetAttribute("bytes", strconv.Itoa(len(data)))
+	if err != nil {
+		readSpan.SetError("read_failed")
+	}
+	readSpan.End()
+	if err != nil || len(data) == 0 {
+		writeIndistinguishable(w)
+		return
+	}
+	if !s.checkClaims(r, authz.Cap, auth.Requirement{RequestBytes: int64(len(data))}) {
 		writeIndistinguishable(w)
 		return
 	}
 	session := authz.Session
-	if !s.quotas.AddBytes(ip, session.ID, int64(len(data)), s.cfg.Quotas.BytesPerDayIP, s.cfg.Quotas.BytesPerDaySession) {
-		logging.Allowlist(s.logger, map[string]string{
-			"event":            "quota_blocked",
-			"scope":            "upload_bytes",
-			"ip_hash":          anonHash(ip),
-			"session_id_hash":  anonHash(session.ID),
-			"transfer_id_hash": anonHash(transferID),
+	subject := quotaSubject{ip: ip, session: session.ID, sender: authz.Claim.SenderPubKeyB64, receiver: session.ReceiverPubKeyB64}
+	if !s.quotas.AddBytes(r.Context(), subject, int64(len(data)), s.currentConfig().Quotas) {
+		s.metrics.IncQuotaBlocked("upload_bytes")
+		s.recordSecurityEvent(map[string]string{
+			"event":                 "quota_blocked",
+			"scope":                 "upload_bytes",
+			"ip_hash":               anonHash(ip.addr),
+			"ip_prefix_hash":        anonHash(ip.prefix),
+			"ip_coarse_prefix_hash": anonHash(ip.coarse),
+			"session_id_hash":       anonHash(session.ID),
+			"transfer_id_hash":      anonHash(transferID),
+			"sender_key_hash":       anonHash(subject.sender),
+			"receiver_key_hash":     anonHash(subject.receiver),
 		})
 		writeIndistinguishable(w)
 		return
 	}
-	waitTransfer := s.throttles.ReserveTransfer(transferID, int64(len(data)))
-	waitGlobal := s.throttles.ReserveGlobal(int64(len(data)))
-	if delay := maxDuration(waitTransfer, waitGlobal); delay > 0 {
-		time.Sleep(delay)
-	}
 
-	if err := s.transfers.AcceptChunk(r.Context(), transferID, offset, data); err != nil {
+	written, err := s.transfers.AcceptChunk(r.Context(), transferID, offset, data)
+	if err != nil {
 		if errors.Is(err, transfer.ErrChunkConflict) {
 			writeJSON(w, http.StatusConflict, map[string]string{"error": "chunk_conflict"})
 			return
@@ -988,6 +1047,8 @@ func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
+	s.capacity.Consume(transferID, written)
+	s.metrics.ObserveChunkSize(len(data))
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
@@ -1014,11 +1075,13 @@ func (s *Server) handleFinalizeTransfer(w http.ResponseWriter, r *http.Request)
 		writeIndistinguishable(w)
 		return
 	}
+	s.capacity.Release(req.TransferID)
 
 	if err := s.markTransferReady(r.Context(), session, claimID, req.TransferID); err != nil {
 		writeIndistinguishable(w)
 		return
 	}
+	s.metrics.ObserveTransferSize(authz.Meta.TotalBytes)
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -1058,7 +1121,7 @@ func (s *Server) handleDownloadToken(w http.ResponseWriter, r *http.Request) {
 		ManifestHash:      authz.Meta.ManifestHash,
 		Visibility:        auth.VisibilityE2E,
 		MaxBytes:          authz.Meta.TotalBytes,
-		MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
+		MaxRateBps:        s.currentConfig().Throttles.TransferBandwidthCapBps,
 		AllowedRoutes:     []string{"/v1/transfer/download"},
 		SingleUse:         true,
 	})
@@ -1066,7 +1129,7 @@ func (s *Server) handleDownloadToken(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
 		return
 	}
-	logging.Allowlist(s.logger, map[string]string{
+	s.recordSecurityEvent(map[string]string{
 		"event":            "download_token_issued",
 		"session_id_hash":  anonHash(session.ID),
 		"claim_id_hash":    anonHash(claim.ID),
@@ -1093,13 +1156,13 @@ func (s *Server) handleDownloadTransfer(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	ip := clientIP(r)
+	ip := s.clientKeys(r)
 	downloadToken := headerValue(r, "download_token")
 	if downloadToken == "" {
 		writeIndistinguishable(w)
 		return
 	}
-	capClaims, ok := s.requireCapability(r, downloadToken, auth.Requirement{
+	capClaims, ok := s.inspectCapability(r, downloadToken, auth.Requirement{
 		Scope:      auth.ScopeTransferDownload,
 		SessionID:  sessionID,
 		TransferID: transferID,
@@ -1128,7 +1191,7 @@ func (s *Server) handleDownloadTransfer(w http.ResponseWriter, r *http.Request)
 		writeIndistinguishable(w)
 		return
 	}
-	if !s.capabilities.ValidateClaims(capClaims, auth.Requirement{
+	if !s.checkClaims(r, capClaims, auth.Requirement{
 		ClaimID:           claim.ID,
 		TransferID:        transferID,
 		SenderPubKeyB64:   claim.SenderPubKeyB64,
@@ -1137,13 +1200,25 @@ func (s *Server) handleDownloadTransfer(w http.ResponseWriter, r *http.Request)
 		Visibility:        auth.VisibilityE2E,
 		MaxBytes:          meta.TotalBytes,
 		RequestBytes:      length,
-		MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
 		Route:             routePattern(r),
 	}) {
 		writeIndistinguishable(w)
 		return
 	}
 
+	reserved := length
+	if meta.TotalBytes > 0 && reserved > meta.TotalBytes {
+		reserved = meta.TotalBytes
+	}
+	release, ok := s.reserveInflight(w, r, reserved)
+	if !ok {
+		return
+	}
+	defer release()
+	if !s.consumeCapability(r, capClaims) {
+		writeIndistinguishable(w)
+		return
+	}
 	data, err := s.transfers.ReadRange(r.Context(), transferID, start, length)
 	if err != nil {
 		writeIndistinguishable(w)
@@ -1153,22 +1228,23 @@ func (s *Server) handleDownloadTransfer(w http.ResponseWriter, r *http.Request)
 		writeIndistinguishable(w)
 		return
 	}
-	if !s.quotas.AddBytes(ip, session.ID, int64(len(data)), s.cfg.Quotas.BytesPerDayIP, s.cfg.Quotas.BytesPerDaySession) {
-		logging.Allowlist(s.logger, map[string]string{
-			"event":            "quota_blocked",
-			"scope":            "download_bytes",
-			"ip_hash":          anonHash(ip),
-			"session_id_hash":  anonHash(session.ID),
-			"transfer_id_hash": anonHash(transferID),
+	subject := quotaSubject{ip: ip, session: session.ID, sender: claim.SenderPubKeyB64, receiver: session.ReceiverPubKeyB64}
+	if !s.quotas.AddBytes(r.Context(), subject, int64(len(data)), s.currentConfig().Quotas) {
+		s.metrics.IncQuotaBlocked("download_bytes")
+		s.recordSecurityEvent(map[string]string{
+			"event":                 "quota_blocked",
+			"scope":                 "download_bytes",
+			"ip_hash":               anonHash(ip.addr),
+			"ip_prefix_hash":        anonHash(ip.prefix),
+			"ip_coarse_prefix_hash": anonHash(ip.coarse),
+			"session_id_hash":       anonHash(session.ID),
+			"transfer_id_hash":      anonHash(transferID),
+			"sender_key_hash":       anonHash(subject.sender),
+			"receiver_key_hash":     anonHash(subject.receiver),
 		})
 		writeIndistinguishable(w)
 		return
 	}
-	waitTransfer := s.throttles.ReserveTransfer(transferID, int64(len(data)))
-	waitGlobal := s.throttles.ReserveGlobal(int64(len(data)))
-	if delay := maxDuration(waitTransfer, waitGlobal); delay > 0 {
-		time.Sleep(delay)
-	}
 
 	end := start + int64(len(data)) - 1
 	totalBytes := meta.TotalBytes
@@ -1180,7 +1256,12 @@ func (s *Server) handleDownloadTransfer(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(totalBytes, 10))
 	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(data)), 10))
 	w.WriteHeader(http.StatusPartialContent)
-	_, _ = w.Write(data)
+	_, writeSpan := tracing.Start(r.Context(), "download.write_body")
+	defer writeSpan.End()
+	writeSpan.SetAttribute("bytes", strconv.Itoa(len(data)))
+	if _, err := s.bandwidth.Writer(r.Context(), transferID, capClaims.MaxRateBps, w).Write(data); err != nil {
+		writeSpan.SetError("write_failed")
+	}
 }
 
 func (s *Server) handleTransferReceipt(w http.ResponseWriter, r *http.Request) {
@@ -1210,12 +1291,13 @@ func (s *Server) handleTransferReceipt(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
-	s.quotas.EndTransfer(req.TransferID)
-	s.throttles.ForgetTransfer(req.TransferID)
+	s.quotas.EndTransfer(r.Context(), req.TransferID)
+	s.capacity.Release(req.TransferID)
+	s.bandwidth.Forget(req.TransferID)
 	s.capabilities.RevokeTransfer(req.TransferID)
 	s.metrics.IncTransfersCompleted()
 
-	logging.Allowlist(s.logger, map[string]string{
+	s.recordSecurityEvent(map[string]string{
 		"event":            "transfer_receipt",
 		"session_id_hash":  anonHash(session.ID),
 		"claim_id_hash":    anonHash(claimID),
@@ -1296,7 +1378,7 @@ func (s *Server) handleScanChunk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxScanBytes)
+	r.Body = http.MaxBytesReader(w, r.Body, s.currentConfig().MaxScanBytes)
 	data, err := io.ReadAll(r.Body)
 	if err != nil || len(data) == 0 {
 		writeIndistinguishable(w)
@@ -1307,13 +1389,19 @@ func (s *Server) handleScanChunk(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
-	ip := clientIP(r)
-	if !s.quotas.AddBytes(ip, scanSession.SessionID, int64(len(data)), s.cfg.Quotas.BytesPerDayIP, s.cfg.Quotas.BytesPerDaySession) {
-		logging.Allowlist(s.logger, map[string]string{
-			"event":           "quota_blocked",
-			"scope":           "scan_bytes",
-			"ip_hash":         anonHash(ip),
-			"session_id_hash": anonHash(scanSession.SessionID),
+	ip := s.clientKeys(r)
+	subject := quotaSubject{ip: ip, session: scanSession.SessionID, sender: authz.Claim.SenderPubKeyB64, receiver: authz.Session.ReceiverPubKeyB64}
+	if !s.quotas.AddBytes(r.Context(), subject, int64(len(data)), s.currentConfig().Quotas) {
+		s.metrics.IncQuotaBlocked("scan_bytes")
+		s.recordSecurityEvent(map[string]string{
+			"event":                 "quota_blocked",
+			"scope":                 "scan_bytes",
+			"ip_hash":               anonHash(ip.addr),
+			"ip_prefix_hash":        anonHash(ip.prefix),
+			"ip_coarse_prefix_hash": anonHash(ip.coarse),
+			"session_id_hash":       anonHash(scanSession.SessionID),
+			"sender_key_hash":       anonHash(subject.sender),
+			"receiver_key_hash":     anonHash(subject.receiver),
 		})
 		writeIndistinguishable(w)
 		return
@@ -1353,11 +1441,12 @@ func (s *Server) handleScanFinalize(w http.ResponseWriter, r *http.Request) {
 	session := authz.Session
 	claimID := authz.Claim.ID
 
-	status, err := s.transfers.FinalizeScan(r.Context(), req.ScanID, s.scanner, s.cfg.MaxScanBytes, s.cfg.MaxScanDuration)
+	status, err := s.transfers.FinalizeScan(r.Context(), req.ScanID, s.scanner, s.currentConfig().MaxScanBytes, s.currentConfig().MaxScanDuration)
 	if err != nil {
 		writeIndistinguishable(w)
 		return
 	}
+	s.metrics.IncScanVerdict(string(status))
 	if err := s.updateClaimScanStatus(r.Context(), session, claimID, status); err != nil {
 		writeIndistinguishable(w)
 		return
@@ -1470,10 +1559,3 @@ func parseRange(header string) (int64, int64, bool) {
 	}
 	return start, end - start + 1, true
 }
-
-func maxDuration(a time.Duration, b time.Duration) time.Duration {
-	if a > b {
-		return a
-	}
-	return b
-}
@@ -1,327 +1,395 @@
 package api
 
 import (
+	"context"
 	"sync"
 	"time"
+
+	"universaldrop/internal/config"
+	"universaldrop/internal/quota"
 )
 
-const quotaDayWindow = 24 * time.Hour
+const (
+	quotaDayWindow   = 24 * time.Hour
+	concurrencyLease = 2 * time.Minute
+)
 
-type dailyCounter struct {
-	start time.Time
-	count int64
-	bytes int64
+type clientKeys struct {
+	addr   string
+	prefix string
+	coarse string
 }
 
-type transferOwner struct {
-	ip      string
-	session string
+type quotaSubject struct {
+	ip       clientKeys
+	session  string
+	sender   string
+	receiver string
 }
 
-type quotaTracker struct {
-	mu sync.Mutex
+type quotaOp struct {
+	key    string
+	delta  int64
+	limit  int64
+	window time.Duration
+}
 
-	sessionsByIP       map[string]*dailyCounter
-	sessionsBySession  map[string]*dailyCounter
-	transfersByIP      map[string]*dailyCounter
-	transfersBySession map[string]*dailyCounter
-	bytesByIP          map[string]*dailyCounter
-	bytesBySession     map[string]*dailyCounter
+const coarseKeyPrefix = "coarse:"
 
-	concurrentByIP      map[string]int
-	concurrentBySession map[string]int
-	transferOwners      map[string]transferOwner
+type quotaTracker struct {
+	mu    sync.Mutex
+	store quota.Store
 
-	relayByIdentity map[string]*dailyCounter
-	relayActive     map[string][]time.Time
-}
+	held      map[string][]string
+	beginning map[string]bool
 
-func newQuotaTracker() *quotaTracker {
-	return &quotaTracker{
-		sessionsByIP:        map[string]*dailyCounter{},
-		sessionsBySession:   map[string]*dailyCounter{},
-		transfersByIP:       map[string]*dailyCounter{},
-		transfersBySession:  map[string]*dailyCounter{},
-		bytesByIP:           map[string]*dailyCounter{},
-		bytesBySession:      map[string]*dailyCounter{},
-		concurrentByIP:      map[string]int{},
-		concurrentBySession: map[string]int{},
-		transferOwners:      map[string]transferOwner{},
-		relayByIdentity:     map[string]*dailyCounter{},
-		relayActive:         map[string][]time.Time{},
-	}
-}
-
-func (q *quotaTracker) AllowSession(ip string, session string, limitIP int64, limitSession int64) bool {
-	if limitIP <= 0 && limitSession <= 0 {
-		return true
-	}
-	now := time.Now().UTC()
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	coarseScale int64
+}
 
-	if !q.allowCount(q.sessionsByIP, ip, now, limitIP) {
-		return false
+func newQuotaTracker(store quota.Store, coarseScale int64) *quotaTracker {
+	if coarseScale <= 0 {
+		coarseScale = 1
 	}
-	if session != "" && !q.allowCount(q.sessionsBySession, session, now, limitSession) {
-		return false
+	return &quotaTracker{
+		store:       store,
+		held:        map[string][]string{},
+		beginning:   map[string]bool{},
+		coarseScale: coarseScale,
 	}
-	return true
 }
 
-func (q *quotaTracker) BeginTransfer(transferID string, ip string, session string, limitIP int64, limitSession int64, concurrentIP int, concurrentSession int) bool {
-	if limitIP <= 0 && limitSession <= 0 && concurrentIP <= 0 && concurrentSession <= 0 {
-		return true
+func quotaKey(dimension string, value string) string {
+	if value == "" {
+		value = "unknown"
 	}
-	now := time.Now().UTC()
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	return dimension + ":" + anonHash(value)
+}
 
-	if transferID == "" {
-		return false
+func (q *quotaTracker) ipOps(dimension string, ip clientKeys, delta int64, limit int64, window time.Duration) []quotaOp {
+	if ip.prefix == "" || limit <= 0 {
+		return nil
 	}
-	if owner, ok := q.transferOwners[transferID]; ok {
-		_ = owner
-		return true
+	ops := []quotaOp{{key: quotaKey(dimension+":ip", ip.prefix), delta: delta, limit: limit, window: window}}
+	if ip.coarse != "" {
+		ops = append(ops, quotaOp{key: quotaKey(dimension+":ip", coarseKeyPrefix+ip.coarse), delta: delta, limit: limit * q.coarseScale, window: window})
 	}
+	return ops
+}
 
-	if limitIP > 0 {
-		counter := q.counter(q.transfersByIP, ip, now)
-		if counter.count+1 > limitIP {
-			return false
-		}
+func (q *quotaTracker) sessionOps(dimension string, session string, delta int64, limit int64, window time.Duration) []quotaOp {
+	if session == "" || limit <= 0 {
+		return nil
 	}
-	if session != "" && limitSession > 0 {
-		counter := q.counter(q.transfersBySession, session, now)
-		if counter.count+1 > limitSession {
-			return false
-		}
+	return []quotaOp{{key: quotaKey(dimension+":session", session), delta: delta, limit: limit, window: window}}
+}
+
+func (q *quotaTracker) identityOps(dimension string, subject quotaSubject, delta int64, limitSender int64, limitReceiver int64, window time.Duration) []quotaOp {
+	var ops []quotaOp
+	if subject.sender != "" && limitSender > 0 {
+		ops = append(ops, quotaOp{key: quotaKey(dimension+":sender", subject.sender), delta: delta, limit: limitSender, window: window})
 	}
-	if concurrentIP > 0 {
-		if q.concurrentByIP[ip]+1 > concurrentIP {
-			return false
-		}
+	if subject.receiver != "" && limitReceiver > 0 {
+		ops = append(ops, quotaOp{key: quotaKey(dimension+":receiver", subject.receiver), delta: delta, limit: limitReceiver, window: window})
 	}
-	if session != "" && concurrentSession > 0 {
-		if q.concurrentBySession[session]+1 > concurrentSession {
+	return ops
+}
+
+func (q *quotaTracker) apply(ctx context.Context, ops []quotaOp) bool {
+	applied := make([]quotaOp, 0, len(ops))
+	for _, op := range ops {
+		_, ok, err := q.store.Incr(ctx, op.key, op.delta, op.limit, op.window)
+		if err != nil || !ok {
+			for _, done := range applied {
+				_, _, _ = q.store.Incr(ctx, done.key, -done.delta, 0, done.window)
+			}
 			return false
 		}
+		applied = append(applied, op)
 	}
+	return true
+}
+
+func (q *quotaTracker) newSessionOps(subject quotaSubject, limits config.QuotaConfig) []quotaOp {
+	ops := q.ipOps("sessions", subject.ip, 1, limits.SessionsPerDayIP, quotaDayWindow)
+	ops = append(ops, q.sessionOps("sessions", subject.session, 1, limits.SessionsPerDaySession, quotaDayWindow)...)
+	return append(ops, q.identityOps("sessions", subject, 1, limits.SessionsPerDaySender, limits.SessionsPerDayReceiver, quotaDayWindow)...)
+}
 
-	if limitIP > 0 {
-		counter := q.counter(q.transfersByIP, ip, now)
-		counter.count++
+func (q *quotaTracker) AllowSession(ctx context.Context, subject quotaSubject, limits config.QuotaConfig) bool {
+	ops := q.newSessionOps(subject, limits)
+	if len(ops) == 0 {
+		return true
 	}
-	if session != "" && limitSession > 0 {
-		counter := q.counter(q.transfersBySession, session, now)
-		counter.count++
+	return q.apply(ctx, ops)
+}
+
+func (q *quotaTracker) RefundSession(ctx context.Context, subject quotaSubject, limits config.QuotaConfig) {
+	for _, op := range q.newSessionOps(subject, limits) {
+		_, _, _ = q.store.Incr(ctx, op.key, -op.delta, 0, op.window)
 	}
-	if concurrentIP > 0 {
-		q.concurrentByIP[ip] = q.concurrentByIP[ip] + 1
+}
+
+func (q *quotaTracker) BeginTransfer(ctx context.Context, transferID string, subject quotaSubject, limits config.QuotaConfig) bool {
+	concurrent := q.ipOps("concurrent", subject.ip, 1, int64(limits.ConcurrentTransfersIP), 0)
+	concurrent = append(concurrent, q.sessionOps("concurrent", subject.session, 1, int64(limits.ConcurrentTransfersSession), 0)...)
+	concurrent = append(concurrent, q.identityOps("concurrent", subject, 1, int64(limits.ConcurrentTransfersSender), int64(limits.ConcurrentTransfersReceiver), 0)...)
+	ops := q.ipOps("transfers", subject.ip, 1, limits.TransfersPerDayIP, quotaDayWindow)
+	ops = append(ops, q.sessionOps("transfers", subject.session, 1, limits.TransfersPerDaySession, quotaDayWindow)...)
+	if len(ops) == 0 && len(concurrent) == 0 {
+		return true
 	}
-	if session != "" && concurrentSession > 0 {
-		q.concurrentBySession[session] = q.concurrentBySession[session] + 1
+	if transferID == "" {
+		return false
 	}
-	q.transferOwners[transferID] = transferOwner{ip: ip, session: session}
-	return true
-}
 
-func (q *quotaTracker) EndTransfer(transferID string) {
 	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	owner, ok := q.transferOwners[transferID]
-	if !ok {
-		return
-	}
-	if owner.ip != "" {
-		if q.concurrentByIP[owner.ip] > 0 {
-			q.concurrentByIP[owner.ip]--
-		}
+	if _, busy := q.beginning[transferID]; busy {
+		q.mu.Unlock()
+		return false
 	}
-	if owner.session != "" {
-		if q.concurrentBySession[owner.session] > 0 {
-			q.concurrentBySession[owner.session]--
-		}
+	q.beginning[transferID] = false
+	q.mu.Unlock()
+
+	began := q.beginTransfer(ctx, transferID, ops, concurrent)
+
+	q.mu.Lock()
+	ended := q.beginning[transferID]
+	delete(q.beginning, transferID)
+	q.mu.Unlock()
+	if began && ended {
+		q.releaseTransfer(ctx, transferID)
 	}
-	delete(q.transferOwners, transferID)
+	return began
 }
 
-func (q *quotaTracker) AddBytes(ip string, session string, bytes int64, limitIP int64, limitSession int64) bool {
-	if bytes <= 0 {
-		return true
-	}
-	if limitIP <= 0 && limitSession <= 0 {
+func (q *quotaTracker) beginTransfer(ctx context.Context, transferID string, ops []quotaOp, concurrent []quotaOp) bool {
+	if _, ok, err := q.store.GetOwner(ctx, transferID); err != nil {
+		return false
+	} else if ok {
 		return true
 	}
-	now := time.Now().UTC()
-	q.mu.Lock()
-	defer q.mu.Unlock()
 
-	var ipCounter *dailyCounter
-	var sessionCounter *dailyCounter
-	if limitIP > 0 {
-		ipCounter = q.counter(q.bytesByIP, ip, now)
-		if ipCounter.bytes+bytes > limitIP {
-			return false
-		}
+	owner := quota.Owner{}
+	for _, op := range concurrent {
+		owner.Keys = append(owner.Keys, op.key)
 	}
-	if session != "" && limitSession > 0 {
-		sessionCounter = q.counter(q.bytesBySession, session, now)
-		if sessionCounter.bytes+bytes > limitSession {
-			return false
-		}
+	if !q.hold(ctx, transferID, concurrent, concurrencyLease) {
+		return false
 	}
-	if ipCounter != nil {
-		ipCounter.bytes += bytes
+	if !q.apply(ctx, ops) {
+		q.release(ctx, transferID, owner.Keys)
+		return false
 	}
-	if sessionCounter != nil {
-		sessionCounter.bytes += bytes
+	if err := q.store.SetOwner(ctx, transferID, owner); err != nil {
+		q.release(ctx, transferID, owner.Keys)
+		for _, op := range ops {
+			_, _, _ = q.store.Incr(ctx, op.key, -op.delta, 0, op.window)
+		}
+		return false
 	}
+	q.track(transferID, owner.Keys)
 	return true
 }
 
-func (q *quotaTracker) AllowRelay(identity string, perDay int64, concurrentLimit int, ttl time.Duration) bool {
-	if perDay <= 0 && concurrentLimit <= 0 {
-		return true
-	}
-	now := time.Now().UTC()
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	active := q.relayActive[identity]
-	if len(active) > 0 {
-		filtered := active[:0]
-		for _, expiresAt := range active {
-			if now.Before(expiresAt) {
-				filtered = append(filtered, expiresAt)
+func (q *quotaTracker) hold(ctx context.Context, holder string, ops []quotaOp, ttl time.Duration) bool {
+	for i, op := range ops {
+		held, err := q.store.Hold(ctx, op.key, holder, op.limit, ttl)
+		if err != nil || !held {
+			for _, done := range ops[:i] {
+				_ = q.store.Release(ctx, done.key, holder)
 			}
-		}
-		active = filtered
-	}
-
-	if concurrentLimit > 0 && len(active) >= concurrentLimit {
-		q.relayActive[identity] = active
-		return false
-	}
-	if perDay > 0 {
-		counter := q.counter(q.relayByIdentity, identity, now)
-		if counter.count+1 > perDay {
-			q.relayActive[identity] = active
 			return false
 		}
-		counter.count++
-	}
-	if concurrentLimit > 0 {
-		active = append(active, now.Add(ttl))
 	}
-	q.relayActive[identity] = active
 	return true
 }
 
-func (q *quotaTracker) counter(store map[string]*dailyCounter, key string, now time.Time) *dailyCounter {
-	if key == "" {
-		key = "unknown"
+func (q *quotaTracker) release(ctx context.Context, holder string, keys []string) {
+	for _, key := range keys {
+		_ = q.store.Release(ctx, key, holder)
 	}
-	entry, ok := store[key]
-	if !ok || entry == nil {
-		entry = &dailyCounter{start: now}
-		store[key] = entry
+}
+
+func (q *quotaTracker) track(transferID string, keys []string) {
+	if len(keys) == 0 {
+		return
 	}
-	if entry.start.IsZero() || now.Sub(entry.start) >= quotaDayWindow {
-		entry.start = now
-		entry.count = 0
-		entry.bytes = 0
+	q.mu.Lock()
+	q.held[transferID] = keys
+	q.mu.Unlock()
+}
+
+func (q *quotaTracker) RenewLeases(ctx context.Context) {
+	q.mu.Lock()
+	held := make(map[string][]string, len(q.held))
+	for transferID, keys := range q.held {
+		held[transferID] = keys
+	}
+	q.mu.Unlock()
+	for transferID, keys := range held {
+		for _, key := range keys {
+			_, _ = q.store.Hold(ctx, key, transferID, 0, concurrencyLease)
+		}
 	}
-	return entry
 }
 
-func (q *quotaTracker) allowCount(store map[string]*dailyCounter, key string, now time.Time, limit int64) bool {
-	if limit <= 0 {
-		return true
+func (q *quotaTracker) EndTransfer(ctx context.Context, transferID string) {
+	q.mu.Lock()
+	if _, busy := q.beginning[transferID]; busy {
+		q.beginning[transferID] = true
 	}
-	entry := q.counter(store, key, now)
-	if entry.count+1 > limit {
-		return false
+	q.mu.Unlock()
+
+	q.releaseTransfer(ctx, transferID)
+}
+
+func (q *quotaTracker) releaseTransfer(ctx context.Context, transferID string) {
+	q.mu.Lock()
+	delete(q.held, transferID)
+	q.mu.Unlock()
+	owner, ok, err := q.store.DeleteOwner(ctx, transferID)
+	if err != nil || !ok {
+		return
 	}
-	entry.count++
-	return true
+	q.release(ctx, transferID, owner.Keys)
 }
 
-type bandwidthLimiter struct {
-	rateBps int64
-	next    time.Time
+type quotaRemaining struct {
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
 }
 
-func (b *bandwidthLimiter) Reserve(bytes int64) time.Duration {
-	if b.rateBps <= 0 || bytes <= 0 {
-		return 0
-	}
-	now := time.Now()
-	duration := time.Duration(float64(bytes) / float64(b.rateBps) * float64(time.Second))
-	start := b.next
-	if start.IsZero() || now.After(start) {
-		start = now
-	}
-	end := start.Add(duration)
-	b.next = end
-	wait := end.Sub(now)
-	if wait < 0 {
-		return 0
-	}
-	return wait
+type quotaDimension struct {
+	SessionsPerDay      *quotaRemaining `json:"sessions_per_day,omitempty"`
+	TransfersPerDay     *quotaRemaining `json:"transfers_per_day,omitempty"`
+	BytesPerDay         *quotaRemaining `json:"bytes_per_day,omitempty"`
+	ConcurrentTransfers *quotaRemaining `json:"concurrent_transfers,omitempty"`
 }
 
-type throttleManager struct {
-	mu              sync.Mutex
-	perTransferRate int64
-	perTransfer     map[string]*bandwidthLimiter
-	globalRate      int64
-	global          bandwidthLimiter
+type quotaReport struct {
+	IP       quotaDimension  `json:"ip"`
+	Session  quotaDimension  `json:"session"`
+	Sender   *quotaDimension `json:"sender,omitempty"`
+	Receiver *quotaDimension `json:"receiver,omitempty"`
 }
 
-func newThrottleManager(perTransfer int64, global int64) *throttleManager {
-	return &throttleManager{
-		perTransferRate: perTransfer,
-		perTransfer:     map[string]*bandwidthLimiter{},
-		globalRate:      global,
-		global:          bandwidthLimiter{rateBps: global},
+func (q *quotaTracker) Remaining(ctx context.Context, subject quotaSubject, limits config.QuotaConfig) (quotaReport, error) {
+	var report quotaReport
+	var err error
+	count := func(ops []quotaOp, used func(ctx context.Context, key string) (int64, error)) *quotaRemaining {
+		if err != nil || len(ops) == 0 {
+			return nil
+		}
+		result := &quotaRemaining{Limit: ops[0].limit, Remaining: ops[0].limit}
+		for _, op := range ops {
+			var value int64
+			value, err = used(ctx, op.key)
+			if err != nil {
+				return nil
+			}
+			if remaining := op.limit - value; remaining < result.Remaining {
+				result.Remaining = remaining
+			}
+		}
+		if result.Remaining < 0 {
+			result.Remaining = 0
+		}
+		return result
+	}
+
+	report.IP = quotaDimension{
+		SessionsPerDay:      count(q.ipOps("sessions", subject.ip, 1, limits.SessionsPerDayIP, quotaDayWindow), q.store.Get),
+		TransfersPerDay:     count(q.ipOps("transfers", subject.ip, 1, limits.TransfersPerDayIP, quotaDayWindow), q.store.Get),
+		BytesPerDay:         count(q.ipOps("bytes", subject.ip, 1, limits.BytesPerDayIP, quotaDayWindow), q.store.Get),
+		ConcurrentTransfers: count(q.ipOps("concurrent", subject.ip, 1, int64(limits.ConcurrentTransfersIP), 0), q.store.Holders),
+	}
+	report.Session = quotaDimension{
+		SessionsPerDay:      count(q.sessionOps("sessions", subject.session, 1, limits.SessionsPerDaySession, quotaDayWindow), q.store.Get),
+		TransfersPerDay:     count(q.sessionOps("transfers", subject.session, 1, limits.TransfersPerDaySession, quotaDayWindow), q.store.Get),
+		BytesPerDay:         count(q.sessionOps("bytes", subject.session, 1, limits.BytesPerDaySession, quotaDayWindow), q.store.Get),
+		ConcurrentTransfers: count(q.sessionOps("concurrent", subject.session, 1, int64(limits.ConcurrentTransfersSession), 0), q.store.Holders),
+	}
+	if subject.sender != "" {
+		sender := quotaSubject{sender: subject.sender}
+		report.Sender = &quotaDimension{
+			SessionsPerDay:      count(q.identityOps("sessions", sender, 1, limits.SessionsPerDaySender, 0, quotaDayWindow), q.store.Get),
+			BytesPerDay:         count(q.identityOps("bytes", sender, 1, limits.BytesPerDaySender, 0, quotaDayWindow), q.store.Get),
+			ConcurrentTransfers: count(q.identityOps("concurrent", sender, 1, int64(limits.ConcurrentTransfersSender), 0, 0), q.store.Holders),
+		}
 	}
+	if subject.receiver != "" {
+		receiver := quotaSubject{receiver: subject.receiver}
+		report.Receiver = &quotaDimension{
+			SessionsPerDay:      count(q.identityOps("sessions", receiver, 1, 0, limits.SessionsPerDayReceiver, quotaDayWindow), q.store.Get),
+			BytesPerDay:         count(q.identityOps("bytes", receiver, 1, 0, limits.BytesPerDayReceiver, quotaDayWindow), q.store.Get),
+			ConcurrentTransfers: count(q.identityOps("concurrent", receiver, 1, 0, int64(limits.ConcurrentTransfersReceiver), 0), q.store.Holders),
+		}
+	}
+	if err != nil {
+		return quotaReport{}, err
+	}
+	return report, nil
 }
 
-func (t *throttleManager) ReserveTransfer(transferID string, bytes int64) time.Duration {
-	if t.perTransferRate <= 0 {
-		return 0
+func (q *quotaTracker) RebuildConcurrency(ctx context.Context, live func(ctx context.Context, transferID string) bool) error {
+	owners, err := q.store.Owners(ctx)
+	if err != nil {
+		return err
 	}
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	limiter := t.perTransfer[transferID]
-	if limiter == nil {
-		limiter = &bandwidthLimiter{rateBps: t.perTransferRate}
-		t.perTransfer[transferID] = limiter
+	for transferID, owner := range owners {
+		if !live(ctx, transferID) {
+			if _, _, err := q.store.DeleteOwner(ctx, transferID); err != nil {
+				return err
+			}
+			q.release(ctx, transferID, owner.Keys)
+			continue
+		}
+		for _, key := range owner.Keys {
+			if _, err := q.store.Hold(ctx, key, transferID, 0, concurrencyLease); err != nil {
+				return err
+			}
+		}
+		q.track(transferID, owner.Keys)
 	}
-	return limiter.Reserve(bytes)
+	return nil
 }
 
-func (t *throttleManager) ReserveGlobal(bytes int64) time.Duration {
-	if t.globalRate <= 0 {
-		return 0
+func (q *quotaTracker) AddBytes(ctx context.Context, subject quotaSubject, bytes int64, limits config.QuotaConfig) bool {
+	if bytes <= 0 {
+		return true
 	}
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	t.global.rateBps = t.globalRate
-	return t.global.Reserve(bytes)
+	ops := q.ipOps("bytes", subject.ip, bytes, limits.BytesPerDayIP, quotaDayWindow)
+	ops = append(ops, q.sessionOps("bytes", subject.session, bytes, limits.BytesPerDaySession, quotaDayWindow)...)
+	ops = append(ops, q.identityOps("bytes", subject, bytes, limits.BytesPerDaySender, limits.BytesPerDayReceiver, quotaDayWindow)...)
+	if len(ops) == 0 {
+		return true
+	}
+	return q.apply(ctx, ops)
 }
 
-func (t *throttleManager) ForgetTransfer(transferID string) {
-	if transferID == "" {
-		return
+func (q *quotaTracker) AllowRelay(ctx context.Context, identity string, perDay int64, concurrentLimit int, ttl time.Duration) bool {
+	if perDay <= 0 && concurrentLimit <= 0 {
+		return true
 	}
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	delete(t.perTransfer, transferID)
+	var slot []string
+	holder, err := randomBase64(12)
+	if err != nil {
+		return false
+	}
+	if concurrentLimit > 0 {
+		key := quotaKey("relay:active", identity)
+		if !q.hold(ctx, holder, []quotaOp{{key: key, limit: int64(concurrentLimit)}}, ttl) {
+			return false
+		}
+		slot = append(slot, key)
+	}
+	if perDay > 0 {
+		if !q.apply(ctx, []quotaOp{{key: quotaKey("relay:identity", identity), delta: 1, limit: perDay, window: quotaDayWindow}}) {
+			q.release(ctx, holder, slot)
+			return false
+		}
+	}
+	return true
 }
 
 type downloadTokenStore struct {
@@ -1,7 +1,36 @@
 package api
 
-import "net/http"
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"universaldrop/internal/metrics"
+)
 
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, s.metrics.Snapshot())
 }
+
+func (s *Server) handlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentTypeText)
+	w.WriteHeader(http.StatusOK)
+	_ = s.metrics.WritePrometheus(w)
+}
+
+func (s *Server) requireMetricsToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := s.currentConfig().Metrics.BearerToken
+		if want == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := sha256.Sum256([]byte(bearerToken(r)))
+		expected := sha256.Sum256([]byte(want))
+		if subtle.ConstantTimeCompare(got[:], expected[:]) != 1 {
+			writeIndistinguishable(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"universaldrop/internal/domain"
+	"universaldrop/internal/storage"
+	"universaldrop/internal/tracing"
+)
+
+type observedStorage struct {
+	storage.Storage
+	observe func(op string, d time.Duration)
+}
+
+func (o observedStorage) track(ctx context.Context, op string) func(error) {
+	start := time.Now()
+	_, span := tracing.Start(ctx, "storage."+op)
+	span.SetAttribute("op", op)
+	return func(err error) {
+		o.observe(op, time.Since(start))
+		if err != nil {
+			span.SetError(storageErrorCode(err))
+		}
+		span.End()
+	}
+}
+
+func storageErrorCode(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, storage.ErrConflict):
+		return "conflict"
+	case errors.Is(err, storage.ErrInvalidRange):
+		return "invalid_range"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "storage_error"
+	}
+}
+
+func (o observedStorage) SaveManifest(ctx context.Context, transferID string, manifest []byte) error {
+	done := o.track(ctx, "save_manifest")
+	err := o.Storage.SaveManifest(ctx, transferID, manifest)
+	done(err)
+	return err
+}
+
+func (o observedStorage) LoadManifest(ctx context.Context, transferID string) ([]byte, error) {
+	done := o.track(ctx, "load_manifest")
+	result, err := o.Storage.LoadManifest(ctx, transferID)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) SaveTransferMeta(ctx context.Context, transferID string, meta domain.TransferMeta) error {
+	done := o.track(ctx, "save_transfer_meta")
+	err := o.Storage.SaveTransferMeta(ctx, transferID, meta)
+	done(err)
+	return err
+}
+
+func (o observedStorage) GetTransferMeta(ctx context.Context, transferID string) (domain.TransferMeta, error) {
+	done := o.track(ctx, "get_transfer_meta")
+	result, err := o.Storage.GetTransferMeta(ctx, transferID)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) DeleteTransferMeta(ctx context.Context, transferID string) error {
+	done := o.track(ctx, "delete_transfer_meta")
+	err := o.Storage.DeleteTransferMeta(ctx, transferID)
+	done(err)
+	return err
+}
+
+func (o observedStorage) WriteChunk(ctx context.Context, transferID string, offset int64, data []byte) error {
+	done := o.track(ctx, "write_chunk")
+	err := o.Storage.WriteChunk(ctx, transferID, offset, data)
+	done(err)
+	return err
+}
+
+func (o observedStorage) ReadRange(ctx context.Context, transferID string, offset int64, length int64) ([]byte, error) {
+	done := o.track(ctx, "read_range")
+	result, err := o.Storage.ReadRange(ctx, transferID, offset, length)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) DeleteTransfer(ctx context.Context, transferID string) error {
+	done := o.track(ctx, "delete_transfer")
+	err := o.Storage.DeleteTransfer(ctx, transferID)
+	done(err)
+	return err
+}
+
+func (o observedStorage) SweepExpired(ctx context.Context, now time.Time) (storage.SweepResult, error) {
+	done := o.track(ctx, "sweep_expired")
+	result, err := o.Storage.SweepExpired(ctx, now)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) CreateSession(ctx context.Context, session domain.Session) error {
+	done := o.track(ctx, "create_session")
+	err := o.Storage.CreateSession(ctx, session)
+	done(err)
+	return err
+}
+
+func (o observedStorage) GetSession(ctx context.Context, sessionID string) (domain.Session, error) {
+	done := o.track(ctx, "get_session")
+	result, err := o.Storage.GetSession(ctx, sessionID)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) UpdateSession(ctx context.Context, session domain.Session) error {
+	done := o.track(ctx, "update_session")
+	err := o.Storage.UpdateSession(ctx, session)
+	done(err)
+	return err
+}
+
+func (o observedStorage) DeleteSession(ctx context.Context, sessionID string) error {
+	done := o.track(ctx, "delete_session")
+	err := o.Storage.DeleteSession(ctx, sessionID)
+	done(err)
+	return err
+}
+
+func (o observedStorage) SaveSessionAuthContext(ctx context.Context, auth domain.SessionAuthContext) error {
+	done := o.track(ctx, "save_session_auth_context")
+	err := o.Storage.SaveSessionAuthContext(ctx, auth)
+	done(err)
+	return err
+}
+
+func (o observedStorage) GetSessionAuthContext(ctx context.Context, sessionID string, claimID string) (domain.SessionAuthContext, error) {
+	done := o.track(ctx, "get_session_auth_context")
+	result, err := o.Storage.GetSessionAuthContext(ctx, sessionID, claimID)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) CreateScanSession(ctx context.Context, scan domain.ScanSession) error {
+	done := o.track(ctx, "create_scan_session")
+	err := o.Storage.CreateScanSession(ctx, scan)
+	done(err)
+	return err
+}
+
+func (o observedStorage) GetScanSession(ctx context.Context, scanID string) (domain.ScanSession, error) {
+	done := o.track(ctx, "get_scan_session")
+	result, err := o.Storage.GetScanSession(ctx, scanID)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) DeleteScanSession(ctx context.Context, scanID string) error {
+	done := o.track(ctx, "delete_scan_session")
+	err := o.Storage.DeleteScanSession(ctx, scanID)
+	done(err)
+	return err
+}
+
+func (o observedStorage) StoreScanChunk(ctx context.Context, scanID string, chunkIndex int, data []byte) error {
+	done := o.track(ctx, "store_scan_chunk")
+	err := o.Storage.StoreScanChunk(ctx, scanID, chunkIndex, data)
+	done(err)
+	return err
+}
+
+func (o observedStorage) ListScanChunks(ctx context.Context, scanID string) ([]int, error) {
+	done := o.track(ctx, "list_scan_chunks")
+	result, err := o.Storage.ListScanChunks(ctx, scanID)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) LoadScanChunk(ctx context.Context, scanID string, chunkIndex int) ([]byte, error) {
+	done := o.track(ctx, "load_scan_chunk")
+	result, err := o.Storage.LoadScanChunk(ctx, scanID, chunkIndex)
+	done(err)
+	return result, err
+}
+
+func (o observedStorage) DeleteScanChunks(ctx context.Context, scanID string) error {
+	done := o.track(ctx, "delete_scan_chunks")
+	err := o.Storage.DeleteScanChunks(ctx, scanID)
+	done(err)
+	return err
+}
+
+func (o observedStorage) HealthCheck(ctx context.Context) error {
+	checker, ok := o.Storage.(StorageHealthChecker)
+	if !ok {
+		return nil
+	}
+	done := o.track(ctx, "health_check")
+	err := checker.HealthCheck(ctx)
+	done(err)
+	return err
+}
@@ -12,7 +12,6 @@ import (
 	"universaldrop/internal/auth"
 	"universaldrop/internal/config"
 	"universaldrop/internal/domain"
-	"universaldrop/internal/logging"
 	"universaldrop/internal/storage"
 )
 
@@ -160,15 +159,17 @@ func (s *Server) handleP2PIceConfig(w http.ResponseWriter, r *http.Request) {
 		writeIndistinguishable(w)
 		return
 	}
-	if mode == "relay" && (len(s.cfg.TURNURLs) == 0 || len(s.cfg.TURNSharedSecret) == 0) {
+	turnSecret := s.turnSharedSecret()
+	if mode == "relay" && (len(s.currentConfig().TURNURLs) == 0 || len(turnSecret) == 0) {
 		writeJSON(w, http.StatusConflict, map[string]string{"error": "turn_unavailable"})
 		return
 	}
 	if mode == "relay" {
 		ttl := s.turnCredentialTTL()
 		identity := sessionID + ":" + claimID
-		if !s.quotas.AllowRelay(identity, s.cfg.Quotas.RelayPerIdentityPerDay, s.cfg.Quotas.RelayConcurrentPerIdentity, ttl) {
-			logging.Allowlist(s.logger, map[string]string{
+		if !s.quotas.AllowRelay(r.Context(), identity, s.currentConfig().Quotas.RelayPerIdentityPerDay, s.currentConfig().Quotas.RelayConcurrentPerIdentity, ttl) {
+			s.metrics.IncQuotaBlocked("relay_issue")
+			s.recordSecurityEvent(map[string]string{
 				"event":           "quota_blocked",
 				"scope":           "relay_issue",
 				"session_id_hash": anonHash(sessionID),
@@ -180,14 +181,14 @@ func (s *Server) handleP2PIceConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := p2pIceConfigResponse{
-		STUNURLs: s.cfg.STUNURLs,
-		TURNURLs: s.cfg.TURNURLs,
+		STUNURLs: s.currentConfig().STUNURLs,
+		TURNURLs: s.currentConfig().TURNURLs,
 	}
 	if mode == "relay" {
 		response.STUNURLs = nil
 	}
-	if len(s.cfg.TURNURLs) > 0 && len(s.cfg.TURNSharedSecret) > 0 {
-		username, credential, ttlSeconds := s.issueTurnCredentials(sessionID, claimID)
+	if len(s.currentConfig().TURNURLs) > 0 && len(turnSecret) > 0 {
+		username, credential, ttlSeconds := s.issueTurnCredentials(turnSecret, sessionID, claimID)
 		response.Username = username
 		response.Credential = credential
 		response.TTLSeconds = ttlSeconds
@@ -233,7 +234,7 @@ func (s *Server) authorizeP2P(r *http.Request, sessionID string, claimID string,
 	if _, err := s.store.GetSessionAuthContext(r.Context(), sessionID, claimID); err != nil {
 		return domain.Session{}, domain.SessionClaim{}, false
 	}
-	if !s.capabilities.ValidateClaims(capClaims, auth.Requirement{
+	if !s.checkClaims(r, capClaims, auth.Requirement{
 		ClaimID:           claimID,
 		SenderPubKeyB64:   claim.SenderPubKeyB64,
 		ReceiverPubKeyB64: session.ReceiverPubKeyB64,
@@ -278,18 +279,18 @@ func (s *Server) drainP2PMessages(ctx context.Context, session domain.Session, c
 	return nil, storage.ErrNotFound
 }
 
-func (s *Server) issueTurnCredentials(sessionID string, claimID string) (string, string, int64) {
+func (s *Server) issueTurnCredentials(secret []byte, sessionID string, claimID string) (string, string, int64) {
 	ttl := s.turnCredentialTTL()
 	expiresAt := time.Now().UTC().Add(ttl).Unix()
 	username := sessionID + ":" + claimID + ":" + strconv.FormatInt(expiresAt, 10)
-	mac := hmac.New(sha1.New, s.cfg.TURNSharedSecret)
+	mac := hmac.New(sha1.New, secret)
 	_, _ = mac.Write([]byte(username))
 	credential := base64.StdEncoding.EncodeToString(mac.Sum(nil))
 	return username, credential, int64(ttl.Seconds())
 }
 
 func (s *Server) turnCredentialTTL() time.Duration {
-	ttl := s.cfg.TransferTokenTTL
+	ttl := s.currentConfig().TransferTokenTTL
 	if ttl <= 0 {
 		ttl = config.DefaultTransferTokenTTL
 	}
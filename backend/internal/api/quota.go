@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"universaldrop/internal/auth"
+)
+
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		writeIndistinguishable(w)
+		return
+	}
+	claims, ok := s.requireCapability(r, "", auth.Requirement{SessionID: sessionID})
+	if !ok {
+		writeIndistinguishable(w)
+		return
+	}
+	session, err := s.store.GetSession(r.Context(), sessionID)
+	if err != nil {
+		writeIndistinguishable(w)
+		return
+	}
+	if time.Now().UTC().After(session.ExpiresAt) {
+		writeIndistinguishable(w)
+		return
+	}
+
+	subject := quotaSubject{
+		ip:       s.clientKeys(r),
+		session:  session.ID,
+		sender:   claims.SenderPubKeyB64,
+		receiver: claims.ReceiverPubKeyB64,
+	}
+	report, err := s.quotas.Remaining(r.Context(), subject, s.currentConfig().Quotas)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "quota_unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
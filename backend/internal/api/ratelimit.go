@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"universaldrop/internal/config"
+	"universaldrop/internal/ratelimit"
+)
+
+type rateLimitRule struct {
+	limiter ratelimit.Taker
+	coarse  ratelimit.Taker
+}
+
+type rateLimits struct {
+	groups map[string]*rateLimitRule
+	routes map[string]*rateLimitRule
+}
+
+func (s *Server) buildRateLimits(cfg config.Config, previous *rateLimits) *rateLimits {
+	limits := &rateLimits{
+		groups: map[string]*rateLimitRule{},
+		routes: map[string]*rateLimitRule{},
+	}
+	if previous == nil {
+		previous = &rateLimits{}
+	}
+	for group, limit := range map[string]config.RateLimit{
+		"health": cfg.RateLimitHealth,
+		"v1":     cfg.RateLimitV1,
+	} {
+		if rule := s.rateLimitRule(limit, previous.groups[group]); rule != nil {
+			limits.groups[group] = rule
+		}
+	}
+	routePolicies := map[string]config.RateLimit{
+		"/v1/session/claim": cfg.RateLimitSessionClaim,
+	}
+	for pattern, limit := range cfg.RateLimitRoutes {
+		routePolicies[pattern] = limit
+	}
+	for pattern, limit := range routePolicies {
+		if rule := s.rateLimitRule(limit, previous.routes[pattern]); rule != nil {
+			limits.routes[pattern] = rule
+		}
+	}
+	return limits
+}
+
+func (s *Server) rateLimitRule(limit config.RateLimit, existing *rateLimitRule) *rateLimitRule {
+	if limit.Max <= 0 {
+		return nil
+	}
+	policy := ratelimit.Policy{Limit: limit.Max, Window: limit.Window, Burst: limit.Burst}
+	coarsePolicy := ratelimit.Policy{Limit: limit.Max * s.coarseScale, Window: limit.Window, Burst: limit.Burst * s.coarseScale}
+	if existing != nil {
+		existing.limiter.SetPolicy(policy)
+		if existing.coarse != nil {
+			existing.coarse.SetPolicy(coarsePolicy)
+		}
+		return existing
+	}
+	rule := &rateLimitRule{limiter: s.newLimiter(policy)}
+	if s.coarsePrefixes.Enabled() {
+		rule.coarse = s.newLimiter(coarsePolicy)
+	}
+	return rule
+}
+
+func (rule *rateLimitRule) take(name string, keys clientKeys) ratelimit.Decision {
+	decision := rule.limiter.Take(name + ":" + keys.prefix)
+	if !decision.Allowed || rule.coarse == nil || keys.coarse == "" {
+		return decision
+	}
+	coarse := rule.coarse.Take(name + ":" + keys.coarse)
+	if !coarse.Allowed || coarse.Remaining < decision.Remaining {
+		return coarse
+	}
+	return decision
+}
+
+func (s *Server) rateLimit(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limits := s.limits.Load()
+			groupRule := limits.groups[group]
+			if groupRule == nil && len(limits.routes) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			pattern := s.routePattern(r)
+			routeRule := limits.routes[pattern]
+			if groupRule == nil && routeRule == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keys := s.clientKeys(r)
+			var decision ratelimit.Decision
+			for _, entry := range []struct {
+				name string
+				rule *rateLimitRule
+			}{
+				{name: group, rule: groupRule},
+				{name: "route:" + pattern, rule: routeRule},
+			} {
+				if entry.rule == nil {
+					continue
+				}
+				current := entry.rule.take(entry.name, keys)
+				if decision.Limit == 0 || !current.Allowed || current.Remaining < decision.Remaining {
+					decision = current
+				}
+				if !current.Allowed {
+					break
+				}
+			}
+			writeRateLimitHeaders(w, decision)
+			if !decision.Allowed {
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
+				writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate_limited"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"universaldrop/internal/config"
+	"universaldrop/internal/logging"
+)
+
+func (s *Server) currentConfig() *config.Config {
+	return s.cfg.Load()
+}
+
+func (s *Server) Reload(next config.Config) ([]string, []string) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	current := *s.cfg.Load()
+	merged, applied, ignored := config.Reload(current, next)
+	if len(ignored) > 0 {
+		logging.Allowlist(s.logger, map[string]string{
+			"event":  "config_reload_ignored",
+			"reason": "restart_required",
+			"count":  strconv.Itoa(len(ignored)),
+			"keys":   strings.Join(ignored, ","),
+		})
+	}
+	if len(applied) == 0 {
+		return applied, ignored
+	}
+
+	limits := s.buildRateLimits(merged, s.limits.Load())
+	s.bandwidth.SetRates(merged.Throttles.GlobalBandwidthCapBps, merged.Throttles.TransferBandwidthCapBps)
+	if string(merged.TURNSharedSecret) != string(current.TURNSharedSecret) {
+		s.SetTURNSharedSecret(merged.TURNSharedSecret)
+	}
+	s.limits.Store(limits)
+	s.cfg.Store(&merged)
+
+	logging.Allowlist(s.logger, map[string]string{
+		"event": "config_reloaded",
+		"count": strconv.Itoa(len(applied)),
+		"keys":  strings.Join(applied, ","),
+	})
+	return applied, ignored
+}
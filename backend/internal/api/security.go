@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"net"
 	"net/http"
 	"strings"
 )
@@ -43,21 +42,17 @@ func randomBase64(size int) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
-func clientIP(r *http.Request) string {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		parts := strings.Split(forwarded, ",")
-		if len(parts) > 0 {
-			candidate := strings.TrimSpace(parts[0])
-			if candidate != "" {
-				return candidate
-			}
-		}
-	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil && host != "" {
-		return host
+func (s *Server) clientIP(r *http.Request) string {
+	return s.clientIPs.ClientIP(r)
+}
+
+func (s *Server) clientKeys(r *http.Request) clientKeys {
+	ip := s.clientIP(r)
+	keys := clientKeys{addr: ip, prefix: s.prefixes.Key(ip)}
+	if s.coarsePrefixes.Enabled() {
+		keys.coarse = s.coarsePrefixes.Key(ip)
 	}
-	return "unknown"
+	return keys
 }
 
 func bearerToken(r *http.Request) string {
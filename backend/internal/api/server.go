@@ -2,23 +2,32 @@ package api
 
 import (
 	"context"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"universaldrop/internal/admission"
+	"universaldrop/internal/audit"
 	"universaldrop/internal/auth"
+	"universaldrop/internal/bandwidth"
+	"universaldrop/internal/capacity"
+	"universaldrop/internal/clientip"
 	"universaldrop/internal/clock"
 	"universaldrop/internal/config"
 	"universaldrop/internal/logging"
 	"universaldrop/internal/metrics"
+	"universaldrop/internal/quota"
 	"universaldrop/internal/ratelimit"
+	"universaldrop/internal/redis"
 	"universaldrop/internal/scanner"
 	"universaldrop/internal/storage"
+	"universaldrop/internal/tracing"
 	"universaldrop/internal/transfer"
 )
 
@@ -33,30 +42,54 @@ type StorageHealthChecker interface {
 type Dependencies struct {
 	Config        config.Config
 	Store         storage.Storage
-	Logger        *log.Logger
+	Logger        *slog.Logger
 	Version       string
 	Scanner       scanner.Scanner
 	Clock         clock.Clock
 	Capabilities  *auth.Service
 	SweeperStatus SweeperStatus
+	ClientIP      *clientip.Resolver
+	QuotaStore    quota.Store
+	SharedState   *redis.Client
+	Tracer        *tracing.Tracer
+	Audit         audit.Recorder
 }
 
 type Server struct {
-	cfg            config.Config
+	cfg            atomic.Pointer[config.Config]
+	reloadMu       sync.Mutex
 	store          storage.Storage
-	logger         *log.Logger
+	logger         *slog.Logger
 	version        string
-	rateLimiters   map[string]*ratelimit.Limiter
+	limits         atomic.Pointer[rateLimits]
+	newLimiter     func(ratelimit.Policy) ratelimit.Taker
+	coarseScale    int
+	mux            *chi.Mux
 	transfers      *transfer.Engine
 	scanner        scanner.Scanner
 	quotas         *quotaTracker
-	throttles      *throttleManager
+	sharedQuotas   bool
+	bandwidth      *bandwidth.Scheduler
+	admission      *admission.Controller
+	capacity       *capacity.Guard
+	tracer         *tracing.Tracer
+	audit          audit.Recorder
+	rejections     rejectionCounts
 	downloadTokens *downloadTokenStore
 	clock          clock.Clock
 	sweeperStatus  SweeperStatus
 	metrics        *metrics.Counters
 	capabilities   *auth.Service
+	clientIPs      *clientip.Resolver
+	prefixes       clientip.PrefixPolicy
+	coarsePrefixes clientip.PrefixPolicy
+	turnMu         sync.RWMutex
+	turnSecret     []byte
+	draining       atomic.Bool
+	inflight       atomic.Int64
 	Router         http.Handler
+	AdminRouter    http.Handler
+	MetricsRouter  http.Handler
 }
 
 var nonTransferTimeout = 2 * time.Minute
@@ -67,7 +100,7 @@ const sweeperStaleThreshold = 10 * time.Minute
 func NewServer(deps Dependencies) *Server {
 	logSink := deps.Logger
 	if logSink == nil {
-		logSink = log.New(io.Discard, "", 0)
+		logSink = logging.Discard()
 	}
 	version := deps.Version
 	if version == "" {
@@ -85,50 +118,127 @@ func NewServer(deps Dependencies) *Server {
 	if caps == nil {
 		caps = auth.NewService(nil, clk, nil)
 	}
+	resolver := deps.ClientIP
+	if resolver == nil {
+		var err error
+		resolver, err = clientip.NewResolver(deps.Config.TrustedProxies, clientip.Options{Header: deps.Config.TrustedHeader})
+		if err != nil {
+			resolver = &clientip.Resolver{}
+		}
+	}
+
+	quotaStore := deps.QuotaStore
+	if quotaStore == nil {
+		quotaStore = quota.NewMemoryStore(clk)
+	}
 
-	rateLimiters := map[string]*ratelimit.Limiter{}
-	if deps.Config.RateLimitHealth.Max > 0 {
-		rateLimiters["health"] = ratelimit.New(deps.Config.RateLimitHealth.Max, deps.Config.RateLimitHealth.Window, clk)
+	prefixCfg := deps.Config.IPPrefixes
+	prefixes := clientip.PrefixPolicy{IPv4Bits: prefixCfg.IPv4Bits, IPv6Bits: prefixCfg.IPv6Bits}
+	if prefixes.IPv4Bits <= 0 {
+		prefixes.IPv4Bits = config.DefaultIPv4PrefixBits
 	}
-	if deps.Config.RateLimitV1.Max > 0 {
-		rateLimiters["v1"] = ratelimit.New(deps.Config.RateLimitV1.Max, deps.Config.RateLimitV1.Window, clk)
+	if prefixes.IPv6Bits <= 0 {
+		prefixes.IPv6Bits = config.DefaultIPv6PrefixBits
 	}
-	if deps.Config.RateLimitSessionClaim.Max > 0 {
-		rateLimiters["session-claim"] = ratelimit.New(deps.Config.RateLimitSessionClaim.Max, deps.Config.RateLimitSessionClaim.Window, clk)
+	coarsePrefixes := clientip.PrefixPolicy{IPv4Bits: prefixCfg.CoarseIPv4Bits, IPv6Bits: prefixCfg.CoarseIPv6Bits}
+	coarseScale := prefixCfg.CoarseLimitScale
+	if coarseScale <= 0 {
+		coarseScale = config.DefaultCoarseLimitScale
+	}
+
+	counters := metrics.NewCounters()
+	scheduler := bandwidth.New(bandwidth.Options{
+		GlobalBps:  deps.Config.Throttles.GlobalBandwidthCapBps,
+		PerFlowBps: deps.Config.Throttles.TransferBandwidthCapBps,
+		OnQueued:   counters.ObserveBandwidthQueueDelay,
+	})
+
+	admissionCfg := deps.Config.Admission
+	controller := admission.New(admission.Options{
+		MaxInflightBytes:  admissionCfg.MaxInflightBytes,
+		MaxGoroutines:     admissionCfg.MaxGoroutines,
+		MaxStorageLatency: admissionCfg.MaxStorageLatency,
+		ShedPercent:       admissionCfg.ShedPercent,
+	})
+	var disk storage.CapacityReporter
+	if reporter, ok := deps.Store.(storage.CapacityReporter); ok {
+		disk = reporter
+	}
+	guard := capacity.New(disk, capacity.Options{
+		MinFreeBytes:  deps.Config.Storage.MinFreeBytes,
+		MinFreeInodes: deps.Config.Storage.MinFreeInodes,
+		Clock:         clk,
+	})
+	store := deps.Store
+	if store != nil {
+		store = observedStorage{Storage: store, observe: func(op string, d time.Duration) {
+			counters.ObserveStorageOp(op, d)
+			controller.ObserveStorage(d)
+		}}
 	}
 
 	server := &Server{
-		cfg:            deps.Config,
-		store:          deps.Store,
+		store:          store,
 		logger:         logSink,
 		version:        version,
-		rateLimiters:   rateLimiters,
-		transfers:      transfer.New(deps.Store),
+		coarseScale:    int(coarseScale),
+		transfers:      transfer.New(store),
 		scanner:        scanService,
-		quotas:         newQuotaTracker(),
-		throttles:      newThrottleManager(deps.Config.Throttles.TransferBandwidthCapBps, deps.Config.Throttles.GlobalBandwidthCapBps),
+		quotas:         newQuotaTracker(quotaStore, coarseScale),
+		bandwidth:      scheduler,
+		admission:      controller,
+		capacity:       guard,
+		tracer:         deps.Tracer,
+		audit:          deps.Audit,
 		downloadTokens: newDownloadTokenStore(),
 		clock:          clk,
 		sweeperStatus:  deps.SweeperStatus,
-		metrics:        metrics.NewCounters(),
+		metrics:        counters,
 		capabilities:   caps,
+		clientIPs:      resolver,
+		prefixes:       prefixes,
+		coarsePrefixes: coarsePrefixes,
+		turnSecret:     append([]byte(nil), deps.Config.TURNSharedSecret...),
+		sharedQuotas:   deps.SharedState != nil,
+	}
+	server.newLimiter = func(policy ratelimit.Policy) ratelimit.Taker {
+		local := ratelimit.NewWithPolicy(policy, clk)
+		if deps.SharedState == nil {
+			return local
+		}
+		if deps.Config.SharedState.FailureMode != config.SharedStateFailLocal {
+			local = nil
+		}
+		return ratelimit.NewShared(deps.SharedState, deps.Config.SharedState.KeyPrefix+"rl:", policy, clk, local, nil)
 	}
+	cfg := deps.Config
+	server.cfg.Store(&cfg)
+	server.limits.Store(server.buildRateLimits(cfg, nil))
 
 	server.Router = server.routes()
+	server.AdminRouter = server.adminRoutes()
+	server.MetricsRouter = server.metricsRoutes()
 	return server
 }
 
 func (s *Server) routes() http.Handler {
 	r := chi.NewRouter()
+	s.mux = r
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(s.traceRequests)
 	r.Use(middleware.Recoverer)
 
 	r.With(timeoutMiddleware(nonTransferTimeout)).With(s.safeLogger).With(s.rateLimit("health")).Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 	})
-	r.With(timeoutMiddleware(nonTransferTimeout)).With(s.safeLogger).With(s.rateLimit("health")).Get("/readyz", s.handleReadyz)
-	r.With(timeoutMiddleware(nonTransferTimeout)).With(s.safeLogger).With(s.rateLimit("health")).Get("/metricsz", s.handleMetrics)
+	cfg := s.currentConfig()
+	if cfg.Admin.Address == "" {
+		r.With(timeoutMiddleware(nonTransferTimeout)).With(s.safeLogger).With(s.rateLimit("health")).Get("/readyz", s.handleReadyz)
+	}
+	if cfg.Admin.Address == "" && cfg.Metrics.Address == "" && (cfg.Metrics.BearerToken != "" || cfg.Metrics.Public) {
+		r.With(timeoutMiddleware(nonTransferTimeout)).With(s.safeLogger).With(s.rateLimit("health")).With(s.requireMetricsToken).Get("/metricsz", s.handleMetrics)
+		r.With(timeoutMiddleware(nonTransferTimeout)).With(s.safeLogger).With(s.rateLimit("health")).With(s.requireMetricsToken).Get("/metrics", s.handlePrometheusMetrics)
+	}
 
 	r.Route("/v1", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
@@ -136,14 +246,18 @@ func (s *Server) routes() http.Handler {
 			r.Use(s.safeLogger)
 			r.Use(s.rateLimit("v1"))
 			r.Get("/ping", s.handlePing)
-			r.With(s.rateLimit("session-claim")).Post("/session/claim", s.handleClaimSession)
+			r.With(s.refuseWhenDraining, s.admit(admission.Background)).Post("/session/claim", s.handleClaimSession)
 			r.Post("/session/approve", s.handleApproveSession)
 			r.Post("/session/sas/commit", s.handleCommitSAS)
 			r.Get("/session/sas/status", s.handleSASStatus)
 			r.Get("/session/poll", s.handlePollSession)
-			r.Post("/session/create", s.handleCreateSession)
+			r.With(s.refuseWhenDraining, s.admit(admission.Background)).Post("/session/create", s.handleCreateSession)
+			r.Get("/quota", s.handleQuota)
+			if s.currentConfig().DebugCapabilityIntrospection {
+				r.Post("/debug/capability", s.handleDebugCapability)
+			}
 			r.Route("/p2p", func(r chi.Router) {
-				r.Post("/offer", s.handleP2POffer)
+				r.With(s.refuseWhenDraining, s.admit(admission.Transfer)).Post("/offer", s.handleP2POffer)
 				r.Post("/answer", s.handleP2PAnswer)
 				r.Post("/ice", s.handleP2PICE)
 				r.Get("/poll", s.handleP2PPoll)
@@ -153,26 +267,94 @@ func (s *Server) routes() http.Handler {
 		r.Route("/transfer", func(r chi.Router) {
 			r.Use(s.safeLogger)
 			r.Use(s.rateLimit("v1"))
-			r.Post("/init", s.handleInitTransfer)
+			r.Use(s.trackInFlight)
+			r.With(s.refuseWhenDraining, s.admit(admission.Transfer)).Post("/init", s.handleInitTransfer)
 			r.Put("/chunk", s.handleUploadChunk)
 			r.Post("/finalize", s.handleFinalizeTransfer)
 			r.Get("/manifest", s.handleGetTransferManifest)
 			r.Post("/download_token", s.handleDownloadToken)
 			r.Get("/download", s.handleDownloadTransfer)
 			r.Post("/receipt", s.handleTransferReceipt)
-			r.Post("/scan_init", s.handleScanInit)
-			r.Put("/scan_chunk", s.handleScanChunk)
-			r.Post("/scan_finalize", s.handleScanFinalize)
+			r.With(s.refuseWhenDraining, s.admit(admission.Background)).Post("/scan_init", s.handleScanInit)
+			r.With(s.admit(admission.Transfer)).Put("/scan_chunk", s.handleScanChunk)
+			r.With(s.admit(admission.Transfer)).Post("/scan_finalize", s.handleScanFinalize)
 		})
 	})
 
 	return r
 }
 
+func (s *Server) adminRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(timeoutMiddleware(nonTransferTimeout))
+	r.Use(s.safeLogger)
+
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+	r.Get("/readyz", s.handleReadyz)
+	if s.currentConfig().Metrics.Address == "" {
+		r.With(s.requireMetricsToken).Get("/metricsz", s.handleMetrics)
+		r.With(s.requireMetricsToken).Get("/metrics", s.handlePrometheusMetrics)
+	}
+	return r
+}
+
+func (s *Server) metricsRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(timeoutMiddleware(nonTransferTimeout))
+	r.Use(s.safeLogger)
+	r.Use(s.requireMetricsToken)
+
+	r.Get("/metricsz", s.handleMetrics)
+	r.Get("/metrics", s.handlePrometheusMetrics)
+	return r
+}
+
 func (s *Server) Metrics() *metrics.Counters {
 	return s.metrics
 }
 
+func (s *Server) RebuildQuotaConcurrency(ctx context.Context) error {
+	if s.sharedQuotas {
+		return nil
+	}
+	return s.quotas.RebuildConcurrency(ctx, func(ctx context.Context, transferID string) bool {
+		meta, err := s.store.GetTransferMeta(ctx, transferID)
+		if err != nil {
+			return false
+		}
+		return meta.ExpiresAt.IsZero() || s.clock.Now().Before(meta.ExpiresAt)
+	})
+}
+
+func (s *Server) RenewQuotaLeases(ctx context.Context) {
+	ticker := time.NewTicker(concurrencyLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.quotas.RenewLeases(ctx)
+		}
+	}
+}
+
+func (s *Server) SetTURNSharedSecret(secret []byte) {
+	s.turnMu.Lock()
+	defer s.turnMu.Unlock()
+	s.turnSecret = append([]byte(nil), secret...)
+}
+
+func (s *Server) turnSharedSecret() []byte {
+	s.turnMu.RLock()
+	defer s.turnMu.RUnlock()
+	return s.turnSecret
+}
+
 func (s *Server) safeLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -184,43 +366,57 @@ func (s *Server) safeLogger(next http.Handler) http.Handler {
 		if route == "" {
 			route = "unknown"
 		}
+		duration := time.Since(start)
+		s.metrics.ObserveRequest(route, duration)
 		logging.Allowlist(s.logger, map[string]string{
 			"method":      r.Method,
 			"route":       route,
 			"status":      strconv.Itoa(ww.Status()),
-			"duration_ms": strconv.FormatInt(time.Since(start).Milliseconds(), 10),
-			"ip_hash":     anonHash(clientIP(r)),
+			"duration_ms": strconv.FormatInt(duration.Milliseconds(), 10),
+			"ip_hash":     anonHash(s.clientIP(r)),
 		})
 	})
 }
 
-func (s *Server) rateLimit(group string) func(http.Handler) http.Handler {
-	limiter := s.rateLimiters[group]
-	if limiter == nil {
-		return func(next http.Handler) http.Handler {
-			return next
-		}
+func (s *Server) routePattern(r *http.Request) string {
+	if s.mux == nil {
+		return ""
 	}
+	return s.mux.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+}
 
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := group + ":" + clientIP(r)
-			if !limiter.Allow(key) {
-				writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate_limited"})
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
+func writeRateLimitHeaders(w http.ResponseWriter, decision ratelimit.Decision) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(decision.ResetAfter), 10))
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
 	}
+	return int64((d + time.Second - 1) / time.Second)
 }
 
 func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
 	storageOK := s.storageOK(r.Context())
 	sweeperOK := s.sweeperOK()
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":         storageOK && sweeperOK,
-		"storage_ok": storageOK,
-		"sweeper_ok": sweeperOK,
+	load := s.admission.Status()
+	overloaded := load.Level == admission.Overloaded
+	draining := s.draining.Load()
+	disk, _ := s.capacity.Status(r.Context())
+	status := http.StatusOK
+	if overloaded || draining || disk.Degraded {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]any{
+		"ok":               storageOK && sweeperOK && !overloaded && !draining && !disk.Degraded,
+		"storage_ok":       storageOK,
+		"storage_degraded": disk.Degraded,
+		"sweeper_ok":       sweeperOK,
+		"overloaded":       overloaded,
+		"draining":         draining,
+		"load":             load.Level.String(),
 	})
 }
 
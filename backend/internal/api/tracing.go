@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"universaldrop/internal/tracing"
+)
+
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	if s.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := s.tracer.StartRequest(r.Context(), "http.request", r)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		span.SetAttribute("method", r.Method)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		span.SetAttribute("route", routePattern(r))
+		span.SetAttribute("status", strconv.Itoa(ww.Status()))
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetError("server_error")
+		}
+	})
+}
+
+func annotateRequestSpan(r *http.Request, sessionID string, claimID string, transferID string) {
+	span := tracing.SpanFromContext(r.Context())
+	span.SetAttribute("session_id_hash", anonHash(sessionID))
+	span.SetAttribute("claim_id_hash", anonHash(claimID))
+	span.SetAttribute("transfer_id_hash", anonHash(transferID))
+}
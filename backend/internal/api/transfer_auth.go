@@ -38,6 +38,7 @@ func (s *Server) authorizeTransfer(r *http.Request, sessionID string, transferID
 	if !ok {
 		return transferAuth{}, false
 	}
+	annotateRequestSpan(r, session.ID, claim.ID, transferID)
 	peerID := ""
 	switch scope {
 	case auth.ScopeTransferInit, auth.ScopeTransferSend:
@@ -49,13 +50,12 @@ func (s *Server) authorizeTransfer(r *http.Request, sessionID string, transferID
 		return transferAuth{}, false
 	}
 	if transferID == "" {
-		if !s.capabilities.ValidateClaims(capClaims, auth.Requirement{
+		if !s.checkClaims(r, capClaims, auth.Requirement{
 			ClaimID:           claim.ID,
 			PeerID:            peerID,
 			SenderPubKeyB64:   claim.SenderPubKeyB64,
 			ReceiverPubKeyB64: session.ReceiverPubKeyB64,
 			Visibility:        auth.VisibilityE2E,
-			MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
 			Route:             routePattern(r),
 		}) {
 			return transferAuth{}, false
@@ -69,7 +69,7 @@ func (s *Server) authorizeTransfer(r *http.Request, sessionID string, transferID
 	if err != nil {
 		return transferAuth{}, false
 	}
-	if !s.capabilities.ValidateClaims(capClaims, auth.Requirement{
+	if !s.checkClaims(r, capClaims, auth.Requirement{
 		ClaimID:           claim.ID,
 		TransferID:        transferID,
 		PeerID:            peerID,
@@ -79,7 +79,6 @@ func (s *Server) authorizeTransfer(r *http.Request, sessionID string, transferID
 		Visibility:        auth.VisibilityE2E,
 		MaxBytes:          meta.TotalBytes,
 		RequestBytes:      reqBytes,
-		MaxRateBps:        s.cfg.Throttles.TransferBandwidthCapBps,
 		Route:             routePattern(r),
 	}) {
 		return transferAuth{}, false
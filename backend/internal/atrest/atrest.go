@@ -0,0 +1,220 @@
+package atrest
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	KeySize = chacha20poly1305.KeySize
+
+	BlockSize  = 64 << 10
+	RecordSize = blockHeaderSize + chacha20poly1305.NonceSizeX + BlockSize + chacha20poly1305.Overhead
+	ExtentSize = chacha20poly1305.NonceSizeX + 8 + chacha20poly1305.Overhead
+
+	magic           = "UDS1"
+	keyIDSize       = 8
+	headerSize      = len(magic) + keyIDSize
+	blockHeaderSize = 4
+)
+
+var (
+	ErrKeySize    = errors.New("at-rest key must be 32 bytes")
+	ErrUnknownKey = errors.New("sealed with an unknown at-rest key")
+	ErrCorrupt    = errors.New("sealed data failed authentication")
+	ErrNoKeyring  = errors.New("sealed data found but no at-rest key configured")
+)
+
+type KeyID [keyIDSize]byte
+
+type Keyring struct {
+	primary KeyID
+	keys    map[KeyID]cipher.AEAD
+}
+
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[KeyID]cipher.AEAD)}
+	id, err := k.add(primary)
+	if err != nil {
+		return nil, err
+	}
+	k.primary = id
+	for _, key := range previous {
+		if _, err := k.add(key); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) add(key []byte) (KeyID, error) {
+	if len(key) != KeySize {
+		return KeyID{}, ErrKeySize
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return KeyID{}, err
+	}
+	id := fingerprint(key)
+	if _, ok := k.keys[id]; !ok {
+		k.keys[id] = aead
+	}
+	return id, nil
+}
+
+func fingerprint(key []byte) KeyID {
+	sum := sha256.Sum256(append([]byte("universaldrop at-rest key id\x00"), key...))
+	var id KeyID
+	copy(id[:], sum[:])
+	return id
+}
+
+func (k *Keyring) Primary() KeyID {
+	return k.primary
+}
+
+func IsSealed(data []byte) bool {
+	return len(data) >= headerSize && string(data[:len(magic)]) == magic
+}
+
+func SealedWith(data []byte) (KeyID, bool) {
+	if !IsSealed(data) {
+		return KeyID{}, false
+	}
+	var id KeyID
+	copy(id[:], data[len(magic):headerSize])
+	return id, true
+}
+
+func (k *Keyring) Seal(name string, plaintext []byte) ([]byte, error) {
+	aead := k.keys[k.primary]
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(out, magic)
+	copy(out[len(magic):], k.primary[:])
+	nonce := out[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, plaintext, additionalData(out[:headerSize], name)), nil
+}
+
+func (k *Keyring) Open(name string, sealed []byte) ([]byte, error) {
+	id, ok := SealedWith(sealed)
+	if !ok {
+		return nil, ErrCorrupt
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(sealed) < headerSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCorrupt
+	}
+	nonce := sealed[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[headerSize+aead.NonceSize():], additionalData(sealed[:headerSize], name))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
+
+func additionalData(header []byte, name string) []byte {
+	aad := make([]byte, 0, len(header)+len(name))
+	aad = append(aad, header...)
+	return append(aad, name...)
+}
+
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+type Blocks struct {
+	aead cipher.AEAD
+}
+
+func NewBlocks(dataKey []byte) (*Blocks, error) {
+	if len(dataKey) != KeySize {
+		return nil, ErrKeySize
+	}
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Blocks{aead: aead}, nil
+}
+
+func (b *Blocks) Seal(index int64, plaintext []byte) ([]byte, error) {
+	if len(plaintext) > BlockSize {
+		return nil, ErrCorrupt
+	}
+	record := make([]byte, RecordSize)
+	binary.BigEndian.PutUint32(record, uint32(len(plaintext)))
+	nonce := record[blockHeaderSize : blockHeaderSize+b.aead.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b.aead.Seal(nonce[len(nonce):len(nonce)], nonce, plaintext, blockAdditionalData(index, len(plaintext)))
+	return record, nil
+}
+
+func (b *Blocks) Open(index int64, record []byte) ([]byte, error) {
+	if len(record) < blockHeaderSize+b.aead.NonceSize()+b.aead.Overhead() {
+		return nil, ErrCorrupt
+	}
+	length := int(binary.BigEndian.Uint32(record))
+	end := blockHeaderSize + b.aead.NonceSize() + length + b.aead.Overhead()
+	if length > BlockSize || len(record) < end {
+		return nil, ErrCorrupt
+	}
+	nonce := record[blockHeaderSize : blockHeaderSize+b.aead.NonceSize()]
+	plaintext, err := b.aead.Open(nil, nonce, record[blockHeaderSize+b.aead.NonceSize():end], blockAdditionalData(index, length))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
+
+func (b *Blocks) SealExtent(size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, ErrCorrupt
+	}
+	record := make([]byte, b.aead.NonceSize(), ExtentSize)
+	if _, err := rand.Read(record); err != nil {
+		return nil, err
+	}
+	var plaintext [8]byte
+	binary.BigEndian.PutUint64(plaintext[:], uint64(size))
+	return b.aead.Seal(record, record, plaintext[:], blockAdditionalData(-1, len(plaintext))), nil
+}
+
+func (b *Blocks) OpenExtent(record []byte) (int64, error) {
+	if len(record) != ExtentSize {
+		return 0, ErrCorrupt
+	}
+	nonce := record[:b.aead.NonceSize()]
+	plaintext, err := b.aead.Open(nil, nonce, record[len(nonce):], blockAdditionalData(-1, 8))
+	if err != nil {
+		return 0, ErrCorrupt
+	}
+	size := int64(binary.BigEndian.Uint64(plaintext))
+	if size < 0 {
+		return 0, ErrCorrupt
+	}
+	return size, nil
+}
+
+func blockAdditionalData(index int64, length int) []byte {
+	aad := make([]byte, 12)
+	binary.BigEndian.PutUint64(aad, uint64(index))
+	binary.BigEndian.PutUint32(aad[8:], uint32(length))
+	return aad
+}
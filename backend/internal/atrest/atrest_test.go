@@ -0,0 +1,106 @@
+package atrest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestKeyringSealsPerObjectAndRotates(t *testing.T) {
+	old, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	first, err := old.Seal("sessions/a.json", []byte(`{"sender_label":"laptop"}`))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	second, err := old.Seal("sessions/a.json", []byte(`{"sender_label":"laptop"}`))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if bytes.Equal(first, second) || bytes.Contains(first, []byte("laptop")) {
+		t.Fatalf("expected fresh nonces and no plaintext in sealed output")
+	}
+	if _, err := old.Open("sessions/b.json", first); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected sealed data to be bound to its name, got %v", err)
+	}
+	first[len(first)-1] ^= 1
+	if _, err := old.Open("sessions/a.json", first); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected tampering to be detected, got %v", err)
+	}
+
+	rotated, err := NewKeyring(testKey(2), testKey(1))
+	if err != nil {
+		t.Fatalf("rotated keyring: %v", err)
+	}
+	plaintext, err := rotated.Open("sessions/a.json", second)
+	if err != nil || string(plaintext) != `{"sender_label":"laptop"}` {
+		t.Fatalf("expected previous key to open old data, got %q %v", plaintext, err)
+	}
+	if id, _ := SealedWith(second); id == rotated.Primary() {
+		t.Fatalf("expected old data to be flagged for rewrap")
+	}
+	resealed, err := rotated.Seal("sessions/a.json", plaintext)
+	if err != nil {
+		t.Fatalf("reseal: %v", err)
+	}
+	if _, err := old.Open("sessions/a.json", resealed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected the old keyring not to know the new key, got %v", err)
+	}
+	if _, err := NewKeyring([]byte("short")); !errors.Is(err, ErrKeySize) {
+		t.Fatalf("expected short keys to be rejected, got %v", err)
+	}
+}
+
+func TestBlocksBindIndexAndRejectBlankRecords(t *testing.T) {
+	blocks, err := NewBlocks(testKey(3))
+	if err != nil {
+		t.Fatalf("blocks: %v", err)
+	}
+	record, err := blocks.Seal(4, []byte("ciphertext chunk"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if len(record) != RecordSize {
+		t.Fatalf("expected fixed-size records, got %d", len(record))
+	}
+	plaintext, err := blocks.Open(4, record)
+	if err != nil || string(plaintext) != "ciphertext chunk" {
+		t.Fatalf("unexpected open %q %v", plaintext, err)
+	}
+	if _, err := blocks.Open(5, record); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected blocks to be bound to their index, got %v", err)
+	}
+	for _, blank := range [][]byte{nil, make([]byte, 3), make([]byte, RecordSize)} {
+		if _, err := blocks.Open(0, blank); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("expected a %d-byte blank record to fail authentication, got %v", len(blank), err)
+		}
+	}
+	empty, err := blocks.Seal(0, nil)
+	if err != nil {
+		t.Fatalf("seal empty: %v", err)
+	}
+	if plaintext, err := blocks.Open(0, empty); err != nil || len(plaintext) != 0 {
+		t.Fatalf("expected a sealed empty block to open, got %q %v", plaintext, err)
+	}
+
+	extent, err := blocks.SealExtent(12345)
+	if err != nil {
+		t.Fatalf("seal extent: %v", err)
+	}
+	if size, err := blocks.OpenExtent(extent); err != nil || size != 12345 {
+		t.Fatalf("unexpected extent %d %v", size, err)
+	}
+	extent[len(extent)-1] ^= 1
+	if _, err := blocks.OpenExtent(extent); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected a tampered extent to fail authentication, got %v", err)
+	}
+	if _, err := blocks.OpenExtent(make([]byte, ExtentSize)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected a zeroed extent to fail authentication, got %v", err)
+	}
+}
@@ -0,0 +1,480 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+	"universaldrop/internal/logging"
+)
+
+const (
+	DefaultMaxFileBytes = 10 << 20
+	MinKeyBytes         = 32
+	DefaultSyncInterval = time.Second
+	filePrefix          = "audit-"
+	fileSuffix          = ".jsonl"
+	headFile            = "HEAD"
+	recoveredEvent      = "audit_recovered"
+)
+
+var GenesisHash = strings.Repeat("0", 64)
+
+var (
+	ErrBrokenChain = errors.New("audit chain broken")
+	ErrTruncated   = errors.New("audit log truncated")
+	ErrClosed      = errors.New("audit log closed")
+	ErrKeyTooShort = errors.New("audit key too short")
+)
+
+type Recorder interface {
+	Record(fields map[string]string) error
+	RecordDeferred(fields map[string]string) error
+}
+
+type Record struct {
+	Seq    uint64            `json:"seq"`
+	Time   string            `json:"time"`
+	Event  string            `json:"event"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Prev   string            `json:"prev"`
+	Hash   string            `json:"hash"`
+}
+
+func (r Record) digest(key []byte) string {
+	unsigned := r
+	unsigned.Hash = ""
+	body, _ := json.Marshal(unsigned)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	File string `json:"file"`
+}
+
+type Options struct {
+	MaxFileBytes int64
+	Clock        clock.Clock
+	Key          []byte
+	SyncInterval time.Duration
+}
+
+type Log struct {
+	mu       sync.Mutex
+	dir      string
+	opts     Options
+	file     *os.File
+	fileName string
+	fileSize int64
+	fileNum  int
+	seq      uint64
+	lastHash string
+	closed   bool
+	dirty    bool
+	syncing  *time.Timer
+}
+
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.MaxFileBytes <= 0 {
+		opts.MaxFileBytes = DefaultMaxFileBytes
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if len(opts.Key) < MinKeyBytes {
+		return nil, ErrKeyTooShort
+	}
+	opts.Key = append([]byte(nil), opts.Key...)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, opts: opts, lastHash: GenesisHash}
+	files, err := segmentFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return l, l.openSegment(1)
+	}
+	last := files[len(files)-1]
+	l.fileNum = segmentNumber(last)
+	records, complete, err := readSegment(filepath.Join(dir, last))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		tail := records[len(records)-1]
+		l.seq = tail.Seq
+		l.lastHash = tail.Hash
+	} else if state, err := readHead(dir); err == nil {
+		l.seq = state.Seq
+		l.lastHash = state.Hash
+	}
+	if err := l.openSegment(l.fileNum); err != nil {
+		return nil, err
+	}
+	if !complete {
+		dropped, err := truncatePartial(filepath.Join(dir, last))
+		if err != nil {
+			_ = l.file.Close()
+			return nil, err
+		}
+		l.fileSize -= dropped
+		if err := l.Record(map[string]string{
+			"event":  recoveredEvent,
+			"reason": "partial_record",
+			"count":  strconv.FormatInt(dropped, 10),
+		}); err != nil {
+			_ = l.file.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func truncatePartial(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	keep := int64(bytes.LastIndexByte(data, '\n') + 1)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	if err := file.Truncate(keep); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	return int64(len(data)) - keep, file.Close()
+}
+
+func (l *Log) Record(fields map[string]string) error {
+	if l == nil {
+		return nil
+	}
+	return l.append(fields, true)
+}
+
+func (l *Log) RecordDeferred(fields map[string]string) error {
+	if l == nil {
+		return nil
+	}
+	return l.append(fields, false)
+}
+
+func (l *Log) append(fields map[string]string, durable bool) error {
+	event := fields["event"]
+	if event == "" {
+		return nil
+	}
+	filtered := map[string]string{}
+	for key, value := range fields {
+		if key == "event" || value == "" || !logging.Allowed(key) {
+			continue
+		}
+		filtered[key] = value
+	}
+	if len(filtered) == 0 {
+		filtered = nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	record := Record{
+		Seq:    l.seq + 1,
+		Time:   l.opts.Clock.Now().UTC().Format(time.RFC3339Nano),
+		Event:  event,
+		Fields: filtered,
+		Prev:   l.lastHash,
+	}
+	record.Hash = record.digest(l.opts.Key)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if l.fileSize > 0 && l.fileSize+int64(len(line)) > l.opts.MaxFileBytes {
+		if err := l.openSegment(l.fileNum + 1); err != nil {
+			return err
+		}
+	}
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	l.fileSize += int64(len(line))
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+	l.dirty = true
+	if durable {
+		return l.syncLocked()
+	}
+	if l.syncing == nil {
+		l.syncing = time.AfterFunc(l.opts.SyncInterval, l.syncDeferred)
+	}
+	return nil
+}
+
+func (l *Log) syncDeferred() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syncing = nil
+	if !l.closed {
+		_ = l.syncLocked()
+	}
+}
+
+func (l *Log) syncLocked() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if err := writeHead(l.dir, head{Seq: l.seq, Hash: l.lastHash, File: l.fileName}); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.syncing != nil {
+		l.syncing.Stop()
+		l.syncing = nil
+	}
+	err := l.syncLocked()
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *Log) openSegment(num int) error {
+	if l.file != nil {
+		if l.dirty {
+			if err := l.file.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+	}
+	name := segmentName(num)
+	file, err := os.OpenFile(filepath.Join(l.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.file = file
+	l.fileName = name
+	l.fileNum = num
+	l.fileSize = info.Size()
+	return nil
+}
+
+type Report struct {
+	Files    int
+	Records  uint64
+	FirstSeq uint64
+	LastSeq  uint64
+	LastHash string
+}
+
+func Verify(dir string, key []byte) (Report, error) {
+	if len(key) < MinKeyBytes {
+		return Report{}, ErrKeyTooShort
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return Report{}, err
+	}
+	files, err := segmentFiles(dir)
+	if err != nil {
+		return Report{}, err
+	}
+	state, headErr := readHead(dir)
+	headHash := ""
+	report := Report{Files: len(files), LastHash: GenesisHash}
+	prev := GenesisHash
+	for i, name := range files {
+		if i > 0 && segmentNumber(name) != segmentNumber(files[i-1])+1 {
+			return report, fmt.Errorf("%w: segment %s missing before %s", ErrBrokenChain, segmentName(segmentNumber(files[i-1])+1), name)
+		}
+		records, complete, err := readSegment(filepath.Join(dir, name))
+		if err != nil {
+			return report, fmt.Errorf("%w: %s: %v", ErrBrokenChain, name, err)
+		}
+		if !complete {
+			return report, fmt.Errorf("%w: %s ends with a partial record", ErrTruncated, name)
+		}
+		for _, record := range records {
+			if report.Records == 0 {
+				report.FirstSeq = record.Seq
+				if record.Seq != 1 || record.Prev != GenesisHash {
+					return report, fmt.Errorf("%w: log starts at seq %d", ErrTruncated, record.Seq)
+				}
+			} else if record.Seq != report.LastSeq+1 {
+				return report, fmt.Errorf("%w: %s: seq %d follows %d", ErrBrokenChain, name, record.Seq, report.LastSeq)
+			}
+			if record.Prev != prev {
+				return report, fmt.Errorf("%w: %s: seq %d does not link to its predecessor", ErrBrokenChain, name, record.Seq)
+			}
+			if !hmac.Equal([]byte(record.digest(key)), []byte(record.Hash)) {
+				return report, fmt.Errorf("%w: %s: seq %d was modified", ErrBrokenChain, name, record.Seq)
+			}
+			if headErr == nil && record.Seq == state.Seq {
+				headHash = record.Hash
+			}
+			prev = record.Hash
+			report.Records++
+			report.LastSeq = record.Seq
+			report.LastHash = record.Hash
+		}
+	}
+	if errors.Is(headErr, os.ErrNotExist) {
+		if report.Records > 0 {
+			return report, fmt.Errorf("%w: head marker missing", ErrTruncated)
+		}
+		return report, nil
+	}
+	if headErr != nil {
+		return report, fmt.Errorf("%w: head marker unreadable", ErrBrokenChain)
+	}
+	if state.Seq > report.LastSeq {
+		return report, fmt.Errorf("%w: head marker expects seq %d, log ends at seq %d", ErrTruncated, state.Seq, report.LastSeq)
+	}
+	if state.Seq > 0 && state.Hash != headHash {
+		return report, fmt.Errorf("%w: head marker does not match seq %d", ErrBrokenChain, state.Seq)
+	}
+	return report, nil
+}
+
+func readSegment(path string) ([]Record, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	complete := len(data) == 0 || data[len(data)-1] == '\n'
+	var records []Record
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var record Record
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, false, fmt.Errorf("record %d unreadable", len(records)+1)
+		}
+		records = append(records, record)
+	}
+	return records, complete, nil
+}
+
+func segmentFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || segmentNumber(name) <= 0 {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return segmentNumber(names[i]) < segmentNumber(names[j])
+	})
+	return names, nil
+}
+
+func segmentName(num int) string {
+	return fmt.Sprintf("%s%06d%s", filePrefix, num, fileSuffix)
+}
+
+func segmentNumber(name string) int {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return 0
+	}
+	var num int
+	if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "%d", &num); err != nil {
+		return 0
+	}
+	return num
+}
+
+func readHead(dir string) (head, error) {
+	data, err := os.ReadFile(filepath.Join(dir, headFile))
+	if err != nil {
+		return head{}, err
+	}
+	var state head
+	if err := json.Unmarshal(data, &state); err != nil {
+		return head{}, err
+	}
+	return state, nil
+}
+
+func writeHead(dir string, state head) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, headFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, headFile))
+}
@@ -0,0 +1,308 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+var testKey = bytes.Repeat([]byte{0x41}, MinKeyBytes)
+
+func writeRecords(t *testing.T, dir string, count int) {
+	t.Helper()
+	log, err := Open(dir, Options{MaxFileBytes: 600, Clock: clock.NewFake(time.Unix(1700000000, 0)), Key: testKey})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		if err := log.Record(map[string]string{
+			"event":           "session_approved",
+			"session_id_hash": "abc123",
+			"session_id":      "raw-session-id",
+			"reason":          "",
+		}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestRecordsChainAcrossRotationAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	writeRecords(t, dir, 5)
+	writeRecords(t, dir, 5)
+
+	report, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if report.Records != 10 || report.LastSeq != 10 || report.Files < 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	files, _ := segmentFiles(dir)
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if bytes.Contains(data, []byte("raw-session-id")) || bytes.Contains(data, []byte(`"session_id"`)) {
+			t.Fatalf("raw id leaked into %s: %s", name, data)
+		}
+		if !bytes.Contains(data, []byte(`"session_id_hash":"abc123"`)) {
+			t.Fatalf("expected hashed field in %s", name)
+		}
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	cases := map[string]struct {
+		tamper func(t *testing.T, dir string, files []string)
+		want   error
+	}{
+		"modified field": {
+			tamper: func(t *testing.T, dir string, files []string) {
+				rewrite(t, filepath.Join(dir, files[1]), func(data []byte) []byte {
+					return bytes.Replace(data, []byte("session_approved"), []byte("session_rejected"), 1)
+				})
+			},
+			want: ErrBrokenChain,
+		},
+		"removed tail record": {
+			tamper: func(t *testing.T, dir string, files []string) {
+				rewrite(t, filepath.Join(dir, files[len(files)-1]), func(data []byte) []byte {
+					lines := strings.SplitAfter(string(data), "\n")
+					return []byte(strings.Join(lines[:len(lines)-2], ""))
+				})
+			},
+			want: ErrTruncated,
+		},
+		"partial record": {
+			tamper: func(t *testing.T, dir string, files []string) {
+				rewrite(t, filepath.Join(dir, files[len(files)-1]), func(data []byte) []byte {
+					return data[:len(data)-10]
+				})
+			},
+			want: ErrTruncated,
+		},
+		"missing middle segment": {
+			tamper: func(t *testing.T, dir string, files []string) {
+				_ = os.Remove(filepath.Join(dir, files[1]))
+			},
+			want: ErrBrokenChain,
+		},
+		"missing first segment": {
+			tamper: func(t *testing.T, dir string, files []string) {
+				_ = os.Remove(filepath.Join(dir, files[0]))
+			},
+			want: ErrTruncated,
+		},
+		"missing head": {
+			tamper: func(t *testing.T, dir string, files []string) {
+				_ = os.Remove(filepath.Join(dir, headFile))
+			},
+			want: ErrTruncated,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeRecords(t, dir, 10)
+			files, _ := segmentFiles(dir)
+			if len(files) < 3 {
+				t.Fatalf("expected rotation, got %v", files)
+			}
+			tc.tamper(t, dir, files)
+			if _, err := Verify(dir, testKey); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestOpenTruncatesPartialTailAndRecordsRecovery(t *testing.T) {
+	dir := t.TempDir()
+	writeRecords(t, dir, 10)
+	files, _ := segmentFiles(dir)
+	last := filepath.Join(dir, files[len(files)-1])
+	rewrite(t, last, func(data []byte) []byte {
+		return data[:len(data)-10]
+	})
+	if _, err := Verify(dir, testKey); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected partial tail to fail verification before reopen, got %v", err)
+	}
+
+	writeRecords(t, dir, 1)
+	report, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatalf("expected log to verify after crash recovery, got %v", err)
+	}
+	if report.Records != 11 {
+		t.Fatalf("expected 9 surviving records, a recovery marker and one new record, got %+v", report)
+	}
+	var recovered *Record
+	files, _ = segmentFiles(dir)
+	for _, name := range files {
+		records, _, err := readSegment(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read segment: %v", err)
+		}
+		for i := range records {
+			if records[i].Event == recoveredEvent {
+				recovered = &records[i]
+			}
+		}
+	}
+	if recovered == nil || recovered.Fields["reason"] != "partial_record" || recovered.Fields["count"] == "" {
+		t.Fatalf("expected a recovery marker in the chain, got %+v", recovered)
+	}
+}
+
+func TestVerifyRejectsChainForgedWithoutTheKey(t *testing.T) {
+	dir := t.TempDir()
+	writeRecords(t, dir, 4)
+	forgedKey := bytes.Repeat([]byte{0x42}, MinKeyBytes)
+
+	files, _ := segmentFiles(dir)
+	prev := GenesisHash
+	var last head
+	for _, name := range files {
+		records, _, err := readSegment(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read segment: %v", err)
+		}
+		var out []byte
+		for _, record := range records {
+			record.Event = "session_rejected"
+			record.Prev = prev
+			record.Hash = record.digest(forgedKey)
+			prev = record.Hash
+			line, _ := json.Marshal(record)
+			out = append(append(out, line...), '\n')
+			last = head{Seq: record.Seq, Hash: record.Hash, File: name}
+		}
+		rewrite(t, filepath.Join(dir, name), func([]byte) []byte { return out })
+	}
+	if err := writeHead(dir, last); err != nil {
+		t.Fatalf("write head: %v", err)
+	}
+
+	if _, err := Verify(dir, forgedKey); err != nil {
+		t.Fatalf("expected forged chain to be self-consistent under the forger's key, got %v", err)
+	}
+	if _, err := Verify(dir, testKey); !errors.Is(err, ErrBrokenChain) {
+		t.Fatalf("expected forged chain to fail under the real key, got %v", err)
+	}
+}
+
+func TestOpenRequiresKey(t *testing.T) {
+	if _, err := Open(t.TempDir(), Options{}); !errors.Is(err, ErrKeyTooShort) {
+		t.Fatalf("expected open without a key to fail, got %v", err)
+	}
+}
+
+func TestDeferredRecordsAreGroupCommitted(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, Options{Key: testKey, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := log.Record(map[string]string{"event": "session_approved"}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := log.RecordDeferred(map[string]string{"event": "capability_rejected", "reason": "signature"}); err != nil {
+			t.Fatalf("record deferred: %v", err)
+		}
+	}
+	if state, err := readHead(dir); err != nil || state.Seq != 1 {
+		t.Fatalf("expected deferred records to skip the head update, got %+v err=%v", state, err)
+	}
+	if report, err := Verify(dir, testKey); err != nil || report.LastSeq != 4 {
+		t.Fatalf("expected a lagging head to verify, got %+v err=%v", report, err)
+	}
+
+	if err := log.Record(map[string]string{"event": "transfer_receipt"}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if state, _ := readHead(dir); state.Seq != 5 {
+		t.Fatalf("expected a durable record to commit the deferred ones, got seq %d", state.Seq)
+	}
+	if err := log.RecordDeferred(map[string]string{"event": "capability_rejected"}); err != nil {
+		t.Fatalf("record deferred: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if state, _ := readHead(dir); state.Seq != 6 {
+		t.Fatalf("expected close to commit pending records, got seq %d", state.Seq)
+	}
+
+	timed, err := Open(dir, Options{Key: testKey, SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer timed.Close()
+	if err := timed.RecordDeferred(map[string]string{"event": "capability_rejected"}); err != nil {
+		t.Fatalf("record deferred: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if state, _ := readHead(dir); state.Seq == 7 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the sync interval to commit deferred records")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestVerifyRejectsHeadThatDoesNotMatchTheChain(t *testing.T) {
+	dir := t.TempDir()
+	writeRecords(t, dir, 4)
+	state, err := readHead(dir)
+	if err != nil {
+		t.Fatalf("read head: %v", err)
+	}
+	state.Seq = 2
+	if err := writeHead(dir, state); err != nil {
+		t.Fatalf("write head: %v", err)
+	}
+	if _, err := Verify(dir, testKey); !errors.Is(err, ErrBrokenChain) {
+		t.Fatalf("expected mismatched head to fail, got %v", err)
+	}
+}
+
+func rewrite(t *testing.T, path string, change func([]byte) []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := os.WriteFile(path, change(data), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestNilLogIsANoopRecorder(t *testing.T) {
+	var disabled *Log
+	var recorder Recorder = disabled
+	if err := recorder.Record(map[string]string{"event": "capability_rejected", "reason": "expired"}); err != nil {
+		t.Fatalf("expected a disabled log to ignore records, got %v", err)
+	}
+	if err := recorder.RecordDeferred(map[string]string{"event": "capability_rejected", "reason": "expired"}); err != nil {
+		t.Fatalf("expected a disabled log to ignore deferred records, got %v", err)
+	}
+	if err := disabled.Close(); err != nil {
+		t.Fatalf("expected closing a disabled log to succeed, got %v", err)
+	}
+}
@@ -32,6 +32,29 @@ const (
 	ScopeTransferSignal        = "xfer.signal"
 )
 
+const (
+	ReasonMalformed    = "malformed"
+	ReasonSignature    = "signature"
+	ReasonVersion      = "version"
+	ReasonExpired      = "expired"
+	ReasonRevoked      = "revoked"
+	ReasonReplayed     = "replayed"
+	ReasonScope        = "scope"
+	ReasonSession      = "session"
+	ReasonClaim        = "claim"
+	ReasonTransfer     = "transfer"
+	ReasonPeer         = "peer"
+	ReasonSenderKey    = "sender_key"
+	ReasonReceiverKey  = "receiver_key"
+	ReasonManifestHash = "manifest_hash"
+	ReasonVisibility   = "visibility"
+	ReasonMaxBytes     = "max_bytes"
+	ReasonRequestBytes = "request_bytes"
+	ReasonMaxRate      = "max_rate"
+	ReasonSingleUse    = "single_use"
+	ReasonRoute        = "route"
+)
+
 type Claims struct {
 	Scope             string   `json:"scope"`
 	Exp               int64    `json:"exp"`
@@ -92,6 +115,7 @@ type RevocationStore interface {
 	RevokeGlobal()
 	RevokeJTI(jti string, exp time.Time)
 	UseJTI(jti string, exp time.Time) bool
+	JTIUsed(jti string) bool
 	IsRevoked(claims Claims) bool
 }
 
@@ -166,6 +190,18 @@ func (m *MemoryRevocationStore) UseJTI(jti string, exp time.Time) bool {
 	return true
 }
 
+func (m *MemoryRevocationStore) JTIUsed(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	now := m.clock.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanupLocked(now)
+	_, used := m.usedJTIs[jti]
+	return used
+}
+
 func (m *MemoryRevocationStore) IsRevoked(claims Claims) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -206,9 +242,12 @@ func (m *MemoryRevocationStore) cleanupLocked(now time.Time) {
 }
 
 type Service struct {
-	secret      []byte
-	clock       clock.Clock
-	revocations RevocationStore
+	mu            sync.RWMutex
+	secret        []byte
+	previous      []byte
+	previousUntil time.Time
+	clock         clock.Clock
+	revocations   RevocationStore
 }
 
 func NewService(secret []byte, clk clock.Clock, revocations RevocationStore) *Service {
@@ -230,6 +269,21 @@ func NewService(secret []byte, clk clock.Clock, revocations RevocationStore) *Se
 	}
 }
 
+func (s *Service) Rotate(secret []byte, grace time.Duration) bool {
+	if len(secret) < minSecretBytes {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hmac.Equal(secret, s.secret) {
+		return false
+	}
+	s.previous = s.secret
+	s.previousUntil = s.clock.Now().UTC().Add(grace)
+	s.secret = append([]byte(nil), secret...)
+	return true
+}
+
 func (s *Service) RevokeTransfer(transferID string) {
 	if s.revocations == nil {
 		return
@@ -280,77 +334,121 @@ func (s *Service) Issue(spec IssueSpec) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	s.mu.RLock()
 	signature := signHMAC(payload, s.secret)
+	s.mu.RUnlock()
 	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
 }
 
 func (s *Service) Validate(token string, req Requirement) (Claims, bool) {
-	payload, ok := parseToken(token, s.secret)
-	if !ok {
+	payload, reason := s.Check(token, req)
+	if reason != "" {
 		return Claims{}, false
 	}
+	return payload, true
+}
+
+func (s *Service) Check(token string, req Requirement) (Claims, string) {
+	return s.check(token, req, true)
+}
+
+func (s *Service) Inspect(token string, req Requirement) (Claims, string) {
+	return s.check(token, req, false)
+}
+
+func (s *Service) Consume(payload Claims) string {
+	if s.revocations == nil {
+		return ""
+	}
+	if !s.revocations.UseJTI(payload.Jti, time.Unix(payload.Exp, 0).UTC()) {
+		return ReasonReplayed
+	}
+	return ""
+}
+
+func (s *Service) check(token string, req Requirement, consume bool) (Claims, string) {
+	now := s.clock.Now().UTC()
+	s.mu.RLock()
+	payload, reason := parseToken(token, s.secret)
+	if reason == ReasonSignature && len(s.previous) > 0 && now.Before(s.previousUntil) {
+		payload, reason = parseToken(token, s.previous)
+	}
+	s.mu.RUnlock()
+	if reason != "" {
+		return Claims{}, reason
+	}
 	if payload.V != capabilityVersion {
-		return Claims{}, false
+		return Claims{}, ReasonVersion
 	}
 	if payload.Exp > 0 && payload.Exp < s.clock.Now().UTC().Unix() {
-		return Claims{}, false
+		return payload, ReasonExpired
 	}
-	if !s.ValidateClaims(payload, req) {
-		return Claims{}, false
+	if reason := s.CheckClaims(payload, req); reason != "" {
+		return payload, reason
 	}
 	if s.revocations != nil {
 		if s.revocations.IsRevoked(payload) {
-			return Claims{}, false
+			return payload, ReasonRevoked
 		}
 		if req.SingleUse {
+			if !consume {
+				if s.revocations.JTIUsed(payload.Jti) {
+					return payload, ReasonReplayed
+				}
+				return payload, ""
+			}
 			exp := time.Unix(payload.Exp, 0).UTC()
 			if !s.revocations.UseJTI(payload.Jti, exp) {
-				return Claims{}, false
+				return payload, ReasonReplayed
 			}
 		}
 	}
-	return payload, true
+	return payload, ""
 }
 
 func (s *Service) ValidateClaims(payload Claims, req Requirement) bool {
+	return s.CheckClaims(payload, req) == ""
+}
+
+func (s *Service) CheckClaims(payload Claims, req Requirement) string {
 	if req.Scope != "" && payload.Scope != req.Scope {
-		return false
+		return ReasonScope
 	}
 	if req.SessionID != "" && payload.SessionID != req.SessionID {
-		return false
+		return ReasonSession
 	}
 	if req.ClaimID != "" && payload.ClaimID != req.ClaimID {
-		return false
+		return ReasonClaim
 	}
 	if req.TransferID != "" && payload.TransferID != req.TransferID {
-		return false
+		return ReasonTransfer
 	}
 	if req.PeerID != "" && payload.PeerID != req.PeerID {
-		return false
+		return ReasonPeer
 	}
 	if req.SenderPubKeyB64 != "" && payload.SenderPubKeyB64 != req.SenderPubKeyB64 {
-		return false
+		return ReasonSenderKey
 	}
 	if req.ReceiverPubKeyB64 != "" && payload.ReceiverPubKeyB64 != req.ReceiverPubKeyB64 {
-		return false
+		return ReasonReceiverKey
 	}
 	if req.ManifestHash != "" && payload.ManifestHash != req.ManifestHash {
-		return false
+		return ReasonManifestHash
 	}
 	if req.Visibility != "" && payload.Visibility != req.Visibility {
-		return false
+		return ReasonVisibility
 	}
 	if req.MaxBytes > 0 && payload.MaxBytes > 0 && payload.MaxBytes != req.MaxBytes {
-		return false
+		return ReasonMaxBytes
 	}
 	if req.RequestBytes > 0 && payload.MaxBytes > 0 && req.RequestBytes > payload.MaxBytes {
-		return false
+		return ReasonRequestBytes
 	}
 	if req.MaxRateBps > 0 && payload.MaxRateBps > 0 && payload.MaxRateBps != req.MaxRateBps {
-		return false
+		return ReasonMaxRate
 	}
 	if req.SingleUse && !payload.SingleUse {
-		return false
+		return ReasonSingleUse
 	}
 	if req.Route != "" && len(payload.AllowedRoutes) > 0 {
 		allowed := false
@@ -361,37 +459,37 @@ func (s *Service) ValidateClaims(payload Claims, req Requirement) bool {
 			}
 		}
 		if !allowed {
-			return false
+			return ReasonRoute
 		}
 	}
-	return true
+	return ""
 }
 
-func parseToken(token string, secret []byte) (Claims, bool) {
+func parseToken(token string, secret []byte) (Claims, string) {
 	if strings.Count(token, ".") != 1 {
-		return Claims{}, false
+		return Claims{}, ReasonMalformed
 	}
 	parts := strings.Split(token, ".")
 	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return Claims{}, false
+		return Claims{}, ReasonMalformed
 	}
 	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
-		return Claims{}, false
+		return Claims{}, ReasonMalformed
 	}
 	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return Claims{}, false
+		return Claims{}, ReasonMalformed
 	}
 	expected := signHMAC(payloadBytes, secret)
 	if !hmac.Equal(signature, expected) {
-		return Claims{}, false
+		return Claims{}, ReasonSignature
 	}
 	var payload Claims
 	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-		return Claims{}, false
+		return Claims{}, ReasonMalformed
 	}
-	return payload, true
+	return payload, ""
 }
 
 func signHMAC(payload []byte, secret []byte) []byte {
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+func TestServiceIssueValidateRestart(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x11}, 32)
+	svc1 := NewService(secret, nil, nil)
+	token, err := svc1.Issue(IssueSpec{Scope: ScopeSessionClaim, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+
+	svc2 := NewService(secret, nil, nil)
+	if _, reason := svc2.Check(token, Requirement{Scope: ScopeSessionClaim}); reason != "" {
+		t.Fatalf("expected token to validate across restarts, got %q", reason)
+	}
+}
+
+func TestServiceRejectsWrongScope(t *testing.T) {
+	svc := NewService(bytes.Repeat([]byte{0x22}, 32), nil, nil)
+	token, err := svc.Issue(IssueSpec{Scope: ScopeSessionClaim, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	if _, reason := svc.Check(token, Requirement{Scope: ScopeSessionApprove}); reason != ReasonScope {
+		t.Fatalf("expected scope rejection, got %q", reason)
+	}
+}
+
+func TestServiceRejectsExpiredToken(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	svc := NewService(bytes.Repeat([]byte{0x33}, 32), fakeClock, nil)
+	token, err := svc.Issue(IssueSpec{Scope: ScopeSessionClaim, TTL: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	if _, reason := svc.Check(token, Requirement{Scope: ScopeSessionClaim}); reason != "" {
+		t.Fatalf("expected fresh token to validate, got %q", reason)
+	}
+	fakeClock.Advance(11 * time.Second)
+	if _, reason := svc.Check(token, Requirement{Scope: ScopeSessionClaim}); reason != ReasonExpired {
+		t.Fatalf("expected expiry rejection, got %q", reason)
+	}
+}
+
+func TestServiceRejectsUnknownVersion(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x44}, 32)
+	svc := NewService(secret, nil, nil)
+	payload, err := json.Marshal(Claims{
+		Scope: ScopeSessionClaim,
+		Exp:   time.Now().Add(time.Minute).Unix(),
+		Iat:   time.Now().Unix(),
+		Jti:   "jti",
+		V:     capabilityVersion + 1,
+	})
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signHMAC(payload, secret))
+	if _, reason := svc.Check(token, Requirement{Scope: ScopeSessionClaim}); reason != ReasonVersion {
+		t.Fatalf("expected version rejection, got %q", reason)
+	}
+}
+
+func TestServiceRejectsTamperedToken(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x55}, 32)
+	svc := NewService(secret, nil, nil)
+	token, err := svc.Issue(IssueSpec{Scope: ScopeSessionClaim, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	if _, reason := svc.Check(tamperTokenPayload(token), Requirement{Scope: ScopeSessionClaim}); reason == "" {
+		t.Fatalf("expected tampered token to fail validation")
+	}
+
+	other := NewService(bytes.Repeat([]byte{0x66}, 32), nil, nil)
+	if _, reason := other.Check(token, Requirement{Scope: ScopeSessionClaim}); reason != ReasonSignature {
+		t.Fatalf("expected foreign secret to fail signature, got %q", reason)
+	}
+
+	for _, malformed := range []string{"", "abc", "a.b.c", ".sig", "payload.", "!!!.@@@"} {
+		if _, reason := svc.Check(malformed, Requirement{Scope: ScopeSessionClaim}); reason != ReasonMalformed {
+			t.Fatalf("expected %q to be malformed, got %q", malformed, reason)
+		}
+	}
+}
+
+func TestLoadOrCreateSecretUsesLegacyPath(t *testing.T) {
+	t.Setenv(secretEnv, "")
+	dataDir := t.TempDir()
+	legacy := bytes.Repeat([]byte{0x77}, 32)
+	path := filepath.Join(dataDir, "secrets", "token_hmac.key")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, legacy, 0o600); err != nil {
+		t.Fatalf("write legacy secret: %v", err)
+	}
+
+	secret, err := LoadOrCreateSecret(dataDir)
+	if err != nil {
+		t.Fatalf("load secret: %v", err)
+	}
+	if !bytes.Equal(secret, legacy) {
+		t.Fatalf("expected legacy secret file to be used")
+	}
+
+	fresh := t.TempDir()
+	created, err := LoadOrCreateSecret(fresh)
+	if err != nil {
+		t.Fatalf("create secret: %v", err)
+	}
+	if len(created) != minSecretBytes {
+		t.Fatalf("expected %d byte secret, got %d", minSecretBytes, len(created))
+	}
+	if _, err := os.Stat(SecretPath(fresh)); err != nil {
+		t.Fatalf("expected secret file to be created: %v", err)
+	}
+}
+
+func TestLoadOrCreateSecretPrefersEnv(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x88}, 32)
+	t.Setenv(secretEnv, base64.RawURLEncoding.EncodeToString(secret))
+	loaded, err := LoadOrCreateSecret(t.TempDir())
+	if err != nil {
+		t.Fatalf("load secret: %v", err)
+	}
+	if !bytes.Equal(loaded, secret) {
+		t.Fatalf("expected env secret to be used")
+	}
+
+	t.Setenv(secretEnv, base64.RawURLEncoding.EncodeToString([]byte("short")))
+	if _, err := LoadOrCreateSecret(t.TempDir()); err == nil {
+		t.Fatalf("expected short env secret to be rejected")
+	}
+}
+
+func tamperTokenPayload(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || parts[0] == "" {
+		return token
+	}
+	payload := []byte(parts[0])
+	replacement := byte('a')
+	if payload[0] == 'a' {
+		replacement = 'b'
+	}
+	payload[0] = replacement
+	parts[0] = string(payload)
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+const revocationSnapshotVersion = 1
+
+type revocationSnapshot struct {
+	V                int                  `json:"v"`
+	SavedAt          time.Time            `json:"saved_at"`
+	RevokedJTIs      map[string]time.Time `json:"revoked_jtis"`
+	UsedJTIs         map[string]time.Time `json:"used_jtis"`
+	RevokedTransfers map[string]time.Time `json:"revoked_transfers"`
+	RevokedDevices   map[string]time.Time `json:"revoked_devices"`
+	GlobalRevoked    bool                 `json:"global_revoked"`
+}
+
+type FileRevocationStore struct {
+	*MemoryRevocationStore
+	path    string
+	flushMu sync.Mutex
+}
+
+func NewFileRevocationStore(path string, clk clock.Clock) (*FileRevocationStore, error) {
+	store := &FileRevocationStore{
+		MemoryRevocationStore: NewMemoryRevocationStore(clk),
+		path:                  path,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (f *FileRevocationStore) load() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var snap revocationSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.V != revocationSnapshotVersion {
+		return errors.New("unsupported revocation snapshot version")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copyTimes(f.revokedJTIs, snap.RevokedJTIs)
+	copyTimes(f.usedJTIs, snap.UsedJTIs)
+	copyTimes(f.revokedTransfers, snap.RevokedTransfers)
+	copyTimes(f.revokedDevices, snap.RevokedDevices)
+	f.globalRevoked = snap.GlobalRevoked
+	f.cleanupLocked(f.clock.Now().UTC())
+	return nil
+}
+
+func (f *FileRevocationStore) Flush(_ context.Context) error {
+	f.flushMu.Lock()
+	defer f.flushMu.Unlock()
+
+	f.mu.Lock()
+	now := f.clock.Now().UTC()
+	f.cleanupLocked(now)
+	snap := revocationSnapshot{
+		V:                revocationSnapshotVersion,
+		SavedAt:          now,
+		RevokedJTIs:      make(map[string]time.Time, len(f.revokedJTIs)),
+		UsedJTIs:         make(map[string]time.Time, len(f.usedJTIs)),
+		RevokedTransfers: make(map[string]time.Time, len(f.revokedTransfers)),
+		RevokedDevices:   make(map[string]time.Time, len(f.revokedDevices)),
+		GlobalRevoked:    f.globalRevoked,
+	}
+	copyTimes(snap.RevokedJTIs, f.revokedJTIs)
+	copyTimes(snap.UsedJTIs, f.usedJTIs)
+	copyTimes(snap.RevokedTransfers, f.revokedTransfers)
+	copyTimes(snap.RevokedDevices, f.revokedDevices)
+	f.mu.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *FileRevocationStore) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = f.Flush(ctx)
+		}
+	}
+}
+
+func copyTimes(dst map[string]time.Time, src map[string]time.Time) {
+	for key, value := range src {
+		dst[key] = value
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+func TestFileRevocationStoreSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "revocations", "revocations.json")
+	secret := bytes.Repeat([]byte{0x55}, 32)
+
+	store, err := NewFileRevocationStore(path, clk)
+	if err != nil {
+		t.Fatalf("new revocation store: %v", err)
+	}
+	svc := NewService(secret, clk, store)
+	single, err := svc.Issue(IssueSpec{Scope: ScopeTransferDownload, TTL: time.Minute, SingleUse: true})
+	if err != nil {
+		t.Fatalf("issue single-use token: %v", err)
+	}
+	transfer, err := svc.Issue(IssueSpec{Scope: ScopeTransferReceive, TransferID: "t1", TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("issue transfer token: %v", err)
+	}
+	if _, reason := svc.Check(single, Requirement{Scope: ScopeTransferDownload, SingleUse: true}); reason != "" {
+		t.Fatalf("expected first use to pass, got %q", reason)
+	}
+	svc.RevokeTransfer("t1")
+	if err := store.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	reopened, err := NewFileRevocationStore(path, clk)
+	if err != nil {
+		t.Fatalf("reopen revocation store: %v", err)
+	}
+	restarted := NewService(secret, clk, reopened)
+	if _, reason := restarted.Check(single, Requirement{Scope: ScopeTransferDownload, SingleUse: true}); reason != ReasonReplayed {
+		t.Fatalf("expected consumed token to stay consumed across restart, got %q", reason)
+	}
+	if _, reason := restarted.Check(transfer, Requirement{Scope: ScopeTransferReceive, TransferID: "t1"}); reason != ReasonRevoked {
+		t.Fatalf("expected transfer revocation to survive restart, got %q", reason)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if err := reopened.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	pruned, err := NewFileRevocationStore(path, clk)
+	if err != nil {
+		t.Fatalf("reopen revocation store: %v", err)
+	}
+	if len(pruned.usedJTIs) != 0 {
+		t.Fatalf("expected expired single-use entries to be pruned, got %d", len(pruned.usedJTIs))
+	}
+}
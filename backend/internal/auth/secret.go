@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"universaldrop/internal/secrets"
+)
+
+const secretEnv = "UD_TOKEN_HMAC_SECRET_B64"
+
+func LoadOrCreateSecret(dataDir string) ([]byte, error) {
+	provider, err := NewSecretProvider(dataDir, secrets.Spec{})
+	if err != nil {
+		return nil, err
+	}
+	return provider.Load(context.Background())
+}
+
+func NewSecretProvider(dataDir string, spec secrets.Spec) (secrets.SecretProvider, error) {
+	spec.MinBytes = minSecretBytes
+	switch spec.Provider {
+	case "":
+		if os.Getenv(secretEnv) != "" {
+			return secrets.EnvProvider{Key: secretEnv, MinBytes: minSecretBytes}, nil
+		}
+		if dataDir == "" {
+			return nil, errors.New("data dir required for token secret")
+		}
+		return secrets.FileProvider{
+			Path:        SecretPath(dataDir),
+			MinBytes:    minSecretBytes,
+			CreateBytes: minSecretBytes,
+		}, nil
+	case secrets.ProviderEnv:
+		if spec.Env == "" {
+			spec.Env = secretEnv
+		}
+	case secrets.ProviderFile:
+		if spec.Path == "" {
+			if dataDir == "" {
+				return nil, errors.New("data dir required for token secret")
+			}
+			spec.Path = SecretPath(dataDir)
+			spec.CreateBytes = minSecretBytes
+		}
+	}
+	return secrets.NewProvider(spec)
+}
+
+func SecretPath(dataDir string) string {
+	return filepath.Join(dataDir, "secrets", "token_hmac.key")
+}
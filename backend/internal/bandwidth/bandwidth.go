@@ -0,0 +1,370 @@
+package bandwidth
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const DefaultQuantum = 16 << 10
+
+type Options struct {
+	GlobalBps  int64
+	PerFlowBps int64
+	Quantum    int
+	OnQueued   func(wait time.Duration)
+}
+
+type Scheduler struct {
+	mu        sync.Mutex
+	opts      Options
+	flows     map[string]*flow
+	queue     requestQueue
+	virtual   float64
+	linkFree  time.Time
+	timer     *time.Timer
+	lastPrune time.Time
+}
+
+type flow struct {
+	weight  float64
+	finish  float64
+	next    time.Time
+	pending int
+}
+
+type request struct {
+	flow     string
+	bytes    int
+	tag      float64
+	seq      uint64
+	queuedAt time.Time
+	done     chan time.Time
+	index    int
+}
+
+func New(opts Options) *Scheduler {
+	if opts.Quantum <= 0 {
+		opts.Quantum = DefaultQuantum
+	}
+	return &Scheduler{
+		opts:  opts,
+		flows: map[string]*flow{},
+	}
+}
+
+func (s *Scheduler) Enabled() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.GlobalBps > 0 || s.opts.PerFlowBps > 0
+}
+
+func (s *Scheduler) SetRates(globalBps, perFlowBps int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.GlobalBps = globalBps
+	s.opts.PerFlowBps = perFlowBps
+	now := time.Now()
+	if globalBps > 0 {
+		if s.linkFree.After(now) {
+			s.linkFree = now
+		}
+		s.dispatchLocked(now)
+		return
+	}
+	for s.queue.Len() > 0 {
+		req := heap.Pop(&s.queue).(*request)
+		s.releaseLocked(req.flow)
+		req.done <- now
+	}
+	s.dispatchLocked(now)
+}
+
+func (s *Scheduler) Wait(ctx context.Context, flowID string, n int) error {
+	return s.wait(ctx, flowID, n, 0)
+}
+
+func (s *Scheduler) wait(ctx context.Context, flowID string, n int, maxBps int64) error {
+	if !s.limits(maxBps) || n <= 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	s.pruneLocked(now)
+	f := s.flowLocked(flowID)
+	var flowReady time.Time
+	perFlow := s.opts.PerFlowBps
+	if maxBps > 0 && (perFlow <= 0 || maxBps < perFlow) {
+		perFlow = maxBps
+	}
+	if perFlow > 0 {
+		start := f.next
+		if start.Before(now) {
+			start = now
+		}
+		f.next = start.Add(transmit(n, perFlow))
+		flowReady = f.next
+	}
+	if s.opts.GlobalBps <= 0 {
+		s.mu.Unlock()
+		return sleepUntil(ctx, flowReady)
+	}
+
+	start := s.virtual
+	if f.finish > start {
+		start = f.finish
+	}
+	f.finish = start + float64(n)/f.weight
+	f.pending++
+	s.queue.seq++
+	req := &request{
+		flow:     flowID,
+		bytes:    n,
+		tag:      f.finish,
+		seq:      s.queue.seq,
+		queuedAt: now,
+		done:     make(chan time.Time, 1),
+	}
+	heap.Push(&s.queue, req)
+	s.dispatchLocked(now)
+	s.mu.Unlock()
+
+	var linkReady time.Time
+	select {
+	case linkReady = <-req.done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		if req.index >= 0 {
+			heap.Remove(&s.queue, req.index)
+			s.releaseLocked(req.flow)
+			s.dispatchLocked(time.Now())
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+	if linkReady.Before(flowReady) {
+		linkReady = flowReady
+	}
+	return sleepUntil(ctx, linkReady)
+}
+
+func (s *Scheduler) SetWeight(flowID string, weight float64) {
+	if !s.Enabled() || weight <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flowLocked(flowID).weight = weight
+}
+
+func (s *Scheduler) Forget(flowID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.flows[flowID]; ok && f.pending == 0 {
+		delete(s.flows, flowID)
+	}
+}
+
+func (s *Scheduler) Flows() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.flows)
+}
+
+func (s *Scheduler) Reader(ctx context.Context, flowID string, maxBps int64, r io.Reader) io.Reader {
+	if !s.limits(maxBps) {
+		return r
+	}
+	return &reader{ctx: ctx, scheduler: s, flow: flowID, maxBps: maxBps, r: r}
+}
+
+func (s *Scheduler) Writer(ctx context.Context, flowID string, maxBps int64, w io.Writer) io.Writer {
+	if !s.limits(maxBps) {
+		return w
+	}
+	return &writer{ctx: ctx, scheduler: s, flow: flowID, maxBps: maxBps, w: w}
+}
+
+func (s *Scheduler) limits(maxBps int64) bool {
+	return s != nil && (maxBps > 0 || s.Enabled())
+}
+
+func (s *Scheduler) flowLocked(flowID string) *flow {
+	f := s.flows[flowID]
+	if f == nil {
+		f = &flow{weight: 1}
+		s.flows[flowID] = f
+	}
+	return f
+}
+
+func (s *Scheduler) releaseLocked(flowID string) {
+	f := s.flows[flowID]
+	if f == nil {
+		return
+	}
+	f.pending--
+}
+
+func (s *Scheduler) dispatchLocked(now time.Time) {
+	for s.queue.Len() > 0 && !s.linkFree.After(now) {
+		req := heap.Pop(&s.queue).(*request)
+		s.releaseLocked(req.flow)
+		s.virtual = req.tag
+		start := s.linkFree
+		if start.Before(now) {
+			start = now
+		}
+		s.linkFree = start.Add(transmit(req.bytes, s.opts.GlobalBps))
+		if s.opts.OnQueued != nil {
+			s.opts.OnQueued(start.Sub(req.queuedAt))
+		}
+		req.done <- s.linkFree
+	}
+	if s.queue.Len() == 0 {
+		s.virtual = 0
+		for _, f := range s.flows {
+			f.finish = 0
+		}
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.linkFree.Sub(now), s.tick)
+	}
+}
+
+func (s *Scheduler) pruneLocked(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Second {
+		return
+	}
+	s.lastPrune = now
+	for id, f := range s.flows {
+		if f.pending == 0 && f.weight == 1 && f.finish <= s.virtual && !f.next.After(now) {
+			delete(s.flows, id)
+		}
+	}
+}
+
+func (s *Scheduler) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer = nil
+	s.dispatchLocked(time.Now())
+}
+
+func transmit(n int, bps int64) time.Duration {
+	return time.Duration(float64(n) / float64(bps) * float64(time.Second))
+}
+
+func sleepUntil(ctx context.Context, deadline time.Time) error {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type requestQueue struct {
+	items []*request
+	seq   uint64
+}
+
+func (q requestQueue) Len() int {
+	return len(q.items)
+}
+
+func (q requestQueue) Less(i, j int) bool {
+	if q.items[i].tag != q.items[j].tag {
+		return q.items[i].tag < q.items[j].tag
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q requestQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *requestQueue) Push(x any) {
+	req := x.(*request)
+	req.index = len(q.items)
+	q.items = append(q.items, req)
+}
+
+func (q *requestQueue) Pop() any {
+	n := len(q.items)
+	req := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	req.index = -1
+	return req
+}
+
+type reader struct {
+	ctx       context.Context
+	scheduler *Scheduler
+	flow      string
+	maxBps    int64
+	r         io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.scheduler.opts.Quantum {
+		p = p[:r.scheduler.opts.Quantum]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.scheduler.wait(r.ctx, r.flow, n, r.maxBps); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx       context.Context
+	scheduler *Scheduler
+	flow      string
+	maxBps    int64
+	w         io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.scheduler.opts.Quantum {
+			chunk = chunk[:w.scheduler.opts.Quantum]
+		}
+		if err := w.scheduler.wait(w.ctx, w.flow, len(chunk), w.maxBps); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
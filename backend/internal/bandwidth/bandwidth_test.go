@@ -0,0 +1,250 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		queued := s.queue.Len()
+		s.mu.Unlock()
+		if queued >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d queued requests", n)
+}
+
+func waitBusy(t *testing.T, s *Scheduler) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		busy := s.linkFree.After(time.Now())
+		s.mu.Unlock()
+		if busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected link to be busy")
+}
+
+func TestSchedulerSharesLinkFairlyBetweenFlows(t *testing.T) {
+	var mu sync.Mutex
+	var queued []time.Duration
+	s := New(Options{GlobalBps: 10000, OnQueued: func(wait time.Duration) {
+		mu.Lock()
+		queued = append(queued, wait)
+		mu.Unlock()
+	}})
+	ctx := context.Background()
+	if err := s.Wait(ctx, "warmup", 200); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+
+	blockerDone := make(chan struct{})
+	go func() {
+		_ = s.Wait(ctx, "blocker", 200)
+		close(blockerDone)
+	}()
+	waitBusy(t, s)
+
+	var orderMu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(flow string, expected int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Wait(ctx, flow, 100); err != nil {
+				t.Errorf("wait %s: %v", flow, err)
+				return
+			}
+			orderMu.Lock()
+			order = append(order, flow)
+			orderMu.Unlock()
+		}()
+		waitQueued(t, s, expected)
+	}
+	enqueue("a", 1)
+	enqueue("a", 2)
+	enqueue("a", 3)
+	enqueue("b", 4)
+	enqueue("b", 5)
+	wg.Wait()
+	<-blockerDone
+
+	want := []string{"a", "b", "a", "b", "a"}
+	if len(order) != len(want) {
+		t.Fatalf("expected %d completions, got %v", len(want), order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected interleaved order %v, got %v", want, order)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queued) != 7 {
+		t.Fatalf("expected queue delay observed for every request, got %d", len(queued))
+	}
+	if queued[len(queued)-1] <= 0 {
+		t.Fatalf("expected later requests to report queueing delay")
+	}
+}
+
+func TestSchedulerWeightsFlows(t *testing.T) {
+	s := New(Options{GlobalBps: 10000})
+	s.SetWeight("heavy", 2)
+	ctx := context.Background()
+	go func() { _ = s.Wait(ctx, "blocker", 200) }()
+	waitBusy(t, s)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, flow := range []string{"light", "light", "heavy", "heavy", "heavy"} {
+		wg.Add(1)
+		go func(flow string) {
+			defer wg.Done()
+			_ = s.Wait(ctx, flow, 100)
+			mu.Lock()
+			order = append(order, flow)
+			mu.Unlock()
+		}(flow)
+		waitQueued(t, s, i+1)
+	}
+	wg.Wait()
+	want := []string{"heavy", "light", "heavy", "heavy", "light"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected double-weight flow to get twice the share %v, got %v", want, order)
+		}
+	}
+}
+
+func TestSchedulerHonorsCancellation(t *testing.T) {
+	s := New(Options{PerFlowBps: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Wait(ctx, "slow", 100); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("expected cancellation to interrupt the wait")
+	}
+
+	global := New(Options{GlobalBps: 10})
+	busyCtx, stopBusy := context.WithCancel(context.Background())
+	defer stopBusy()
+	go func() { _ = global.Wait(busyCtx, "busy", 100) }()
+	waitBusy(t, global)
+	queuedCtx, stopQueued := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- global.Wait(queuedCtx, "queued", 100) }()
+	waitQueued(t, global, 1)
+	stopQueued()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	global.mu.Lock()
+	remaining := global.queue.Len()
+	global.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("expected canceled request to leave the queue, got %d", remaining)
+	}
+}
+
+func TestSchedulerSetRatesReleasesQueuedRequests(t *testing.T) {
+	s := New(Options{GlobalBps: 10})
+	busyCtx, stopBusy := context.WithCancel(context.Background())
+	defer stopBusy()
+	go func() { _ = s.Wait(busyCtx, "busy", 100) }()
+	waitBusy(t, s)
+	errCh := make(chan error, 2)
+	for _, flow := range []string{"a", "b"} {
+		flow := flow
+		go func() { errCh <- s.Wait(context.Background(), flow, 100) }()
+	}
+	waitQueued(t, s, 2)
+
+	s.SetRates(0, 0)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatalf("expected released wait to succeed, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected queued requests to be released")
+		}
+	}
+	if s.Enabled() {
+		t.Fatalf("expected zero rates to disable the scheduler")
+	}
+
+	s.SetRates(1_000_000, 0)
+	if !s.Enabled() {
+		t.Fatalf("expected a global rate to enable the scheduler")
+	}
+	start := time.Now()
+	if err := s.Wait(context.Background(), "c", 1000); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the raised rate to apply immediately, took %v", elapsed)
+	}
+}
+
+func TestReaderAndWriterThrottle(t *testing.T) {
+	s := New(Options{PerFlowBps: 1000, Quantum: 50})
+	ctx := context.Background()
+
+	start := time.Now()
+	data, err := io.ReadAll(s.Reader(ctx, "up", 0, bytes.NewReader(bytes.Repeat([]byte("a"), 200))))
+	if err != nil || len(data) != 200 {
+		t.Fatalf("read: n=%d err=%v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected reader to be paced, took %v", elapsed)
+	}
+
+	var out bytes.Buffer
+	start = time.Now()
+	if n, err := s.Writer(ctx, "down", 0, &out).Write(bytes.Repeat([]byte("b"), 200)); err != nil || n != 200 {
+		t.Fatalf("write: n=%d err=%v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected writer to be paced, took %v", elapsed)
+	}
+	if out.Len() != 200 {
+		t.Fatalf("expected all bytes written, got %d", out.Len())
+	}
+
+	disabled := New(Options{})
+	if disabled.Enabled() {
+		t.Fatalf("expected zero rates to disable the scheduler")
+	}
+	if r := bytes.NewReader(nil); disabled.Reader(ctx, "x", 0, r) != io.Reader(r) {
+		t.Fatalf("expected disabled scheduler to return the reader unchanged")
+	}
+	start = time.Now()
+	if _, err := io.ReadAll(disabled.Reader(ctx, "capped", 1000, bytes.NewReader(bytes.Repeat([]byte("c"), 200)))); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected a per-flow cap to pace the reader without scheduler rates, took %v", elapsed)
+	}
+}
@@ -0,0 +1,198 @@
+package capacity
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+	"universaldrop/internal/storage"
+)
+
+var (
+	ErrInsufficientSpace = errors.New("insufficient storage space")
+	ErrLowWatermark      = errors.New("storage below low watermark")
+)
+
+type Options struct {
+	MinFreeBytes  int64
+	MinFreeInodes int64
+	Clock         clock.Clock
+}
+
+type Status struct {
+	FreeBytes     uint64
+	FreeInodes    uint64
+	ReservedBytes int64
+	Degraded      bool
+}
+
+type reservation struct {
+	bytes     int64
+	expiresAt time.Time
+}
+
+type Guard struct {
+	reporter storage.CapacityReporter
+	opts     Options
+
+	mu       sync.Mutex
+	reserved map[string]reservation
+	total    int64
+}
+
+func New(reporter storage.CapacityReporter, opts Options) *Guard {
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+	return &Guard{
+		reporter: reporter,
+		opts:     opts,
+		reserved: make(map[string]reservation),
+	}
+}
+
+func (g *Guard) Enabled() bool {
+	return g != nil && g.reporter != nil
+}
+
+func (g *Guard) Status(ctx context.Context) (Status, error) {
+	if !g.Enabled() {
+		return Status{}, nil
+	}
+	current, err := g.reporter.Capacity(ctx)
+	if errors.Is(err, storage.ErrCapacityUnsupported) {
+		return Status{}, nil
+	}
+	if err != nil {
+		return Status{Degraded: true}, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	return g.statusLocked(current), nil
+}
+
+func (g *Guard) Check(ctx context.Context, bytes int64) error {
+	if !g.Enabled() {
+		return nil
+	}
+	current, err := g.reporter.Capacity(ctx)
+	if errors.Is(err, storage.ErrCapacityUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	return g.fitsLocked(current, bytes)
+}
+
+func (g *Guard) Reserve(ctx context.Context, id string, bytes int64, expiresAt time.Time) error {
+	if !g.Enabled() {
+		return nil
+	}
+	current, err := g.reporter.Capacity(ctx)
+	if errors.Is(err, storage.ErrCapacityUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes < 0 {
+		bytes = 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	g.releaseLocked(id)
+	if err := g.fitsLocked(current, bytes); err != nil {
+		return err
+	}
+	g.reserved[id] = reservation{bytes: bytes, expiresAt: expiresAt}
+	g.total += bytes
+	return nil
+}
+
+func (g *Guard) Consume(id string, bytes int64) {
+	if !g.Enabled() || bytes <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, ok := g.reserved[id]
+	if !ok {
+		return
+	}
+	if bytes > entry.bytes {
+		bytes = entry.bytes
+	}
+	entry.bytes -= bytes
+	g.reserved[id] = entry
+	g.total -= bytes
+}
+
+func (g *Guard) Release(id string) {
+	if !g.Enabled() {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.releaseLocked(id)
+}
+
+func (g *Guard) Reserved() int64 {
+	if !g.Enabled() {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	return g.total
+}
+
+func (g *Guard) statusLocked(current storage.Capacity) Status {
+	status := Status{
+		FreeBytes:     current.FreeBytes,
+		FreeInodes:    current.FreeInodes,
+		ReservedBytes: g.total,
+	}
+	if int64(current.FreeBytes)-g.total < g.opts.MinFreeBytes {
+		status.Degraded = true
+	}
+	if current.TotalInodes > 0 && int64(current.FreeInodes) < g.opts.MinFreeInodes {
+		status.Degraded = true
+	}
+	return status
+}
+
+func (g *Guard) fitsLocked(current storage.Capacity, bytes int64) error {
+	status := g.statusLocked(current)
+	if status.Degraded {
+		return ErrLowWatermark
+	}
+	if bytes > int64(status.FreeBytes)-status.ReservedBytes-g.opts.MinFreeBytes {
+		return ErrInsufficientSpace
+	}
+	return nil
+}
+
+func (g *Guard) releaseLocked(id string) {
+	if entry, ok := g.reserved[id]; ok {
+		g.total -= entry.bytes
+		delete(g.reserved, id)
+	}
+}
+
+func (g *Guard) pruneLocked() {
+	now := g.opts.Clock.Now()
+	for id, entry := range g.reserved {
+		if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+			g.total -= entry.bytes
+			delete(g.reserved, id)
+		}
+	}
+}
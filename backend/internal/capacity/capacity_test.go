@@ -0,0 +1,97 @@
+package capacity
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+	"universaldrop/internal/storage"
+)
+
+type fakeDisk struct {
+	capacity storage.Capacity
+	err      error
+}
+
+func (d *fakeDisk) Capacity(context.Context) (storage.Capacity, error) {
+	return d.capacity, d.err
+}
+
+func TestGuardReservesSpacePerTransfer(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	disk := &fakeDisk{capacity: storage.Capacity{TotalBytes: 2000, FreeBytes: 1000, TotalInodes: 100, FreeInodes: 50}}
+	g := New(disk, Options{MinFreeBytes: 200, MinFreeInodes: 10, Clock: clk})
+
+	if err := g.Reserve(ctx, "a", 500, clk.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("reserve a: %v", err)
+	}
+	if err := g.Check(ctx, 300); err != nil {
+		t.Fatalf("expected 300 bytes to fit, got %v", err)
+	}
+	if err := g.Check(ctx, 301); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected check to count reservations, got %v", err)
+	}
+	if err := g.Reserve(ctx, "b", 400, clk.Now().Add(time.Hour)); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected concurrent transfers not to overcommit, got %v", err)
+	}
+	if err := g.Reserve(ctx, "b", 300, clk.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("reserve b: %v", err)
+	}
+	status, err := g.Status(ctx)
+	if err != nil || status.ReservedBytes != 800 || status.Degraded {
+		t.Fatalf("unexpected status %+v %v", status, err)
+	}
+
+	g.Consume("a", 100)
+	disk.capacity.FreeBytes -= 100
+	if got := g.Reserved(); got != 700 {
+		t.Fatalf("expected consumed bytes to leave the reservation, got %d", got)
+	}
+	if err := g.Reserve(ctx, "c", 1, time.Time{}); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected the disk to be fully reserved, got %v", err)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if got := g.Reserved(); got != 300 {
+		t.Fatalf("expected expired reservation to be dropped, got %d", got)
+	}
+	g.Release("b")
+	if got := g.Reserved(); got != 0 {
+		t.Fatalf("expected release to free the reservation, got %d", got)
+	}
+
+	disk.capacity.FreeBytes = 150
+	if status, _ := g.Status(ctx); !status.Degraded {
+		t.Fatalf("expected free space under the watermark to be degraded")
+	}
+	if err := g.Reserve(ctx, "d", 0, time.Time{}); !errors.Is(err, ErrLowWatermark) {
+		t.Fatalf("expected low watermark to reject new transfers, got %v", err)
+	}
+	disk.capacity.FreeBytes = 1000
+	disk.capacity.FreeInodes = 5
+	if status, _ := g.Status(ctx); !status.Degraded {
+		t.Fatalf("expected low inode count to be degraded")
+	}
+
+	disabled := New(nil, Options{MinFreeBytes: 1 << 40})
+	if err := disabled.Reserve(ctx, "x", 1<<50, time.Time{}); err != nil {
+		t.Fatalf("expected a guard without a reporter to admit everything, got %v", err)
+	}
+}
+
+func TestGuardAdmitsWhenPlatformCannotReportCapacity(t *testing.T) {
+	ctx := context.Background()
+	g := New(&fakeDisk{err: storage.ErrCapacityUnsupported}, Options{MinFreeBytes: 1 << 30})
+	if status, err := g.Status(ctx); err != nil || status.Degraded {
+		t.Fatalf("expected unsupported capacity not to degrade readiness, got %+v %v", status, err)
+	}
+	if err := g.Reserve(ctx, "a", 1<<40, time.Time{}); err != nil {
+		t.Fatalf("expected unsupported capacity to admit transfers, got %v", err)
+	}
+	if err := g.Check(ctx, 1<<40); err != nil {
+		t.Fatalf("expected unsupported capacity to admit checks, got %v", err)
+	}
+}
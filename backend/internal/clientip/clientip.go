@@ -0,0 +1,187 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	Unknown = "unknown"
+
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderForwarded     = "forwarded"
+)
+
+type Options struct {
+	Header string
+}
+
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+func NewResolver(cidrs []string, opts Options) (*Resolver, error) {
+	trusted, err := ParsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	header := strings.ToLower(strings.TrimSpace(opts.Header))
+	switch header {
+	case "":
+		header = HeaderXForwardedFor
+	case HeaderXForwardedFor, HeaderForwarded:
+	default:
+		return nil, fmt.Errorf("invalid trusted header %q", opts.Header)
+	}
+	return &Resolver{trusted: trusted, header: header}, nil
+}
+
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (r *Resolver) Trusted(addr netip.Addr) bool {
+	if r == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Resolver) ClientIP(req *http.Request) string {
+	addr, ok := r.ClientAddr(req)
+	if !ok {
+		return Unknown
+	}
+	return addr.String()
+}
+
+func (r *Resolver) ClientAddr(req *http.Request) (netip.Addr, bool) {
+	peer, ok := parseHostPort(req.RemoteAddr)
+	if !ok {
+		if !unixPeer(req.RemoteAddr) {
+			return netip.Addr{}, false
+		}
+	} else if !r.Trusted(peer) {
+		return peer, true
+	}
+
+	var hops []string
+	if r.header == HeaderForwarded {
+		hops = forwardedFor(req.Header.Values("Forwarded"))
+	} else {
+		hops = forwardedList(req.Header.Values("X-Forwarded-For"))
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			return client, client.IsValid()
+		}
+		client = hop
+		if !r.Trusted(hop) {
+			return client, true
+		}
+	}
+	return client, client.IsValid()
+}
+
+func unixPeer(remoteAddr string) bool {
+	return strings.HasPrefix(remoteAddr, "@") || strings.HasPrefix(remoteAddr, "/")
+}
+
+func forwardedList(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(part))
+		}
+	}
+	return hops
+}
+
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			found := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "for") {
+					continue
+				}
+				found = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+			hops = append(hops, found)
+		}
+	}
+	return hops
+}
+
+func parseHop(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return netip.Addr{}, false
+	}
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), true
+	}
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+		if end < 0 {
+			return netip.Addr{}, false
+		}
+		addr, err := netip.ParseAddr(value[1:end])
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return addr.Unmap(), true
+	}
+	return parseHostPort(value)
+}
+
+func parseHostPort(value string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(value)
+	if err != nil {
+		host = value
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
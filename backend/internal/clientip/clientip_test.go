@@ -0,0 +1,258 @@
+package clientip
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolverIgnoresHeadersFromUntrustedPeer(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8"}, Options{})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("Forwarded", "for=198.51.100.2")
+	if got := resolver.ClientIP(req); got != "203.0.113.9" {
+		t.Fatalf("expected remote addr, got %q", got)
+	}
+}
+
+func TestResolverWalksForwardedForFromRight(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.0.2.7"}, Options{})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	cases := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "single hop", header: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed left entry", header: "1.2.3.4, 198.51.100.1, 192.0.2.7", want: "198.51.100.1"},
+		{name: "all trusted", header: "10.1.1.1, 10.2.2.2", want: "10.1.1.1"},
+		{name: "garbage hop", header: "1.2.3.4, not-an-ip, 10.2.2.2", want: "10.2.2.2"},
+		{name: "empty", header: "", want: "10.0.0.5"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.5:4000"
+			if tc.header != "" {
+				req.Header.Set("X-Forwarded-For", tc.header)
+			}
+			if got := resolver.ClientIP(req); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestResolverTrustsUnixSocketPeers(t *testing.T) {
+	resolver, err := NewResolver(nil, Options{})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 198.51.100.1")
+	if got := resolver.ClientIP(req); got != "198.51.100.1" {
+		t.Fatalf("expected forwarded client behind a unix socket, got %q", got)
+	}
+	req.Header.Del("X-Forwarded-For")
+	if got := resolver.ClientIP(req); got != Unknown {
+		t.Fatalf("expected unknown client without a forwarding header, got %q", got)
+	}
+}
+
+func TestResolverParsesForwardedHeader(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8"}, Options{Header: HeaderForwarded})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:4000"
+	req.Header.Add("Forwarded", `for=1.2.3.4, for="[2001:db8::1]:443";proto=https`)
+	req.Header.Add("Forwarded", "for=10.9.9.9;by=10.0.0.5")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := resolver.ClientIP(req); got != "2001:db8::1" {
+		t.Fatalf("expected Forwarded client, got %q", got)
+	}
+}
+
+func TestResolverHonorsOnlyTheConfiguredHeader(t *testing.T) {
+	xff, err := NewResolver([]string{"10.0.0.0/8"}, Options{})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("Forwarded", "for=203.0.113.66")
+	if got := xff.ClientIP(req); got != "198.51.100.1" {
+		t.Fatalf("expected a client-sent Forwarded header to be ignored, got %q", got)
+	}
+
+	forwarded, err := NewResolver([]string{"10.0.0.0/8"}, Options{Header: HeaderForwarded})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	req.Header.Del("Forwarded")
+	if got := forwarded.ClientIP(req); got != "10.0.0.5" {
+		t.Fatalf("expected X-Forwarded-For to be ignored in Forwarded mode, got %q", got)
+	}
+	if _, err := NewResolver(nil, Options{Header: "X-Real-IP"}); err == nil {
+		t.Fatalf("expected unknown headers to be rejected")
+	}
+}
+
+func TestParsePrefixesRejectsInvalid(t *testing.T) {
+	if _, err := ParsePrefixes([]string{"10.0.0.0/8", "nope"}); err == nil {
+		t.Fatalf("expected invalid prefix to error")
+	}
+	prefixes, err := ParsePrefixes([]string{"::ffff:10.0.0.0/104", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("parse prefixes: %v", err)
+	}
+	if prefixes[0].String() != "10.0.0.0/8" || prefixes[1].String() != "2001:db8::1/128" {
+		t.Fatalf("unexpected prefixes: %v", prefixes)
+	}
+}
+
+func TestProxyListenerParsesV1Header(t *testing.T) {
+	remote := serveProxyConn(t, []string{"127.0.0.0/8"}, []byte("PROXY TCP4 198.51.100.7 10.0.0.1 5555 443\r\nhello"))
+	if remote != "198.51.100.7:5555" {
+		t.Fatalf("expected proxied address, got %q", remote)
+	}
+}
+
+func TestProxyListenerParsesV2Header(t *testing.T) {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 198, 51, 100, 8, 10, 0, 0, 1)
+	header = binary.BigEndian.AppendUint16(header, 6000)
+	header = binary.BigEndian.AppendUint16(header, 443)
+	remote := serveProxyConn(t, []string{"127.0.0.0/8"}, append(header, []byte("hello")...))
+	if remote != "198.51.100.8:6000" {
+		t.Fatalf("expected proxied address, got %q", remote)
+	}
+}
+
+func TestProxyListenerIgnoresUntrustedPeer(t *testing.T) {
+	remote := serveProxyConn(t, []string{"10.0.0.0/8"}, []byte("hello"))
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil || host != "127.0.0.1" {
+		t.Fatalf("expected direct peer address, got %q", remote)
+	}
+}
+
+func TestProxyListenerPassesThroughWithoutHeader(t *testing.T) {
+	remote := serveProxyConn(t, []string{"127.0.0.0/8"}, []byte("hello"))
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil || host != "127.0.0.1" {
+		t.Fatalf("expected direct peer address, got %q", remote)
+	}
+}
+
+func TestProxyListenerParsesHeaderFromUnixSocket(t *testing.T) {
+	resolver, err := NewResolver(nil, Options{})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	inner, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	listener := NewProxyListener(inner, resolver)
+	listener.HeaderTimeout = time.Second
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("PROXY TCP4 198.51.100.9 10.0.0.1 7000 443\r\nhello"))
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	if remote := conn.RemoteAddr().String(); remote != "198.51.100.9:7000" {
+		t.Fatalf("expected proxied address over a unix socket, got %q", remote)
+	}
+}
+
+func serveProxyConn(t *testing.T, trusted []string, payload []byte) string {
+	t.Helper()
+	resolver, err := NewResolver(trusted, Options{})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	listener := NewProxyListener(inner, resolver)
+	listener.HeaderTimeout = time.Second
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write(payload)
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	body, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("expected payload after header, got %q", body)
+	}
+	return remote
+}
+
+func TestPrefixPolicyKeys(t *testing.T) {
+	fine := PrefixPolicy{IPv4Bits: 32, IPv6Bits: 64}
+	coarse := PrefixPolicy{IPv6Bits: 48}
+	cases := []struct {
+		ip     string
+		fine   string
+		coarse string
+	}{
+		{ip: "198.51.100.7", fine: "198.51.100.7/32", coarse: ""},
+		{ip: "::ffff:198.51.100.7", fine: "198.51.100.7/32", coarse: ""},
+		{ip: "2001:db8:1:2:3:4:5:6", fine: "2001:db8:1:2::/64", coarse: "2001:db8:1::/48"},
+		{ip: "2001:db8:1:2:ffff::1", fine: "2001:db8:1:2::/64", coarse: "2001:db8:1::/48"},
+		{ip: Unknown, fine: Unknown, coarse: Unknown},
+	}
+	for _, tc := range cases {
+		if got := fine.Key(tc.ip); got != tc.fine {
+			t.Fatalf("fine key for %s: expected %q, got %q", tc.ip, tc.fine, got)
+		}
+		if got := coarse.Key(tc.ip); got != tc.coarse {
+			t.Fatalf("coarse key for %s: expected %q, got %q", tc.ip, tc.coarse, got)
+		}
+	}
+}
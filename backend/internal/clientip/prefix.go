@@ -0,0 +1,35 @@
+package clientip
+
+import "net/netip"
+
+type PrefixPolicy struct {
+	IPv4Bits int
+	IPv6Bits int
+}
+
+func (p PrefixPolicy) Enabled() bool {
+	return p.IPv4Bits > 0 || p.IPv6Bits > 0
+}
+
+func (p PrefixPolicy) Key(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := p.IPv6Bits
+	if addr.Is4() {
+		bits = p.IPv4Bits
+	}
+	if bits <= 0 {
+		return ""
+	}
+	if bits > addr.BitLen() {
+		bits = addr.BitLen()
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
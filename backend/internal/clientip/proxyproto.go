@@ -0,0 +1,219 @@
+package clientip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProxyHeaderTimeout = 5 * time.Second
+
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+)
+
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+type ProxyListener struct {
+	net.Listener
+	Resolver      *Resolver
+	HeaderTimeout time.Duration
+}
+
+func NewProxyListener(inner net.Listener, resolver *Resolver) *ProxyListener {
+	return &ProxyListener{
+		Listener:      inner,
+		Resolver:      resolver,
+		HeaderTimeout: DefaultProxyHeaderTimeout,
+	}
+}
+
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if _, local := conn.RemoteAddr().(*net.UnixAddr); !local {
+		peer, ok := parseHostPort(conn.RemoteAddr().String())
+		if !ok || !l.Resolver.Trusted(peer) {
+			return conn, nil
+		}
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &proxyConn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer func() {
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	}()
+
+	addr, err := readProxyHeader(c.reader)
+	if err != nil {
+		c.err = err
+		_ = c.Conn.Close()
+		return
+	}
+	c.remoteAddr = addr
+}
+
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	peek, err := reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(peek) == proxyV1Prefix {
+		return readProxyV1(reader)
+	}
+	if peek[0] != proxyV2Signature[0] {
+		return nil, nil
+	}
+	peek, err = reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !bytes.Equal(peek, proxyV2Signature) {
+		return nil, nil
+	}
+	return readProxyV2(reader)
+}
+
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidProxyHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrInvalidProxyHeader
+		}
+	}
+	text := string(line)
+	if !strings.HasSuffix(text, "\r\n") {
+		return nil, ErrInvalidProxyHeader
+	}
+	fields := strings.Fields(strings.TrimSuffix(text, "\r\n"))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, ErrInvalidProxyHeader
+	}
+	src, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	if (fields[1] == "TCP4") != src.Is4() {
+		return nil, ErrInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(port))), nil
+}
+
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	if header[12]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	switch command {
+	case 0x0:
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	switch family >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		src := netip.AddrFrom4([4]byte(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, port)), nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		src := netip.AddrFrom16([16]byte(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, port)), nil
+	default:
+		return nil, nil
+	}
+}
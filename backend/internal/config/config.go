@@ -1,16 +1,20 @@
 package config
 
 import (
-	"encoding/base64"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"universaldrop/internal/clientip"
+	"universaldrop/internal/listener"
 )
 
 type RateLimit struct {
 	Max    int
 	Window time.Duration
+	Burst  int
 }
 
 type Config struct {
@@ -19,30 +23,89 @@ type Config struct {
 	RateLimitHealth       RateLimit
 	RateLimitV1           RateLimit
 	RateLimitSessionClaim RateLimit
+	RateLimitRoutes       map[string]RateLimit
 	ClaimTokenTTL         time.Duration
 	TransferTokenTTL      time.Duration
 	DownloadTokenTTL      time.Duration
 	SweepInterval         time.Duration
+	WatchInterval         time.Duration
 	MaxScanBytes          int64
 	MaxScanDuration       time.Duration
 	STUNURLs              []string
 	TURNURLs              []string
 	TURNSharedSecret      []byte
+	TokenSecret           SecretSource
+	TURNSecret            SecretSource
+	TrustedProxies        []string
+	TrustedHeader         string
+	IPPrefixes            IPPrefixConfig
+	ProxyProtocol         bool
 	Quotas                QuotaConfig
+	QuotaStore            QuotaStoreConfig
+	Revocations           RevocationsConfig
+	SharedState           SharedStateConfig
 	Throttles             ThrottleConfig
+	Admission             AdmissionConfig
+	Tracing               TracingConfig
+	Log                   LogConfig
+	Audit                 AuditConfig
+	TLS                   TLSConfig
+	Admin                 AdminConfig
+	Metrics               MetricsConfig
+	Drain                 DrainConfig
+	Storage               StorageConfig
+
+	DebugCapabilityIntrospection bool
+}
+
+type SecretSource struct {
+	Provider      string
+	Env           string
+	Path          string
+	PassphraseEnv string
+	Command       []string
 }
 
 type QuotaConfig struct {
-	SessionsPerDayIP           int64
-	SessionsPerDaySession      int64
-	TransfersPerDayIP          int64
-	TransfersPerDaySession     int64
-	BytesPerDayIP              int64
-	BytesPerDaySession         int64
-	ConcurrentTransfersIP      int
-	ConcurrentTransfersSession int
-	RelayPerIdentityPerDay     int64
-	RelayConcurrentPerIdentity int
+	SessionsPerDayIP            int64
+	SessionsPerDaySession       int64
+	TransfersPerDayIP           int64
+	TransfersPerDaySession      int64
+	BytesPerDayIP               int64
+	BytesPerDaySession          int64
+	ConcurrentTransfersIP       int
+	ConcurrentTransfersSession  int
+	SessionsPerDaySender        int64
+	SessionsPerDayReceiver      int64
+	BytesPerDaySender           int64
+	BytesPerDayReceiver         int64
+	ConcurrentTransfersSender   int
+	ConcurrentTransfersReceiver int
+	RelayPerIdentityPerDay      int64
+	RelayConcurrentPerIdentity  int
+}
+
+type IPPrefixConfig struct {
+	IPv4Bits         int
+	IPv6Bits         int
+	CoarseIPv4Bits   int
+	CoarseIPv6Bits   int
+	CoarseLimitScale int64
+}
+
+type QuotaStoreConfig struct {
+	Backend       string
+	FlushInterval time.Duration
+}
+
+type RevocationsConfig struct {
+	FlushInterval time.Duration
+}
+
+type SharedStateConfig struct {
+	URL         string
+	FailureMode string
+	KeyPrefix   string
 }
 
 type ThrottleConfig struct {
@@ -50,32 +113,121 @@ type ThrottleConfig struct {
 	GlobalBandwidthCapBps   int64
 }
 
+type LogConfig struct {
+	Format string
+	Level  string
+}
+
+type AuditConfig struct {
+	Disabled     bool
+	Dir          string
+	MaxFileBytes int64
+	Key          SecretSource
+}
+
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ReloadInterval time.Duration
+}
+
+type AdminConfig struct {
+	Address      string
+	ClientCAFile string
+}
+
+type MetricsConfig struct {
+	Address      string
+	BearerToken  string
+	ClientCAFile string
+	Public       bool
+}
+
+type StorageConfig struct {
+	MinFreeBytes       int64
+	MinFreeInodes      int64
+	SecureDelete       bool
+	AtRestKey          SecretSource
+	AtRestPreviousKeys []string
+}
+
+type DrainConfig struct {
+	Timeout time.Duration
+	Delay   time.Duration
+}
+
+type TracingConfig struct {
+	Exporter      string
+	OTLPEndpoint  string
+	File          string
+	SamplePercent int
+}
+
+type AdmissionConfig struct {
+	MaxInflightBytes  int64
+	MaxGoroutines     int
+	MaxStorageLatency time.Duration
+	ShedPercent       int
+}
+
+const FileEnv = "UD_CONFIG_FILE"
+
 const (
-	DefaultClaimTokenTTL                   = 3 * time.Minute
-	MinClaimTokenTTL                       = 2 * time.Minute
-	MaxClaimTokenTTL                       = 5 * time.Minute
-	DefaultTransferTokenTTL                = 5 * time.Minute
-	MinTransferTokenTTL                    = 1 * time.Minute
-	MaxTransferTokenTTL                    = 15 * time.Minute
-	DefaultSweepInterval                   = 30 * time.Second
-	DefaultMaxScanBytes                    = 50 << 20
-	DefaultMaxScanDuration                 = 10 * time.Second
-	DefaultQuotaSessionsPerDayIP           = int64(0)
-	DefaultQuotaSessionsPerDaySession      = int64(0)
-	DefaultQuotaTransfersPerDayIP          = int64(0)
-	DefaultQuotaTransfersPerDaySession     = int64(0)
-	DefaultQuotaBytesPerDayIP              = int64(0)
-	DefaultQuotaBytesPerDaySession         = int64(0)
-	DefaultQuotaConcurrentTransfersIP      = 0
-	DefaultQuotaConcurrentTransfersSession = 0
-	DefaultRelayPerIdentityPerDay          = int64(0)
-	DefaultRelayConcurrentPerIdentity      = 0
-	DefaultTransferBandwidthCapBps         = int64(0)
-	DefaultGlobalBandwidthCapBps           = int64(0)
+	DefaultClaimTokenTTL                    = 3 * time.Minute
+	MinClaimTokenTTL                        = 2 * time.Minute
+	MaxClaimTokenTTL                        = 5 * time.Minute
+	DefaultTransferTokenTTL                 = 5 * time.Minute
+	MinTransferTokenTTL                     = 1 * time.Minute
+	MaxTransferTokenTTL                     = 15 * time.Minute
+	DefaultSweepInterval                    = 30 * time.Second
+	DefaultWatchInterval                    = 5 * time.Second
+	DefaultMaxScanBytes                     = 50 << 20
+	DefaultMaxScanDuration                  = 10 * time.Second
+	DefaultQuotaSessionsPerDayIP            = int64(0)
+	DefaultQuotaSessionsPerDaySession       = int64(0)
+	DefaultQuotaTransfersPerDayIP           = int64(0)
+	DefaultQuotaTransfersPerDaySession      = int64(0)
+	DefaultQuotaBytesPerDayIP               = int64(0)
+	DefaultQuotaBytesPerDaySession          = int64(0)
+	DefaultQuotaConcurrentTransfersIP       = 0
+	DefaultQuotaConcurrentTransfersSession  = 0
+	DefaultQuotaSessionsPerDaySender        = int64(0)
+	DefaultQuotaSessionsPerDayReceiver      = int64(0)
+	DefaultQuotaBytesPerDaySender           = int64(0)
+	DefaultQuotaBytesPerDayReceiver         = int64(0)
+	DefaultQuotaConcurrentTransfersSender   = 0
+	DefaultQuotaConcurrentTransfersReceiver = 0
+	DefaultRelayPerIdentityPerDay           = int64(0)
+	DefaultRelayConcurrentPerIdentity       = 0
+	DefaultTransferBandwidthCapBps          = int64(0)
+	DefaultGlobalBandwidthCapBps            = int64(0)
+	DefaultAdmissionMaxInflightBytes        = int64(1 << 30)
+	DefaultAdmissionShedPercent             = 80
+	TracingExporterOTLP                     = "otlp"
+	TracingExporterFile                     = "file"
+	DefaultTracingOTLPEndpoint              = "http://localhost:4318/v1/traces"
+	DefaultTracingSamplePercent             = 100
+	LogFormatJSON                           = "json"
+	LogFormatText                           = "text"
+	DefaultLogLevel                         = "info"
+	DefaultAuditMaxFileBytes                = int64(10 << 20)
+	DefaultTLSReloadInterval                = time.Minute
+	DefaultDrainTimeout                     = 5 * time.Minute
+	DefaultStorageMinFreeBytes              = int64(512 << 20)
+	DefaultStorageMinFreeInodes             = int64(1024)
+	DefaultQuotaStoreBackend                = "file"
+	DefaultQuotaFlushInterval               = 10 * time.Second
+	DefaultRevocationFlushInterval          = 5 * time.Second
+	SharedStateFailClosed                   = "closed"
+	SharedStateFailLocal                    = "local"
+	DefaultSharedStatePrefix                = "ud:"
+	DefaultIPv4PrefixBits                   = 32
+	DefaultIPv6PrefixBits                   = 64
+	DefaultCoarseLimitScale                 = int64(4)
 )
 
-func Load() Config {
-	cfg := Config{
+func Defaults() Config {
+	return Config{
 		Address: ":8080",
 		DataDir: "data",
 		RateLimitHealth: RateLimit{
@@ -93,177 +245,174 @@ func Load() Config {
 		ClaimTokenTTL:    DefaultClaimTokenTTL,
 		TransferTokenTTL: DefaultTransferTokenTTL,
 		SweepInterval:    DefaultSweepInterval,
+		WatchInterval:    DefaultWatchInterval,
 		MaxScanBytes:     DefaultMaxScanBytes,
 		MaxScanDuration:  DefaultMaxScanDuration,
+		TrustedHeader:    clientip.HeaderXForwardedFor,
+		IPPrefixes: IPPrefixConfig{
+			IPv4Bits:         DefaultIPv4PrefixBits,
+			IPv6Bits:         DefaultIPv6PrefixBits,
+			CoarseLimitScale: DefaultCoarseLimitScale,
+		},
+		QuotaStore: QuotaStoreConfig{
+			Backend:       DefaultQuotaStoreBackend,
+			FlushInterval: DefaultQuotaFlushInterval,
+		},
+		Revocations: RevocationsConfig{
+			FlushInterval: DefaultRevocationFlushInterval,
+		},
+		SharedState: SharedStateConfig{
+			FailureMode: SharedStateFailClosed,
+			KeyPrefix:   DefaultSharedStatePrefix,
+		},
 		Quotas: QuotaConfig{
-			SessionsPerDayIP:           DefaultQuotaSessionsPerDayIP,
-			SessionsPerDaySession:      DefaultQuotaSessionsPerDaySession,
-			TransfersPerDayIP:          DefaultQuotaTransfersPerDayIP,
-			TransfersPerDaySession:     DefaultQuotaTransfersPerDaySession,
-			BytesPerDayIP:              DefaultQuotaBytesPerDayIP,
-			BytesPerDaySession:         DefaultQuotaBytesPerDaySession,
-			ConcurrentTransfersIP:      DefaultQuotaConcurrentTransfersIP,
-			ConcurrentTransfersSession: DefaultQuotaConcurrentTransfersSession,
-			RelayPerIdentityPerDay:     DefaultRelayPerIdentityPerDay,
-			RelayConcurrentPerIdentity: DefaultRelayConcurrentPerIdentity,
+			SessionsPerDayIP:            DefaultQuotaSessionsPerDayIP,
+			SessionsPerDaySession:       DefaultQuotaSessionsPerDaySession,
+			TransfersPerDayIP:           DefaultQuotaTransfersPerDayIP,
+			TransfersPerDaySession:      DefaultQuotaTransfersPerDaySession,
+			BytesPerDayIP:               DefaultQuotaBytesPerDayIP,
+			BytesPerDaySession:          DefaultQuotaBytesPerDaySession,
+			ConcurrentTransfersIP:       DefaultQuotaConcurrentTransfersIP,
+			ConcurrentTransfersSession:  DefaultQuotaConcurrentTransfersSession,
+			SessionsPerDaySender:        DefaultQuotaSessionsPerDaySender,
+			SessionsPerDayReceiver:      DefaultQuotaSessionsPerDayReceiver,
+			BytesPerDaySender:           DefaultQuotaBytesPerDaySender,
+			BytesPerDayReceiver:         DefaultQuotaBytesPerDayReceiver,
+			ConcurrentTransfersSender:   DefaultQuotaConcurrentTransfersSender,
+			ConcurrentTransfersReceiver: DefaultQuotaConcurrentTransfersReceiver,
+			RelayPerIdentityPerDay:      DefaultRelayPerIdentityPerDay,
+			RelayConcurrentPerIdentity:  DefaultRelayConcurrentPerIdentity,
 		},
 		Throttles: ThrottleConfig{
 			TransferBandwidthCapBps: DefaultTransferBandwidthCapBps,
 			GlobalBandwidthCapBps:   DefaultGlobalBandwidthCapBps,
 		},
+		Admission: AdmissionConfig{
+			MaxInflightBytes: DefaultAdmissionMaxInflightBytes,
+			ShedPercent:      DefaultAdmissionShedPercent,
+		},
+		Log: LogConfig{
+			Format: LogFormatJSON,
+			Level:  DefaultLogLevel,
+		},
+		Tracing: TracingConfig{
+			OTLPEndpoint:  DefaultTracingOTLPEndpoint,
+			SamplePercent: DefaultTracingSamplePercent,
+		},
+		Audit: AuditConfig{
+			MaxFileBytes: DefaultAuditMaxFileBytes,
+		},
+		TLS: TLSConfig{
+			ReloadInterval: DefaultTLSReloadInterval,
+		},
+		Drain: DrainConfig{
+			Timeout: DefaultDrainTimeout,
+		},
+		Storage: StorageConfig{
+			MinFreeBytes:  DefaultStorageMinFreeBytes,
+			MinFreeInodes: DefaultStorageMinFreeInodes,
+		},
 	}
+}
 
-	if value := os.Getenv("UD_ADDRESS"); value != "" {
-		cfg.Address = value
-	}
-	if value := os.Getenv("UD_DATA_DIR"); value != "" {
-		cfg.DataDir = value
-	}
+func Load() (Config, error) {
+	return LoadFrom(os.Getenv(FileEnv), os.LookupEnv)
+}
 
-	if value := parseIntEnv("UD_RATE_LIMIT_HEALTH_MAX"); value > 0 {
-		cfg.RateLimitHealth.Max = int(value)
-	}
-	if value := parseDurationEnv("UD_RATE_LIMIT_HEALTH_WINDOW"); value > 0 {
-		cfg.RateLimitHealth.Window = value
-	}
-	if value := parseIntEnv("UD_RATE_LIMIT_V1_MAX"); value > 0 {
-		cfg.RateLimitV1.Max = int(value)
-	}
-	if value := parseDurationEnv("UD_RATE_LIMIT_V1_WINDOW"); value > 0 {
-		cfg.RateLimitV1.Window = value
-	}
-	if value := parseIntEnv("UD_RATE_LIMIT_SESSION_CLAIM_MAX"); value > 0 {
-		cfg.RateLimitSessionClaim.Max = int(value)
-	}
-	if value := parseDurationEnv("UD_RATE_LIMIT_SESSION_CLAIM_WINDOW"); value > 0 {
-		cfg.RateLimitSessionClaim.Window = value
-	}
-	if value := parseDurationEnv("UD_CLAIM_TOKEN_TTL"); value > 0 {
-		cfg.ClaimTokenTTL = value
-	}
-	if cfg.ClaimTokenTTL < MinClaimTokenTTL || cfg.ClaimTokenTTL > MaxClaimTokenTTL {
-		cfg.ClaimTokenTTL = DefaultClaimTokenTTL
-	}
-	if value := parseDurationEnv("UD_TRANSFER_TOKEN_TTL"); value > 0 {
-		cfg.TransferTokenTTL = value
-	}
-	if cfg.TransferTokenTTL < MinTransferTokenTTL || cfg.TransferTokenTTL > MaxTransferTokenTTL {
-		cfg.TransferTokenTTL = DefaultTransferTokenTTL
-	}
-	if value := parseDurationEnv("UD_DOWNLOAD_TOKEN_TTL"); value > 0 {
-		cfg.DownloadTokenTTL = value
-	}
-	if value := parseDurationEnv("UD_SWEEP_INTERVAL"); value > 0 {
-		cfg.SweepInterval = value
-	}
-	if value := parseIntEnv("UD_MAX_SCAN_BYTES"); value > 0 {
-		cfg.MaxScanBytes = value
-	}
-	if value := parseDurationEnv("UD_MAX_SCAN_DURATION"); value > 0 {
-		cfg.MaxScanDuration = value
-	}
-	if values := parseCSVEnv("UD_STUN_URLS"); len(values) > 0 {
-		cfg.STUNURLs = values
-	}
-	if values := parseCSVEnv("UD_TURN_URLS"); len(values) > 0 {
-		cfg.TURNURLs = values
-	}
-	if secret := parseBase64Env("UD_TURN_SHARED_SECRET_B64"); len(secret) > 0 {
-		cfg.TURNSharedSecret = secret
-	}
-	if value := parseIntEnv("UD_QUOTA_IP_SESSIONS_PER_DAY"); value > 0 {
-		cfg.Quotas.SessionsPerDayIP = value
-	}
-	if value := parseIntEnv("UD_QUOTA_SESSION_SESSIONS_PER_DAY"); value > 0 {
-		cfg.Quotas.SessionsPerDaySession = value
-	}
-	if value := parseIntEnv("UD_QUOTA_IP_TRANSFERS_PER_DAY"); value > 0 {
-		cfg.Quotas.TransfersPerDayIP = value
-	}
-	if value := parseIntEnv("UD_QUOTA_SESSION_TRANSFERS_PER_DAY"); value > 0 {
-		cfg.Quotas.TransfersPerDaySession = value
-	}
-	if value := parseIntEnv("UD_QUOTA_IP_BYTES_PER_DAY"); value > 0 {
-		cfg.Quotas.BytesPerDayIP = value
-	}
-	if value := parseIntEnv("UD_QUOTA_SESSION_BYTES_PER_DAY"); value > 0 {
-		cfg.Quotas.BytesPerDaySession = value
-	}
-	if value := parseIntEnv("UD_QUOTA_IP_CONCURRENT_TRANSFERS"); value > 0 {
-		cfg.Quotas.ConcurrentTransfersIP = int(value)
-	}
-	if value := parseIntEnv("UD_QUOTA_SESSION_CONCURRENT_TRANSFERS"); value > 0 {
-		cfg.Quotas.ConcurrentTransfersSession = int(value)
-	}
-	if value := parseIntEnv("UD_TRANSFER_BANDWIDTH_BPS"); value > 0 {
-		cfg.Throttles.TransferBandwidthCapBps = value
-	}
-	if value := parseIntEnv("UD_GLOBAL_BANDWIDTH_BPS"); value > 0 {
-		cfg.Throttles.GlobalBandwidthCapBps = value
-	}
-	if value := parseIntEnv("UD_RELAY_ISSUANCE_PER_DAY"); value > 0 {
-		cfg.Quotas.RelayPerIdentityPerDay = value
+func LoadFrom(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Defaults()
+	var problems []string
+	if path != "" {
+		entries, err := parseFile(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems = append(problems, applyFile(&cfg, path, entries)...)
+		}
 	}
-	if value := parseIntEnv("UD_RELAY_CONCURRENT_SESSIONS"); value > 0 {
-		cfg.Quotas.RelayConcurrentPerIdentity = int(value)
+	problems = append(problems, applyEnv(&cfg, lookupEnv)...)
+	problems = append(problems, validate(cfg)...)
+	if len(problems) > 0 {
+		return cfg, &ValidationError{Problems: problems}
 	}
+	return cfg, nil
+}
 
-	return cfg
+type ValidationError struct {
+	Problems []string
 }
 
-func parseDurationEnv(key string) time.Duration {
-	raw := os.Getenv(key)
-	if raw == "" {
-		return 0
-	}
-	value, err := time.ParseDuration(raw)
-	if err != nil {
-		return 0
-	}
-	return value
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
 }
 
-func parseIntEnv(key string) int64 {
-	raw := os.Getenv(key)
-	if raw == "" {
-		return 0
+func validate(cfg Config) []string {
+	var problems []string
+	if cfg.IPPrefixes.CoarseIPv4Bits > cfg.IPPrefixes.IPv4Bits {
+		problems = append(problems, "client_ip.coarse_prefix_v4: must not be longer than client_ip.prefix_v4")
 	}
-	value, err := strconv.ParseInt(raw, 10, 64)
-	if err != nil {
-		return 0
+	if cfg.IPPrefixes.CoarseIPv6Bits > cfg.IPPrefixes.IPv6Bits {
+		problems = append(problems, "client_ip.coarse_prefix_v6: must not be longer than client_ip.prefix_v6")
 	}
-	return value
-}
-
-func parseCSVEnv(key string) []string {
-	raw := strings.TrimSpace(os.Getenv(key))
-	if raw == "" {
-		return nil
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		problems = append(problems, "tls: cert_file and key_file must be set together")
 	}
-	parts := strings.Split(raw, ",")
-	values := make([]string, 0, len(parts))
-	for _, part := range parts {
-		trimmed := strings.TrimSpace(part)
-		if trimmed == "" {
+	if cfg.Admin.ClientCAFile != "" && (cfg.Admin.Address == "" || cfg.TLS.CertFile == "") {
+		problems = append(problems, "admin.client_ca_file: requires admin.address and tls.cert_file")
+	}
+	if cfg.Metrics.ClientCAFile != "" && (cfg.Metrics.Address == "" || cfg.TLS.CertFile == "") {
+		problems = append(problems, "metrics.client_ca_file: requires metrics.address and tls.cert_file")
+	}
+	if len(cfg.Storage.AtRestPreviousKeys) > 0 && cfg.Storage.AtRestKey.Provider == "" {
+		problems = append(problems, "storage.at_rest_previous_keys: requires storage.at_rest_key.provider")
+	}
+	bound := map[listener.Spec]string{}
+	for _, addr := range []struct{ key, value string }{
+		{"address", cfg.Address},
+		{"admin.address", cfg.Admin.Address},
+		{"metrics.address", cfg.Metrics.Address},
+	} {
+		if addr.value == "" && addr.key != "address" {
 			continue
 		}
-		values = append(values, trimmed)
+		spec, err := listener.Parse(addr.value)
+		if err != nil {
+			problems = append(problems, addr.key+": must be host:port, tcp://host:port, unix:/path or systemd:name")
+			continue
+		}
+		if other, ok := bound[spec]; ok {
+			problems = append(problems, addr.key+": already used by "+other)
+			continue
+		}
+		bound[spec] = addr.key
+	}
+	if cfg.SharedState.URL == "" && cfg.SharedState.FailureMode == SharedStateFailLocal {
+		problems = append(problems, "shared_state.failure_mode: requires shared_state.url")
 	}
-	return values
+	return problems
 }
 
-func parseBase64Env(key string) []byte {
-	raw := strings.TrimSpace(os.Getenv(key))
-	if raw == "" {
-		return nil
-	}
-	decoded, err := base64.RawURLEncoding.DecodeString(raw)
-	if err == nil {
-		return decoded
-	}
-	decoded, err = base64.StdEncoding.DecodeString(raw)
-	if err != nil {
-		return nil
+func parseRateLimitSpec(spec string) (RateLimit, bool) {
+	spec, burstRaw, hasBurst := strings.Cut(spec, ":")
+	maxRaw, windowRaw, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RateLimit{}, false
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(maxRaw))
+	if err != nil || max <= 0 {
+		return RateLimit{}, false
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowRaw))
+	if err != nil || window <= 0 {
+		return RateLimit{}, false
+	}
+	limit := RateLimit{Max: max, Window: window}
+	if hasBurst {
+		burst, err := strconv.Atoi(strings.TrimSpace(burstRaw))
+		if err != nil || burst <= 0 {
+			return RateLimit{}, false
+		}
+		limit.Burst = burst
 	}
-	return decoded
+	return limit, true
 }
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func envMap(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func TestLoadFromFileWithEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, `
+# comment
+address = ":9090"
+
+[rate_limit.v1]
+max = 50
+window = "2m" # trailing comment
+
+[rate_limit.routes]
+"/v1/transfer/chunk" = "100/1m:20"
+
+[ice]
+stun_urls = [
+  "stun:a.example:3478",
+  'stun:b.example:3478',
+]
+
+[quota.ip]
+bytes_per_day = 1_000_000
+
+[audit]
+disabled = true
+`)
+	cfg, err := LoadFrom(path, envMap(map[string]string{
+		"UD_ADDRESS":   ":7070",
+		"UD_TURN_URLS": "turn:a.example, turn:b.example",
+		"UD_LOG_LEVEL": "WARN",
+	}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Address != ":7070" {
+		t.Fatalf("expected env to override file, got %q", cfg.Address)
+	}
+	if cfg.RateLimitV1 != (RateLimit{Max: 50, Window: 2 * time.Minute}) {
+		t.Fatalf("unexpected v1 limit %+v", cfg.RateLimitV1)
+	}
+	if cfg.RateLimitRoutes["/v1/transfer/chunk"] != (RateLimit{Max: 100, Window: time.Minute, Burst: 20}) {
+		t.Fatalf("unexpected routes %+v", cfg.RateLimitRoutes)
+	}
+	if !reflect.DeepEqual(cfg.STUNURLs, []string{"stun:a.example:3478", "stun:b.example:3478"}) {
+		t.Fatalf("unexpected stun urls %v", cfg.STUNURLs)
+	}
+	if !reflect.DeepEqual(cfg.TURNURLs, []string{"turn:a.example", "turn:b.example"}) {
+		t.Fatalf("unexpected turn urls %v", cfg.TURNURLs)
+	}
+	if cfg.Quotas.BytesPerDayIP != 1_000_000 || !cfg.Audit.Disabled || cfg.Log.Level != "warn" {
+		t.Fatalf("unexpected values %+v %+v %+v", cfg.Quotas, cfg.Audit, cfg.Log)
+	}
+	if cfg.ClaimTokenTTL != DefaultClaimTokenTTL {
+		t.Fatalf("expected defaults for unset keys")
+	}
+
+	var dump bytes.Buffer
+	if err := Dump(&dump, cfg); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	reloaded, err := LoadFrom(writeConfigFile(t, dump.String()), envMap(nil))
+	if err != nil {
+		t.Fatalf("reload dump: %v\n%s", err, dump.String())
+	}
+	if !reflect.DeepEqual(reloaded, cfg) {
+		t.Fatalf("dump did not round trip:\n%+v\n%+v", reloaded, cfg)
+	}
+}
+
+func TestLoadFromReportsEveryProblem(t *testing.T) {
+	path := writeConfigFile(t, `
+adress = ":1"
+
+[tokens]
+claim_ttl = "10m"
+transfer_ttl = 5
+
+[bogus]
+
+[rate_limit.routes]
+"/v1/ping" = "fast"
+`)
+	_, err := LoadFrom(path, envMap(map[string]string{
+		"UD_SWEEP_INTERVAL":         "soon",
+		"UD_TURN_SHARED_SECRET_B64": "secret-value!",
+		"UD_TRUSTED_PROXIES":        "10.0.0.0/8,not-an-ip",
+		"UD_LOG_FORMAT":             "xml",
+	}))
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	want := []string{
+		"unknown key adress",
+		"tokens.claim_ttl: must be between 2m0s and 5m0s",
+		"tokens.transfer_ttl: expected a duration",
+		"unknown table [bogus]",
+		`rate_limit.routes."/v1/ping": must look like max/window[:burst]`,
+		"UD_SWEEP_INTERVAL (sweep_interval)",
+		"UD_TURN_SHARED_SECRET_B64 (ice.turn_shared_secret_b64): must be base64",
+		"UD_LOG_FORMAT (log.format): must be one of json, text",
+		"UD_TRUSTED_PROXIES (client_ip.trusted_proxies)",
+	}
+	if len(invalid.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %q", len(want), invalid.Problems)
+	}
+	for i, fragment := range want {
+		if !strings.Contains(invalid.Problems[i], fragment) {
+			t.Fatalf("problem %d = %q, expected it to contain %q", i, invalid.Problems[i], fragment)
+		}
+	}
+	if strings.Contains(err.Error(), "secret-value") || strings.Contains(err.Error(), "not-an-ip") {
+		t.Fatalf("problems must not echo raw values: %v", err)
+	}
+}
+
+func TestParseFileRejectsMalformedInput(t *testing.T) {
+	for name, contents := range map[string]string{
+		"duplicate key":       "address = \":1\"\naddress = \":2\"\n",
+		"duplicate table":     "[log]\n[log]\n",
+		"unterminated string": "address = \":1\n",
+		"float":               "[admission]\nshed_percent = 1.5\n",
+		"trailing text":       "address = \":1\" extra\n",
+		"array of tables":     "[[log]]\n",
+		"mixed array":         "[ice]\nstun_urls = [\"a\", 1]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadFrom(writeConfigFile(t, contents), envMap(nil)); err == nil {
+				t.Fatalf("expected %s to be rejected", name)
+			}
+		})
+	}
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	cfg := Defaults()
+	cfg.TURNSharedSecret = []byte("turn-secret")
+	cfg.SharedState.URL = "redis://:password@localhost:6379"
+	cfg.TokenSecret.Command = []string{"vault", "read", "-token=abc"}
+	var out bytes.Buffer
+	if err := Dump(&out, cfg); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	for _, secret := range []string{"dHVybi1zZWNyZXQ", "password", "-token=abc"} {
+		if strings.Contains(out.String(), secret) {
+			t.Fatalf("dump leaked %q:\n%s", secret, out.String())
+		}
+	}
+	if strings.Count(out.String(), `"<redacted>"`) != 3 {
+		t.Fatalf("expected three redacted values:\n%s", out.String())
+	}
+}
+
+func TestReloadAppliesOnlyRuntimeKeys(t *testing.T) {
+	current := Defaults()
+	next := Defaults()
+	next.Address = ":9999"
+	next.DataDir = "/elsewhere"
+	next.RateLimitV1.Burst = 7
+	next.Quotas.BytesPerDayIP = 42
+	next.RateLimitRoutes = map[string]RateLimit{"/v1/ping": {Max: 1, Window: time.Second}}
+
+	merged, applied, ignored := Reload(current, next)
+	if !reflect.DeepEqual(applied, []string{"rate_limit.v1.burst", "quota.ip.bytes_per_day", "rate_limit.routes"}) {
+		t.Fatalf("unexpected applied keys %v", applied)
+	}
+	if !reflect.DeepEqual(ignored, []string{"address", "data_dir"}) {
+		t.Fatalf("unexpected ignored keys %v", ignored)
+	}
+	if merged.Address != current.Address || merged.DataDir != current.DataDir {
+		t.Fatalf("expected restart-only keys to keep their running values")
+	}
+	if merged.RateLimitV1.Burst != 7 || merged.Quotas.BytesPerDayIP != 42 || len(merged.RateLimitRoutes) != 1 {
+		t.Fatalf("expected runtime keys to be applied, got %+v", merged)
+	}
+	if len(Diff(merged, merged)) != 0 {
+		t.Fatalf("expected identical configs to have no diff")
+	}
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindInteger
+	kindBool
+	kindArray
+	kindEnv
+)
+
+type value struct {
+	kind valueKind
+	text string
+	list []string
+}
+
+type fileEntry struct {
+	path  []string
+	line  int
+	value value
+	table bool
+}
+
+func parseFile(path string) ([]fileEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot read config file", path)
+	}
+	p := &fileParser{src: string(data), name: path}
+	return p.parse()
+}
+
+type fileParser struct {
+	src  string
+	pos  int
+	name string
+}
+
+func (p *fileParser) parse() ([]fileEntry, error) {
+	var entries []fileEntry
+	var table []string
+	seen := map[string]bool{}
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return entries, nil
+		}
+		line := p.line()
+		if p.peek() == '[' {
+			p.pos++
+			if p.peek() == '[' {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() != ']' {
+				return nil, p.errorf("expected ] after table name")
+			}
+			p.pos++
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+			name := strings.Join(key, ".")
+			if seen["["+name+"]"] {
+				return nil, fmt.Errorf("%s:%d: table [%s] defined twice", p.name, line, name)
+			}
+			seen["["+name+"]"] = true
+			table = key
+			entries = append(entries, fileEntry{path: key, line: line, table: true})
+			continue
+		}
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != '=' {
+			return nil, p.errorf("expected = after key")
+		}
+		p.pos++
+		p.skipInline()
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+		full := append(append([]string(nil), table...), key...)
+		name := strings.Join(full, ".")
+		if seen[name] {
+			return nil, fmt.Errorf("%s:%d: key %s defined twice", p.name, line, name)
+		}
+		seen[name] = true
+		entries = append(entries, fileEntry{path: full, line: line, value: v})
+	}
+}
+
+func (p *fileParser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		p.skipInline()
+		var part string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		case isBareKeyChar(c):
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			part = p.src[start:p.pos]
+		default:
+			return nil, p.errorf("expected a key")
+		}
+		parts = append(parts, part)
+		p.skipInline()
+		if p.peek() != '.' {
+			return parts, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *fileParser) parseValue() (value, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		s, err := p.parseString()
+		if err != nil {
+			return value{}, err
+		}
+		return value{kind: kindString, text: s}, nil
+	case c == '[':
+		p.pos++
+		var list []string
+		for {
+			p.skipBlank()
+			if p.peek() == ']' {
+				p.pos++
+				return value{kind: kindArray, list: list}, nil
+			}
+			if c := p.peek(); c != '"' && c != '\'' {
+				return value{}, p.errorf("arrays may only contain strings")
+			}
+			s, err := p.parseString()
+			if err != nil {
+				return value{}, err
+			}
+			list = append(list, s)
+			p.skipBlank()
+			switch p.peek() {
+			case ',':
+				p.pos++
+			case ']':
+			default:
+				return value{}, p.errorf("expected , or ] in array")
+			}
+		}
+	case c == 't' || c == 'f':
+		word := p.word()
+		if word != "true" && word != "false" {
+			return value{}, p.errorf("expected true or false")
+		}
+		return value{kind: kindBool, text: word}, nil
+	case c == '+' || c == '-' || (c >= '0' && c <= '9'):
+		word := strings.ReplaceAll(p.word(), "_", "")
+		if _, err := strconv.ParseInt(word, 10, 64); err != nil {
+			return value{}, p.errorf("only decimal integers are supported")
+		}
+		return value{kind: kindInteger, text: word}, nil
+	default:
+		return value{}, p.errorf("expected a value")
+	}
+}
+
+func (p *fileParser) parseString() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	start := p.pos
+	p.pos++
+	for !p.eof() {
+		c := p.peek()
+		if c == '\n' {
+			break
+		}
+		if c == '\\' && quote == '"' {
+			p.pos += 2
+			continue
+		}
+		p.pos++
+		if c != quote {
+			continue
+		}
+		raw := p.src[start+1 : p.pos-1]
+		if quote == '\'' {
+			return raw, nil
+		}
+		s, err := strconv.Unquote(`"` + raw + `"`)
+		if err != nil {
+			return "", p.errorf("invalid escape in string")
+		}
+		return s, nil
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *fileParser) word() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '#' || c == ',' || c == ']' {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *fileParser) endOfLine() error {
+	p.skipInline()
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("unexpected text after value")
+	}
+	p.pos++
+	return nil
+}
+
+func (p *fileParser) skipInline() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *fileParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *fileParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *fileParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *fileParser) line() int {
+	return strings.Count(p.src[:p.pos], "\n") + 1
+}
+
+func (p *fileParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.line(), fmt.Sprintf(format, args...))
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var reloadablePrefixes = []string{
+	"rate_limit.",
+	"tokens.",
+	"scan.",
+	"ice.",
+	"quota.",
+	"relay.",
+	"throttle.",
+}
+
+func Reloadable(key string) bool {
+	for _, prefix := range reloadablePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func Diff(current, next Config) []string {
+	var changed []string
+	for _, s := range settings {
+		if s.format(current) != s.format(next) {
+			changed = append(changed, s.key)
+		}
+	}
+	if !reflect.DeepEqual(formatRoutes(current), formatRoutes(next)) {
+		changed = append(changed, routesKey)
+	}
+	return changed
+}
+
+func Reload(current, next Config) (Config, []string, []string) {
+	merged := current
+	var applied, ignored []string
+	for _, key := range Diff(current, next) {
+		if !Reloadable(key) {
+			ignored = append(ignored, key)
+			continue
+		}
+		applied = append(applied, key)
+		if key == routesKey {
+			merged.RateLimitRoutes = next.RateLimitRoutes
+			continue
+		}
+		settingsByKey[key].copy(&merged, next)
+	}
+	return merged, applied, ignored
+}
+
+func WatchFile(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	if path == "" || interval <= 0 {
+		return
+	}
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				last = info
+				onChange()
+			}
+		}
+	}
+}
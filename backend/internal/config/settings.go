@@ -0,0 +1,538 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"universaldrop/internal/clientip"
+)
+
+const routesKey = "rate_limit.routes"
+
+type setting struct {
+	key    string
+	env    string
+	secret bool
+	apply  func(cfg *Config, v value) error
+	format func(cfg Config) string
+	copy   func(dst *Config, src Config)
+}
+
+var settings = []setting{
+	stringSetting("address", "UD_ADDRESS", func(c *Config) *string { return &c.Address }),
+	stringSetting("data_dir", "UD_DATA_DIR", func(c *Config) *string { return &c.DataDir }),
+	durationSetting("sweep_interval", "UD_SWEEP_INTERVAL", time.Second, 0, func(c *Config) *time.Duration { return &c.SweepInterval }),
+	durationSetting("watch_interval", "UD_CONFIG_WATCH_INTERVAL", 0, 0, func(c *Config) *time.Duration { return &c.WatchInterval }),
+	smallIntSetting("rate_limit.health.max", "UD_RATE_LIMIT_HEALTH_MAX", 1, 0, func(c *Config) *int { return &c.RateLimitHealth.Max }),
+	durationSetting("rate_limit.health.window", "UD_RATE_LIMIT_HEALTH_WINDOW", time.Second, 0, func(c *Config) *time.Duration { return &c.RateLimitHealth.Window }),
+	smallIntSetting("rate_limit.health.burst", "UD_RATE_LIMIT_HEALTH_BURST", 0, 0, func(c *Config) *int { return &c.RateLimitHealth.Burst }),
+	smallIntSetting("rate_limit.v1.max", "UD_RATE_LIMIT_V1_MAX", 1, 0, func(c *Config) *int { return &c.RateLimitV1.Max }),
+	durationSetting("rate_limit.v1.window", "UD_RATE_LIMIT_V1_WINDOW", time.Second, 0, func(c *Config) *time.Duration { return &c.RateLimitV1.Window }),
+	smallIntSetting("rate_limit.v1.burst", "UD_RATE_LIMIT_V1_BURST", 0, 0, func(c *Config) *int { return &c.RateLimitV1.Burst }),
+	smallIntSetting("rate_limit.session_claim.max", "UD_RATE_LIMIT_SESSION_CLAIM_MAX", 1, 0, func(c *Config) *int { return &c.RateLimitSessionClaim.Max }),
+	durationSetting("rate_limit.session_claim.window", "UD_RATE_LIMIT_SESSION_CLAIM_WINDOW", time.Second, 0, func(c *Config) *time.Duration { return &c.RateLimitSessionClaim.Window }),
+	smallIntSetting("rate_limit.session_claim.burst", "UD_RATE_LIMIT_SESSION_CLAIM_BURST", 0, 0, func(c *Config) *int { return &c.RateLimitSessionClaim.Burst }),
+	durationSetting("tokens.claim_ttl", "UD_CLAIM_TOKEN_TTL", MinClaimTokenTTL, MaxClaimTokenTTL, func(c *Config) *time.Duration { return &c.ClaimTokenTTL }),
+	durationSetting("tokens.transfer_ttl", "UD_TRANSFER_TOKEN_TTL", MinTransferTokenTTL, MaxTransferTokenTTL, func(c *Config) *time.Duration { return &c.TransferTokenTTL }),
+	durationSetting("tokens.download_ttl", "UD_DOWNLOAD_TOKEN_TTL", 0, 0, func(c *Config) *time.Duration { return &c.DownloadTokenTTL }),
+	intSetting("scan.max_bytes", "UD_MAX_SCAN_BYTES", 1, 0, func(c *Config) *int64 { return &c.MaxScanBytes }),
+	durationSetting("scan.max_duration", "UD_MAX_SCAN_DURATION", time.Second, 0, func(c *Config) *time.Duration { return &c.MaxScanDuration }),
+	listSetting("ice.stun_urls", "UD_STUN_URLS", splitCSV, func(c *Config) *[]string { return &c.STUNURLs }),
+	listSetting("ice.turn_urls", "UD_TURN_URLS", splitCSV, func(c *Config) *[]string { return &c.TURNURLs }),
+	{
+		key:    "ice.turn_shared_secret_b64",
+		env:    "UD_TURN_SHARED_SECRET_B64",
+		secret: true,
+		apply: func(c *Config, v value) error {
+			raw, err := v.str()
+			if err != nil {
+				return err
+			}
+			if raw == "" {
+				c.TURNSharedSecret = nil
+				return nil
+			}
+			decoded, err := base64.RawURLEncoding.DecodeString(raw)
+			if err != nil {
+				decoded, err = base64.StdEncoding.DecodeString(raw)
+			}
+			if err != nil || len(decoded) == 0 {
+				return errors.New("must be base64")
+			}
+			c.TURNSharedSecret = decoded
+			return nil
+		},
+		format: func(c Config) string {
+			return strconv.Quote(base64.StdEncoding.EncodeToString(c.TURNSharedSecret))
+		},
+		copy: func(dst *Config, src Config) { dst.TURNSharedSecret = src.TURNSharedSecret },
+	},
+	intSetting("quota.ip.sessions_per_day", "UD_QUOTA_IP_SESSIONS_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.SessionsPerDayIP }),
+	intSetting("quota.ip.transfers_per_day", "UD_QUOTA_IP_TRANSFERS_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.TransfersPerDayIP }),
+	intSetting("quota.ip.bytes_per_day", "UD_QUOTA_IP_BYTES_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.BytesPerDayIP }),
+	smallIntSetting("quota.ip.concurrent_transfers", "UD_QUOTA_IP_CONCURRENT_TRANSFERS", 0, 0, func(c *Config) *int { return &c.Quotas.ConcurrentTransfersIP }),
+	intSetting("quota.session.sessions_per_day", "UD_QUOTA_SESSION_SESSIONS_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.SessionsPerDaySession }),
+	intSetting("quota.session.transfers_per_day", "UD_QUOTA_SESSION_TRANSFERS_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.TransfersPerDaySession }),
+	intSetting("quota.session.bytes_per_day", "UD_QUOTA_SESSION_BYTES_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.BytesPerDaySession }),
+	smallIntSetting("quota.session.concurrent_transfers", "UD_QUOTA_SESSION_CONCURRENT_TRANSFERS", 0, 0, func(c *Config) *int { return &c.Quotas.ConcurrentTransfersSession }),
+	intSetting("quota.sender.sessions_per_day", "UD_QUOTA_SENDER_SESSIONS_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.SessionsPerDaySender }),
+	intSetting("quota.sender.bytes_per_day", "UD_QUOTA_SENDER_BYTES_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.BytesPerDaySender }),
+	smallIntSetting("quota.sender.concurrent_transfers", "UD_QUOTA_SENDER_CONCURRENT_TRANSFERS", 0, 0, func(c *Config) *int { return &c.Quotas.ConcurrentTransfersSender }),
+	intSetting("quota.receiver.sessions_per_day", "UD_QUOTA_RECEIVER_SESSIONS_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.SessionsPerDayReceiver }),
+	intSetting("quota.receiver.bytes_per_day", "UD_QUOTA_RECEIVER_BYTES_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.BytesPerDayReceiver }),
+	smallIntSetting("quota.receiver.concurrent_transfers", "UD_QUOTA_RECEIVER_CONCURRENT_TRANSFERS", 0, 0, func(c *Config) *int { return &c.Quotas.ConcurrentTransfersReceiver }),
+	intSetting("relay.issuance_per_day", "UD_RELAY_ISSUANCE_PER_DAY", 0, 0, func(c *Config) *int64 { return &c.Quotas.RelayPerIdentityPerDay }),
+	smallIntSetting("relay.concurrent_sessions", "UD_RELAY_CONCURRENT_SESSIONS", 0, 0, func(c *Config) *int { return &c.Quotas.RelayConcurrentPerIdentity }),
+	intSetting("throttle.transfer_bandwidth_bps", "UD_TRANSFER_BANDWIDTH_BPS", 0, 0, func(c *Config) *int64 { return &c.Throttles.TransferBandwidthCapBps }),
+	intSetting("throttle.global_bandwidth_bps", "UD_GLOBAL_BANDWIDTH_BPS", 0, 0, func(c *Config) *int64 { return &c.Throttles.GlobalBandwidthCapBps }),
+	intSetting("admission.max_inflight_bytes", "UD_ADMISSION_MAX_INFLIGHT_BYTES", 0, 0, func(c *Config) *int64 { return &c.Admission.MaxInflightBytes }),
+	smallIntSetting("admission.max_goroutines", "UD_ADMISSION_MAX_GOROUTINES", 0, 0, func(c *Config) *int { return &c.Admission.MaxGoroutines }),
+	durationSetting("admission.max_storage_latency", "UD_ADMISSION_MAX_STORAGE_LATENCY", 0, 0, func(c *Config) *time.Duration { return &c.Admission.MaxStorageLatency }),
+	smallIntSetting("admission.shed_percent", "UD_ADMISSION_SHED_PERCENT", 1, 100, func(c *Config) *int { return &c.Admission.ShedPercent }),
+	stringSetting("log.format", "UD_LOG_FORMAT", func(c *Config) *string { return &c.Log.Format }, LogFormatJSON, LogFormatText),
+	{
+		key: "log.level",
+		env: "UD_LOG_LEVEL",
+		apply: func(c *Config, v value) error {
+			raw, err := v.str()
+			if err != nil {
+				return err
+			}
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(raw)); err != nil {
+				return errors.New("must be one of debug, info, warn, error")
+			}
+			c.Log.Level = strings.ToLower(raw)
+			return nil
+		},
+		format: func(c Config) string { return strconv.Quote(c.Log.Level) },
+		copy:   func(dst *Config, src Config) { dst.Log.Level = src.Log.Level },
+	},
+	stringSetting("tracing.exporter", "UD_TRACING_EXPORTER", func(c *Config) *string { return &c.Tracing.Exporter }, "", TracingExporterOTLP, TracingExporterFile),
+	stringSetting("tracing.otlp_endpoint", "UD_TRACING_OTLP_ENDPOINT", func(c *Config) *string { return &c.Tracing.OTLPEndpoint }),
+	stringSetting("tracing.file", "UD_TRACING_FILE", func(c *Config) *string { return &c.Tracing.File }),
+	smallIntSetting("tracing.sample_percent", "UD_TRACING_SAMPLE_PERCENT", 1, 100, func(c *Config) *int { return &c.Tracing.SamplePercent }),
+	boolSetting("audit.disabled", "UD_AUDIT_DISABLED", func(c *Config) *bool { return &c.Audit.Disabled }),
+	stringSetting("audit.dir", "UD_AUDIT_DIR", func(c *Config) *string { return &c.Audit.Dir }),
+	intSetting("audit.max_file_bytes", "UD_AUDIT_MAX_FILE_BYTES", 1, 0, func(c *Config) *int64 { return &c.Audit.MaxFileBytes }),
+	stringSetting("audit.key.provider", "UD_AUDIT_KEY_PROVIDER", func(c *Config) *string { return &c.Audit.Key.Provider }),
+	stringSetting("audit.key.env", "UD_AUDIT_KEY_ENV", func(c *Config) *string { return &c.Audit.Key.Env }),
+	stringSetting("audit.key.file", "UD_AUDIT_KEY_FILE", func(c *Config) *string { return &c.Audit.Key.Path }),
+	stringSetting("audit.key.passphrase_env", "UD_AUDIT_KEY_PASSPHRASE_ENV", func(c *Config) *string { return &c.Audit.Key.PassphraseEnv }),
+	secretListSetting("audit.key.command", "UD_AUDIT_KEY_COMMAND", strings.Fields, func(c *Config) *[]string { return &c.Audit.Key.Command }),
+	stringSetting("tls.cert_file", "UD_TLS_CERT_FILE", func(c *Config) *string { return &c.TLS.CertFile }),
+	stringSetting("tls.key_file", "UD_TLS_KEY_FILE", func(c *Config) *string { return &c.TLS.KeyFile }),
+	durationSetting("tls.reload_interval", "UD_TLS_RELOAD_INTERVAL", 0, 0, func(c *Config) *time.Duration { return &c.TLS.ReloadInterval }),
+	stringSetting("admin.address", "UD_ADMIN_ADDRESS", func(c *Config) *string { return &c.Admin.Address }),
+	stringSetting("admin.client_ca_file", "UD_ADMIN_CLIENT_CA_FILE", func(c *Config) *string { return &c.Admin.ClientCAFile }),
+	stringSetting("metrics.address", "UD_METRICS_ADDRESS", func(c *Config) *string { return &c.Metrics.Address }),
+	secretStringSetting("metrics.bearer_token", "UD_METRICS_BEARER_TOKEN", func(c *Config) *string { return &c.Metrics.BearerToken }),
+	stringSetting("metrics.client_ca_file", "UD_METRICS_CLIENT_CA_FILE", func(c *Config) *string { return &c.Metrics.ClientCAFile }),
+	boolSetting("metrics.public", "UD_METRICS_PUBLIC", func(c *Config) *bool { return &c.Metrics.Public }),
+	intSetting("storage.min_free_bytes", "UD_STORAGE_MIN_FREE_BYTES", 0, 0, func(c *Config) *int64 { return &c.Storage.MinFreeBytes }),
+	intSetting("storage.min_free_inodes", "UD_STORAGE_MIN_FREE_INODES", 0, 0, func(c *Config) *int64 { return &c.Storage.MinFreeInodes }),
+	boolSetting("storage.secure_delete", "UD_STORAGE_SECURE_DELETE", func(c *Config) *bool { return &c.Storage.SecureDelete }),
+	secretListSetting("storage.at_rest_previous_keys", "UD_STORAGE_AT_REST_PREVIOUS_KEYS", splitCSV, func(c *Config) *[]string { return &c.Storage.AtRestPreviousKeys }),
+	stringSetting("storage.at_rest_key.provider", "UD_STORAGE_AT_REST_KEY_PROVIDER", func(c *Config) *string { return &c.Storage.AtRestKey.Provider }),
+	stringSetting("storage.at_rest_key.env", "UD_STORAGE_AT_REST_KEY_ENV", func(c *Config) *string { return &c.Storage.AtRestKey.Env }),
+	stringSetting("storage.at_rest_key.file", "UD_STORAGE_AT_REST_KEY_FILE", func(c *Config) *string { return &c.Storage.AtRestKey.Path }),
+	stringSetting("storage.at_rest_key.passphrase_env", "UD_STORAGE_AT_REST_KEY_PASSPHRASE_ENV", func(c *Config) *string { return &c.Storage.AtRestKey.PassphraseEnv }),
+	secretListSetting("storage.at_rest_key.command", "UD_STORAGE_AT_REST_KEY_COMMAND", strings.Fields, func(c *Config) *[]string { return &c.Storage.AtRestKey.Command }),
+	durationSetting("drain.timeout", "UD_DRAIN_TIMEOUT", time.Second, 0, func(c *Config) *time.Duration { return &c.Drain.Timeout }),
+	durationSetting("drain.delay", "UD_DRAIN_DELAY", 0, 0, func(c *Config) *time.Duration { return &c.Drain.Delay }),
+	boolSetting("debug.capability_introspection", "UD_DEBUG_CAPABILITY_INTROSPECTION", func(c *Config) *bool { return &c.DebugCapabilityIntrospection }),
+	{
+		key: "client_ip.trusted_proxies",
+		env: "UD_TRUSTED_PROXIES",
+		apply: func(c *Config, v value) error {
+			values, err := v.strings(splitCSV)
+			if err != nil {
+				return err
+			}
+			if _, err := clientip.ParsePrefixes(values); err != nil {
+				return errors.New("must be IP addresses or CIDR prefixes")
+			}
+			c.TrustedProxies = values
+			return nil
+		},
+		format: func(c Config) string { return formatList(c.TrustedProxies) },
+		copy:   func(dst *Config, src Config) { dst.TrustedProxies = src.TrustedProxies },
+	},
+	stringSetting("client_ip.trusted_header", "UD_TRUSTED_HEADER", func(c *Config) *string { return &c.TrustedHeader }, clientip.HeaderXForwardedFor, clientip.HeaderForwarded),
+	boolSetting("client_ip.proxy_protocol", "UD_PROXY_PROTOCOL", func(c *Config) *bool { return &c.ProxyProtocol }),
+	smallIntSetting("client_ip.prefix_v4", "UD_IP_PREFIX_V4", 1, 32, func(c *Config) *int { return &c.IPPrefixes.IPv4Bits }),
+	smallIntSetting("client_ip.prefix_v6", "UD_IP_PREFIX_V6", 1, 128, func(c *Config) *int { return &c.IPPrefixes.IPv6Bits }),
+	smallIntSetting("client_ip.coarse_prefix_v4", "UD_IP_COARSE_PREFIX_V4", 0, 32, func(c *Config) *int { return &c.IPPrefixes.CoarseIPv4Bits }),
+	smallIntSetting("client_ip.coarse_prefix_v6", "UD_IP_COARSE_PREFIX_V6", 0, 128, func(c *Config) *int { return &c.IPPrefixes.CoarseIPv6Bits }),
+	intSetting("client_ip.coarse_limit_scale", "UD_IP_COARSE_LIMIT_SCALE", 1, 0, func(c *Config) *int64 { return &c.IPPrefixes.CoarseLimitScale }),
+	stringSetting("quota_store.backend", "UD_QUOTA_STORE", func(c *Config) *string { return &c.QuotaStore.Backend }, "memory", "file"),
+	durationSetting("quota_store.flush_interval", "UD_QUOTA_FLUSH_INTERVAL", time.Second, 0, func(c *Config) *time.Duration { return &c.QuotaStore.FlushInterval }),
+	durationSetting("revocations.flush_interval", "UD_REVOCATION_FLUSH_INTERVAL", time.Second, 0, func(c *Config) *time.Duration { return &c.Revocations.FlushInterval }),
+	secretStringSetting("shared_state.url", "UD_SHARED_STATE_URL", func(c *Config) *string { return &c.SharedState.URL }),
+	stringSetting("shared_state.failure_mode", "UD_SHARED_STATE_FAILURE", func(c *Config) *string { return &c.SharedState.FailureMode }, SharedStateFailClosed, SharedStateFailLocal),
+	stringSetting("shared_state.key_prefix", "UD_SHARED_STATE_PREFIX", func(c *Config) *string { return &c.SharedState.KeyPrefix }),
+	stringSetting("token_secret.provider", "UD_TOKEN_SECRET_PROVIDER", func(c *Config) *string { return &c.TokenSecret.Provider }),
+	stringSetting("token_secret.env", "UD_TOKEN_SECRET_ENV", func(c *Config) *string { return &c.TokenSecret.Env }),
+	stringSetting("token_secret.file", "UD_TOKEN_SECRET_FILE", func(c *Config) *string { return &c.TokenSecret.Path }),
+	stringSetting("token_secret.passphrase_env", "UD_TOKEN_SECRET_PASSPHRASE_ENV", func(c *Config) *string { return &c.TokenSecret.PassphraseEnv }),
+	secretListSetting("token_secret.command", "UD_TOKEN_SECRET_COMMAND", strings.Fields, func(c *Config) *[]string { return &c.TokenSecret.Command }),
+	stringSetting("turn_secret.provider", "UD_TURN_SECRET_PROVIDER", func(c *Config) *string { return &c.TURNSecret.Provider }),
+	stringSetting("turn_secret.env", "UD_TURN_SECRET_ENV", func(c *Config) *string { return &c.TURNSecret.Env }),
+	stringSetting("turn_secret.file", "UD_TURN_SECRET_FILE", func(c *Config) *string { return &c.TURNSecret.Path }),
+	stringSetting("turn_secret.passphrase_env", "UD_TURN_SECRET_PASSPHRASE_ENV", func(c *Config) *string { return &c.TURNSecret.PassphraseEnv }),
+	secretListSetting("turn_secret.command", "UD_TURN_SECRET_COMMAND", strings.Fields, func(c *Config) *[]string { return &c.TURNSecret.Command }),
+}
+
+var settingsByKey = map[string]*setting{}
+
+func init() {
+	for i := range settings {
+		settingsByKey[settings[i].key] = &settings[i]
+	}
+}
+
+func applyFile(cfg *Config, path string, entries []fileEntry) []string {
+	var problems []string
+	for _, entry := range entries {
+		name := strings.Join(entry.path, ".")
+		where := fmt.Sprintf("%s:%d", path, entry.line)
+		if entry.table {
+			if !knownTable(name) {
+				problems = append(problems, fmt.Sprintf("%s: unknown table [%s]", where, name))
+			}
+			continue
+		}
+		if len(entry.path) == 3 && strings.Join(entry.path[:2], ".") == routesKey {
+			if err := applyRoute(cfg, entry.path[2], entry.value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s.%q: %v", where, routesKey, entry.path[2], err))
+			}
+			continue
+		}
+		s, ok := settingsByKey[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown key %s", where, name))
+			continue
+		}
+		if err := s.apply(cfg, entry.value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s: %v", where, name, err))
+		}
+	}
+	return problems
+}
+
+func applyEnv(cfg *Config, lookupEnv func(string) (string, bool)) []string {
+	var problems []string
+	for _, s := range settings {
+		raw, ok := lookupEnv(s.env)
+		if !ok || strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if err := s.apply(cfg, value{kind: kindEnv, text: strings.TrimSpace(raw)}); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", s.env, s.key, err))
+		}
+	}
+	if raw, ok := lookupEnv("UD_RATE_LIMIT_ROUTES"); ok && strings.TrimSpace(raw) != "" {
+		cfg.RateLimitRoutes = nil
+		for i, entry := range splitCSV(raw) {
+			pattern, spec, ok := strings.Cut(entry, "=")
+			pattern = strings.TrimSpace(pattern)
+			if !ok || pattern == "" {
+				problems = append(problems, fmt.Sprintf("UD_RATE_LIMIT_ROUTES (%s): entry %d must look like pattern=max/window[:burst]", routesKey, i+1))
+				continue
+			}
+			if err := applyRoute(cfg, pattern, value{kind: kindEnv, text: strings.TrimSpace(spec)}); err != nil {
+				problems = append(problems, fmt.Sprintf("UD_RATE_LIMIT_ROUTES (%s): entry %d: %v", routesKey, i+1, err))
+			}
+		}
+	}
+	return problems
+}
+
+func applyRoute(cfg *Config, pattern string, v value) error {
+	spec, err := v.str()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return errors.New("route pattern must start with /")
+	}
+	limit, ok := parseRateLimitSpec(spec)
+	if !ok {
+		return errors.New("must look like max/window[:burst]")
+	}
+	if cfg.RateLimitRoutes == nil {
+		cfg.RateLimitRoutes = map[string]RateLimit{}
+	}
+	cfg.RateLimitRoutes[pattern] = limit
+	return nil
+}
+
+func knownTable(name string) bool {
+	if name == routesKey {
+		return true
+	}
+	for _, s := range settings {
+		if strings.HasPrefix(s.key, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func Dump(w io.Writer, cfg Config) error {
+	var b strings.Builder
+	table := ""
+	for _, s := range settings {
+		section, name := "", s.key
+		if i := strings.LastIndex(s.key, "."); i >= 0 {
+			section, name = s.key[:i], s.key[i+1:]
+		}
+		if section != table {
+			fmt.Fprintf(&b, "\n[%s]\n", section)
+			table = section
+		}
+		formatted := s.format(cfg)
+		if s.secret && formatted != `""` && formatted != "[]" {
+			formatted = `"<redacted>"`
+		}
+		fmt.Fprintf(&b, "%s = %s\n", name, formatted)
+	}
+	if routes := formatRoutes(cfg); len(routes) > 0 {
+		fmt.Fprintf(&b, "\n[%s]\n", routesKey)
+		for _, line := range routes {
+			fmt.Fprintln(&b, line)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatRoutes(cfg Config) []string {
+	patterns := make([]string, 0, len(cfg.RateLimitRoutes))
+	for pattern := range cfg.RateLimitRoutes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	lines := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		limit := cfg.RateLimitRoutes[pattern]
+		spec := fmt.Sprintf("%d/%s", limit.Max, limit.Window)
+		if limit.Burst > 0 {
+			spec += fmt.Sprintf(":%d", limit.Burst)
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", strconv.Quote(pattern), strconv.Quote(spec)))
+	}
+	return lines
+}
+
+func stringSetting(key, env string, field func(*Config) *string, allowed ...string) setting {
+	return setting{
+		key: key,
+		env: env,
+		apply: func(c *Config, v value) error {
+			raw, err := v.str()
+			if err != nil {
+				return err
+			}
+			if len(allowed) > 0 {
+				raw = strings.ToLower(raw)
+				if !contains(allowed, raw) {
+					return fmt.Errorf("must be one of %s", strings.Join(nonEmpty(allowed), ", "))
+				}
+			}
+			*field(c) = raw
+			return nil
+		},
+		format: func(c Config) string { return strconv.Quote(*field(&c)) },
+		copy:   func(dst *Config, src Config) { *field(dst) = *field(&src) },
+	}
+}
+
+func secretStringSetting(key, env string, field func(*Config) *string) setting {
+	s := stringSetting(key, env, field)
+	s.secret = true
+	return s
+}
+
+func intSetting(key, env string, min, max int64, field func(*Config) *int64) setting {
+	return setting{
+		key: key,
+		env: env,
+		apply: func(c *Config, v value) error {
+			n, err := v.integer(min, max)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+		format: func(c Config) string { return strconv.FormatInt(*field(&c), 10) },
+		copy:   func(dst *Config, src Config) { *field(dst) = *field(&src) },
+	}
+}
+
+func smallIntSetting(key, env string, min, max int64, field func(*Config) *int) setting {
+	return setting{
+		key: key,
+		env: env,
+		apply: func(c *Config, v value) error {
+			n, err := v.integer(min, max)
+			if err != nil {
+				return err
+			}
+			*field(c) = int(n)
+			return nil
+		},
+		format: func(c Config) string { return strconv.Itoa(*field(&c)) },
+		copy:   func(dst *Config, src Config) { *field(dst) = *field(&src) },
+	}
+}
+
+func durationSetting(key, env string, min, max time.Duration, field func(*Config) *time.Duration) setting {
+	return setting{
+		key: key,
+		env: env,
+		apply: func(c *Config, v value) error {
+			raw, err := v.str()
+			if err != nil {
+				return errors.New("expected a duration string such as \"30s\"")
+			}
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return errors.New("expected a duration such as 30s or 5m")
+			}
+			if d < min || (max > 0 && d > max) {
+				if max > 0 {
+					return fmt.Errorf("must be between %s and %s", min, max)
+				}
+				return fmt.Errorf("must be at least %s", min)
+			}
+			*field(c) = d
+			return nil
+		},
+		format: func(c Config) string { return strconv.Quote(field(&c).String()) },
+		copy:   func(dst *Config, src Config) { *field(dst) = *field(&src) },
+	}
+}
+
+func boolSetting(key, env string, field func(*Config) *bool) setting {
+	return setting{
+		key: key,
+		env: env,
+		apply: func(c *Config, v value) error {
+			if v.kind != kindBool && v.kind != kindEnv {
+				return errors.New("expected true or false")
+			}
+			b, err := strconv.ParseBool(v.text)
+			if err != nil {
+				return errors.New("expected true or false")
+			}
+			*field(c) = b
+			return nil
+		},
+		format: func(c Config) string { return strconv.FormatBool(*field(&c)) },
+		copy:   func(dst *Config, src Config) { *field(dst) = *field(&src) },
+	}
+}
+
+func listSetting(key, env string, split func(string) []string, field func(*Config) *[]string) setting {
+	return setting{
+		key: key,
+		env: env,
+		apply: func(c *Config, v value) error {
+			values, err := v.strings(split)
+			if err != nil {
+				return err
+			}
+			*field(c) = values
+			return nil
+		},
+		format: func(c Config) string { return formatList(*field(&c)) },
+		copy:   func(dst *Config, src Config) { *field(dst) = *field(&src) },
+	}
+}
+
+func secretListSetting(key, env string, split func(string) []string, field func(*Config) *[]string) setting {
+	s := listSetting(key, env, split, field)
+	s.secret = true
+	return s
+}
+
+func (v value) str() (string, error) {
+	if v.kind != kindString && v.kind != kindEnv {
+		return "", errors.New("expected a string")
+	}
+	return strings.TrimSpace(v.text), nil
+}
+
+func (v value) integer(min, max int64) (int64, error) {
+	if v.kind != kindInteger && v.kind != kindEnv {
+		return 0, errors.New("expected an integer")
+	}
+	n, err := strconv.ParseInt(v.text, 10, 64)
+	if err != nil {
+		return 0, errors.New("expected an integer")
+	}
+	if n < min || (max > 0 && n > max) {
+		if max > 0 {
+			return 0, fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return 0, fmt.Errorf("must be at least %d", min)
+	}
+	return n, nil
+}
+
+func (v value) strings(split func(string) []string) ([]string, error) {
+	switch v.kind {
+	case kindArray:
+		return v.list, nil
+	case kindEnv:
+		return split(v.text), nil
+	default:
+		return nil, errors.New("expected an array of strings")
+	}
+}
+
+func splitCSV(raw string) []string {
+	var values []string
+	for _, part := range strings.Split(raw, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			values = append(values, trimmed)
+		}
+	}
+	return values
+}
+
+func formatList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func contains(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+func nonEmpty(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			out = append(out, value)
+		}
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	NetworkTCP     = "tcp"
+	NetworkUnix    = "unix"
+	NetworkSystemd = "systemd"
+
+	UnixSocketMode = fs.FileMode(0o660)
+
+	systemdFirstFD = 3
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid listener address")
+	ErrNotActivated   = errors.New("socket not passed by systemd")
+	ErrNotSocket      = errors.New("unix socket path is not a socket")
+	ErrSocketInUse    = errors.New("unix socket is already being served")
+)
+
+type Spec struct {
+	Network string
+	Address string
+}
+
+func (s Spec) String() string {
+	return s.Network + ":" + s.Address
+}
+
+func Parse(raw string) (Spec, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, "unix:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(raw, "unix:"), "//")
+		if path == "" {
+			return Spec{}, ErrInvalidAddress
+		}
+		return Spec{Network: NetworkUnix, Address: path}, nil
+	case strings.HasPrefix(raw, "systemd:"):
+		name := strings.TrimPrefix(raw, "systemd:")
+		if name == "" {
+			return Spec{}, ErrInvalidAddress
+		}
+		return Spec{Network: NetworkSystemd, Address: name}, nil
+	case strings.HasPrefix(raw, "tcp://"):
+		raw = strings.TrimPrefix(raw, "tcp://")
+	}
+	if _, _, err := net.SplitHostPort(raw); err != nil {
+		return Spec{}, ErrInvalidAddress
+	}
+	return Spec{Network: NetworkTCP, Address: raw}, nil
+}
+
+type Activated struct {
+	files []*os.File
+	names []string
+	used  []bool
+}
+
+func FromEnv() *Activated {
+	activated := fromEnv(os.Getenv, os.Getpid(), systemdFirstFD)
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(key)
+	}
+	return activated
+}
+
+func fromEnv(getenv func(string) string, pid int, firstFD int) *Activated {
+	activated := &Activated{}
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return activated
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return activated
+	}
+	var names []string
+	if raw := getenv("LISTEN_FDNAMES"); raw != "" {
+		names = strings.Split(raw, ":")
+	}
+	for i := 0; i < count; i++ {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		fd := uintptr(firstFD + i)
+		activated.files = append(activated.files, os.NewFile(fd, "systemd:"+name))
+		activated.names = append(activated.names, name)
+		activated.used = append(activated.used, false)
+	}
+	return activated
+}
+
+func (a *Activated) Len() int {
+	return len(a.files)
+}
+
+func (a *Activated) take(name string) (*os.File, error) {
+	for i, candidate := range a.names {
+		if a.used[i] {
+			continue
+		}
+		if candidate == name || strconv.Itoa(i) == name {
+			a.used[i] = true
+			return a.files[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotActivated, name)
+}
+
+func (a *Activated) CloseUnused() {
+	for i, file := range a.files {
+		if !a.used[i] {
+			a.used[i] = true
+			_ = file.Close()
+		}
+	}
+}
+
+func (a *Activated) Open(spec Spec) (net.Listener, error) {
+	switch spec.Network {
+	case NetworkTCP:
+		return net.Listen("tcp", spec.Address)
+	case NetworkUnix:
+		return listenUnix(spec.Address)
+	case NetworkSystemd:
+		file, err := a.take(spec.Address)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return net.FileListener(file)
+	default:
+		return nil, ErrInvalidAddress
+	}
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNotSocket, path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrSocketInUse, path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, UnixSocketMode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
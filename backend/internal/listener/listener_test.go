@@ -0,0 +1,85 @@
+package listener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for raw, want := range map[string]Spec{
+		":8080":                 {Network: NetworkTCP, Address: ":8080"},
+		"tcp://127.0.0.1:9000":  {Network: NetworkTCP, Address: "127.0.0.1:9000"},
+		"[::1]:443":             {Network: NetworkTCP, Address: "[::1]:443"},
+		"unix:/run/ud/api.sock": {Network: NetworkUnix, Address: "/run/ud/api.sock"},
+		"unix:///run/ud/m.sock": {Network: NetworkUnix, Address: "/run/ud/m.sock"},
+		"systemd:metrics":       {Network: NetworkSystemd, Address: "metrics"},
+	} {
+		got, err := Parse(raw)
+		if err != nil || got != want {
+			t.Fatalf("Parse(%q) = %+v, %v; want %+v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"", "8080", "unix:", "systemd:", "tcp://nohost"} {
+		if _, err := Parse(raw); !errors.Is(err, ErrInvalidAddress) {
+			t.Fatalf("expected %q to be rejected, got %v", raw, err)
+		}
+	}
+}
+
+func roundTrip(t *testing.T, ln net.Listener, network, address string) {
+	t.Helper()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("ok"))
+		_ = conn.Close()
+	}()
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatalf("dial %s %s: %v", network, address, err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "ok" {
+		t.Fatalf("unexpected reply %q %v", data, err)
+	}
+}
+
+func TestOpenUnixSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	activated := &Activated{}
+	ln, err := activated.Open(Spec{Network: NetworkUnix, Address: path})
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced: %v", err)
+	}
+	defer ln.Close()
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != UnixSocketMode {
+		t.Fatalf("expected socket mode %v, got %v %v", UnixSocketMode, info.Mode().Perm(), err)
+	}
+	roundTrip(t, ln, "unix", path)
+
+	if _, err := activated.Open(Spec{Network: NetworkUnix, Address: path}); !errors.Is(err, ErrSocketInUse) {
+		t.Fatalf("expected live socket to be left alone, got %v", err)
+	}
+	regular := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := activated.Open(Spec{Network: NetworkUnix, Address: regular}); !errors.Is(err, ErrNotSocket) {
+		t.Fatalf("expected regular file to be refused, got %v", err)
+	}
+}
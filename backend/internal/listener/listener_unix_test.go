@@ -0,0 +1,77 @@
+//go:build unix
+
+package listener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestSystemdActivatedSockets(t *testing.T) {
+	var fds []int
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		file, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatalf("file: %v", err)
+		}
+		fd, err := syscall.Dup(int(file.Fd()))
+		if err != nil {
+			t.Fatalf("dup: %v", err)
+		}
+		addrs = append(addrs, ln.Addr().String())
+		fds = append(fds, fd)
+		file.Close()
+		ln.Close()
+	}
+	first := fds[0]
+	if fds[1] != first+1 {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		t.Skip("descriptors are not consecutive")
+	}
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "2",
+		"LISTEN_FDNAMES": "public:metrics",
+	}
+	activated := fromEnv(func(key string) string { return env[key] }, os.Getpid(), first)
+	if activated.Len() != 2 {
+		t.Fatalf("expected two activated sockets, got %d", activated.Len())
+	}
+
+	metrics, err := activated.Open(Spec{Network: NetworkSystemd, Address: "metrics"})
+	if err != nil {
+		t.Fatalf("open metrics socket: %v", err)
+	}
+	defer metrics.Close()
+	roundTrip(t, metrics, "tcp", addrs[1])
+	if _, err := activated.Open(Spec{Network: NetworkSystemd, Address: "metrics"}); !errors.Is(err, ErrNotActivated) {
+		t.Fatalf("expected a socket to be handed out once, got %v", err)
+	}
+	public, err := activated.Open(Spec{Network: NetworkSystemd, Address: "0"})
+	if err != nil {
+		t.Fatalf("open socket by index: %v", err)
+	}
+	defer public.Close()
+	roundTrip(t, public, "tcp", addrs[0])
+
+	other := fromEnv(func(key string) string {
+		if key == "LISTEN_PID" {
+			return "1"
+		}
+		return env[key]
+	}, os.Getpid(), first)
+	if other.Len() != 0 {
+		t.Fatalf("expected sockets for another pid to be ignored")
+	}
+	activated.CloseUnused()
+}
@@ -1,11 +1,18 @@
 package logging
 
 import (
-	"log"
+	"context"
+	"io"
+	"log/slog"
 	"os"
 	"strings"
 )
 
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
 var allowlistOrder = []string{
 	"event",
 	"method",
@@ -13,53 +20,207 @@ var allowlistOrder = []string{
 	"status",
 	"duration_ms",
 	"ip_hash",
+	"ip_prefix_hash",
+	"ip_coarse_prefix_hash",
 	"session_id_hash",
 	"claim_id_hash",
 	"transfer_id_hash",
+	"sender_key_hash",
+	"receiver_key_hash",
 	"count",
+	"keys",
 	"scope",
+	"reason",
 	"error",
 	"version",
 }
 
-var allowlistKeys = map[string]struct{}{
-	"event":            {},
-	"method":           {},
-	"route":            {},
-	"status":           {},
-	"duration_ms":      {},
-	"ip_hash":          {},
-	"session_id_hash":  {},
-	"claim_id_hash":    {},
-	"transfer_id_hash": {},
-	"count":            {},
-	"scope":            {},
-	"error":            {},
-	"version":          {},
+var allowlistKeys = map[string]int{}
+
+func init() {
+	for i, key := range allowlistOrder {
+		allowlistKeys[key] = i
+	}
+}
+
+var eventLevels = map[string]slog.Level{
+	"storage_init_failed":           slog.LevelError,
+	"at_rest_key_load_failed":       slog.LevelError,
+	"token_secret_load_failed":      slog.LevelError,
+	"turn_secret_load_failed":       slog.LevelError,
+	"quota_store_init_failed":       slog.LevelError,
+	"revocation_store_init_failed":  slog.LevelError,
+	"tracing_init_failed":           slog.LevelError,
+	"config_invalid":                slog.LevelError,
+	"listen_failed":                 slog.LevelError,
+	"tls_init_failed":               slog.LevelError,
+	"server_error":                  slog.LevelError,
+	"session_create_failed":         slog.LevelError,
+	"sweep_error":                   slog.LevelError,
+	"quota_blocked":                 slog.LevelWarn,
+	"capability_rejected":           slog.LevelWarn,
+	"load_shed":                     slog.LevelWarn,
+	"storage_capacity_rejected":     slog.LevelWarn,
+	"quota_rebuild_failed":          slog.LevelWarn,
+	"quota_store_flush_failed":      slog.LevelWarn,
+	"revocation_store_flush_failed": slog.LevelWarn,
+	"secret_reload_failed":          slog.LevelWarn,
+	"at_rest_rewrap_failed":         slog.LevelWarn,
+	"tls_cert_reload_failed":        slog.LevelWarn,
+	"drain_deadline_exceeded":       slog.LevelWarn,
+	"config_reload_failed":          slog.LevelWarn,
+	"config_reload_ignored":         slog.LevelWarn,
+	"trace_export_dropped":          slog.LevelWarn,
+	"capability_introspected":       slog.LevelWarn,
+}
+
+func Allowed(key string) bool {
+	_, ok := allowlistKeys[key]
+	return ok
 }
 
-func Allowlist(logger *log.Logger, fields map[string]string) {
+func EventLevel(event string) slog.Level {
+	if level, ok := eventLevels[event]; ok {
+		return level
+	}
+	return slog.LevelInfo
+}
+
+func ParseLevel(value string) (slog.Level, bool) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return slog.LevelInfo, false
+	}
+	return level, true
+}
+
+func New(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	return slog.New(NewHandler(w, format, level))
+}
+
+func Discard() *slog.Logger {
+	return New(io.Discard, FormatText, slog.LevelError+1)
+}
+
+type Handler struct {
+	next   slog.Handler
+	attrs  []scopedAttr
+	prefix string
+}
+
+type scopedAttr struct {
+	prefix string
+	attr   slog.Attr
+}
+
+func NewHandler(w io.Writer, format string, level slog.Leveler) *Handler {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.MessageKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}
+	var next slog.Handler
+	if format == FormatJSON {
+		next = slog.NewJSONHandler(w, opts)
+	} else {
+		next = slog.NewTextHandler(w, opts)
+	}
+	return &Handler{next: next}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	fields := make([]slog.Attr, len(allowlistOrder))
+	for _, scoped := range h.attrs {
+		addAllowed(fields, scoped.prefix, scoped.attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addAllowed(fields, h.prefix, attr)
+		return true
+	})
+
+	out := slog.NewRecord(record.Time, record.Level, "", 0)
+	count := 0
+	for _, attr := range fields {
+		if attr.Key == "" {
+			continue
+		}
+		out.AddAttrs(attr)
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scoped := append([]scopedAttr(nil), h.attrs...)
+	for _, attr := range attrs {
+		scoped = append(scoped, scopedAttr{prefix: h.prefix, attr: attr})
+	}
+	return &Handler{next: h.next, attrs: scoped, prefix: h.prefix}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &Handler{next: h.next, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func addAllowed(fields []slog.Attr, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			addAllowed(fields, prefix, member)
+		}
+		return
+	}
+	key := prefix + attr.Key
+	index, ok := allowlistKeys[key]
+	if !ok {
+		return
+	}
+	value := attr.Value.String()
+	if value == "" {
+		return
+	}
+	fields[index] = slog.String(key, value)
+}
+
+func Allowlist(logger *slog.Logger, fields map[string]string) {
 	if logger == nil {
 		return
 	}
-	var parts []string
+	level := EventLevel(fields["event"])
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(fields))
 	for _, key := range allowlistOrder {
-		value, ok := fields[key]
-		if !ok || value == "" {
-			continue
-		}
-		if _, allowed := allowlistKeys[key]; !allowed {
-			continue
+		if value, ok := fields[key]; ok && value != "" {
+			attrs = append(attrs, slog.String(key, value))
 		}
-		parts = append(parts, key+"="+value)
 	}
-	if len(parts) == 0 {
+	if len(attrs) == 0 {
 		return
 	}
-	logger.Print(strings.Join(parts, " "))
+	logger.LogAttrs(ctx, level, "", attrs...)
 }
 
-func Fatal(logger *log.Logger, fields map[string]string) {
+func Fatal(logger *slog.Logger, fields map[string]string) {
 	Allowlist(logger, fields)
 	os.Exit(1)
 }
@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type secretValuer struct{}
+
+func (secretValuer) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("token", "secret-token"), slog.String("scope", "upload"))
+}
+
+func TestHandlerNeverEmitsNonAllowlistedKeys(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatText} {
+		var buf bytes.Buffer
+		logger := New(&buf, format, slog.LevelDebug)
+
+		logger.Info("message with secret-message", "event", "session_created", "token", "secret-token", "session_id", "secret-session")
+		logger.With("ip", "secret-ip", "route", "/v1/ping").WithGroup("request").Info("", "password", "secret-password", "status", "200")
+		logger.Warn("", slog.Group("nested", slog.String("event", "load_shed"), slog.Group("deeper", slog.String("key", "secret-key"), slog.Int("count", 3))))
+		logger.Info("", "payload", secretValuer{})
+		logger.Info("", "secret-only", "secret-value")
+		Allowlist(logger, map[string]string{"event": "quota_blocked", "filename": "secret-file", "reason": ""})
+
+		output := buf.String()
+		if strings.Contains(output, "secret") || strings.Contains(output, "load_shed") {
+			t.Fatalf("%s output leaked a non-allowlisted value:\n%s", format, output)
+		}
+		for _, key := range []string{"token", "session_id", "password", "nested", "deeper", "request", "payload", "filename", "msg", "status", "count", "scope"} {
+			if strings.Contains(output, key+"=") || strings.Contains(output, `"`+key+`"`) {
+				t.Fatalf("%s output contains key %q:\n%s", format, key, output)
+			}
+		}
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("%s expected 3 records (empty ones suppressed), got %d:\n%s", format, len(lines), output)
+		}
+		if format == FormatJSON {
+			for _, line := range lines {
+				var record map[string]any
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("invalid json line %q: %v", line, err)
+				}
+				for key := range record {
+					if _, ok := allowlistKeys[key]; !ok && key != slog.TimeKey && key != slog.LevelKey {
+						t.Fatalf("unexpected key %q in %q", key, line)
+					}
+				}
+			}
+		} else {
+			for _, want := range []string{"event=session_created", "route=/v1/ping", "level=WARN event=quota_blocked"} {
+				if !strings.Contains(output, want) {
+					t.Fatalf("expected %q in text output:\n%s", want, output)
+				}
+			}
+		}
+	}
+}
+
+func TestHandlerFlattensGroupsIntoPrefixedKeys(t *testing.T) {
+	saved := allowlistOrder
+	allowlistOrder = append(append([]string(nil), saved...), "request.status", "request.upstream.count")
+	allowlistKeys["request.status"] = len(saved)
+	allowlistKeys["request.upstream.count"] = len(saved) + 1
+	t.Cleanup(func() {
+		allowlistOrder = saved
+		delete(allowlistKeys, "request.status")
+		delete(allowlistKeys, "request.upstream.count")
+	})
+
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelDebug)
+	logger.With("event", "load_shed").WithGroup("request").With("status", "503").Info("",
+		"event", "secret-event",
+		slog.Group("upstream", slog.Int("count", 2), slog.String("token", "secret-token")),
+		slog.Group("", slog.String("route", "secret-route")),
+	)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid json %q: %v", buf.String(), err)
+	}
+	if record["event"] != "load_shed" || record["request.status"] != "503" || record["request.upstream.count"] != "2" {
+		t.Fatalf("expected grouped keys flattened with their prefix, got %v", record)
+	}
+	if strings.Contains(buf.String(), "secret") || len(record) != 5 {
+		t.Fatalf("expected grouped keys to be checked against the allowlist after prefixing, got %q", buf.String())
+	}
+}
+
+func TestAllowlistUsesEventLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelWarn)
+	Allowlist(logger, map[string]string{"event": "session_created"})
+	Allowlist(logger, map[string]string{"event": "capability_rejected", "reason": "scope"})
+	Allowlist(logger, map[string]string{"event": "listen_failed"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected info event filtered by level, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], `"level":"WARN"`) || !strings.Contains(lines[1], `"level":"ERROR"`) {
+		t.Fatalf("expected per-event levels, got %q", buf.String())
+	}
+	if level, ok := ParseLevel("debug"); !ok || level != slog.LevelDebug {
+		t.Fatalf("expected debug level to parse")
+	}
+	if _, ok := ParseLevel("loud"); ok {
+		t.Fatalf("expected unknown level to be rejected")
+	}
+}
@@ -1,6 +1,9 @@
 package metrics
 
-import "sync/atomic"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type Counters struct {
 	sessionsCreatedTotal      atomic.Uint64
@@ -9,10 +12,31 @@ type Counters struct {
 	transfersExpiredTotal     atomic.Uint64
 	sweeperRunsTotal          atomic.Uint64
 	relayIceConfigIssuedTotal atomic.Uint64
+	bandwidthQueuedTotal      atomic.Uint64
+	bandwidthQueueDelayMicros atomic.Uint64
+	admissionRejectedTotal    atomic.Uint64
+	auditWriteFailuresTotal   atomic.Uint64
+	requestDuration           *histogramVec
+	storageOpDuration         *histogramVec
+	chunkSize                 *histogramVec
+	transferSize              *histogramVec
+	quotaBlocked              *counterVec
+	capabilityRejected        *counterVec
+	scanVerdicts              *counterVec
+	securelyErased            *counterVec
 }
 
 func NewCounters() *Counters {
-	return &Counters{}
+	return &Counters{
+		requestDuration:    newHistogramVec(namespace+"http_request_duration_seconds", "HTTP request latency by route pattern.", "route", durationBuckets),
+		storageOpDuration:  newHistogramVec(namespace+"storage_op_duration_seconds", "Storage operation latency by operation.", "op", storageBuckets),
+		chunkSize:          newHistogramVec(namespace+"chunk_size_bytes", "Accepted upload chunk sizes.", "", chunkSizeBuckets),
+		transferSize:       newHistogramVec(namespace+"transfer_size_bytes", "Declared sizes of finalized transfers.", "", transferSizeBuckets),
+		quotaBlocked:       newCounterVec(namespace+"quota_blocked_total", "Requests rejected by quota, by scope.", "scope"),
+		capabilityRejected: newCounterVec(namespace+"capability_rejected_total", "Capability checks that failed, by reason.", "reason"),
+		scanVerdicts:       newCounterVec(namespace+"scan_verdicts_total", "Scan finalize results, by verdict.", "verdict"),
+		securelyErased:     newCounterVec(namespace+"securely_erased_bytes_total", "Bytes securely erased from storage, by method.", "method"),
+	}
 }
 
 func (c *Counters) IncSessionsCreated() {
@@ -42,13 +66,67 @@ func (c *Counters) IncRelayIceConfigIssued() {
 	c.relayIceConfigIssuedTotal.Add(1)
 }
 
+func (c *Counters) ObserveBandwidthQueueDelay(wait time.Duration) {
+	c.bandwidthQueuedTotal.Add(1)
+	if wait > 0 {
+		c.bandwidthQueueDelayMicros.Add(uint64(wait / time.Microsecond))
+	}
+}
+
+func (c *Counters) IncAdmissionRejected() {
+	c.admissionRejectedTotal.Add(1)
+}
+
+func (c *Counters) IncAuditWriteFailures() {
+	c.auditWriteFailuresTotal.Add(1)
+}
+
+func (c *Counters) ObserveRequest(route string, duration time.Duration) {
+	c.requestDuration.observe(route, duration.Seconds())
+}
+
+func (c *Counters) ObserveStorageOp(op string, duration time.Duration) {
+	c.storageOpDuration.observe(op, duration.Seconds())
+}
+
+func (c *Counters) ObserveChunkSize(bytes int) {
+	c.chunkSize.observe("", float64(bytes))
+}
+
+func (c *Counters) ObserveTransferSize(bytes int64) {
+	c.transferSize.observe("", float64(bytes))
+}
+
+func (c *Counters) IncQuotaBlocked(scope string) {
+	c.quotaBlocked.inc(scope)
+}
+
+func (c *Counters) IncCapabilityRejected(reason string) {
+	c.capabilityRejected.inc(reason)
+}
+
+func (c *Counters) IncScanVerdict(verdict string) {
+	c.scanVerdicts.inc(verdict)
+}
+
+func (c *Counters) AddSecurelyErased(method string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	c.securelyErased.add(method, uint64(bytes))
+}
+
 func (c *Counters) Snapshot() map[string]uint64 {
 	return map[string]uint64{
-		"sessions_created_total":        c.sessionsCreatedTotal.Load(),
-		"transfers_started_total":       c.transfersStartedTotal.Load(),
-		"transfers_completed_total":     c.transfersCompletedTotal.Load(),
-		"transfers_expired_total":       c.transfersExpiredTotal.Load(),
-		"sweeper_runs_total":            c.sweeperRunsTotal.Load(),
-		"relay_ice_config_issued_total": c.relayIceConfigIssuedTotal.Load(),
+		"sessions_created_total":         c.sessionsCreatedTotal.Load(),
+		"transfers_started_total":        c.transfersStartedTotal.Load(),
+		"transfers_completed_total":      c.transfersCompletedTotal.Load(),
+		"transfers_expired_total":        c.transfersExpiredTotal.Load(),
+		"sweeper_runs_total":             c.sweeperRunsTotal.Load(),
+		"relay_ice_config_issued_total":  c.relayIceConfigIssuedTotal.Load(),
+		"bandwidth_queued_total":         c.bandwidthQueuedTotal.Load(),
+		"bandwidth_queue_delay_ms_total": c.bandwidthQueueDelayMicros.Load() / 1000,
+		"admission_rejected_total":       c.admissionRejectedTotal.Load(),
+		"audit_write_failures_total":     c.auditWriteFailuresTotal.Load(),
 	}
 }
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	namespace       = "ud_"
+	maxLabelValues  = 64
+	maxLabelLength  = 64
+	overflowLabel   = "other"
+	unknownLabel    = "unknown"
+	ContentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	durationBuckets     = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	storageBuckets      = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+	chunkSizeBuckets    = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 32 << 20}
+	transferSizeBuckets = []float64{64 << 10, 1 << 20, 16 << 20, 128 << 20, 1 << 30, 4 << 30, 16 << 30}
+)
+
+type histogram struct {
+	mu     sync.Mutex
+	upper  []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(upper []float64) *histogram {
+	return &histogram{upper: upper, counts: make([]uint64, len(upper))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.upper {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) write(out *bufio.Writer, name string, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative := uint64(0)
+	for i, bound := range h.upper {
+		cumulative += h.counts[i]
+		writeSample(out, name+"_bucket", joinLabels(labels, `le="`+formatFloat(bound)+`"`), strconv.FormatUint(cumulative, 10))
+	}
+	writeSample(out, name+"_bucket", joinLabels(labels, `le="+Inf"`), strconv.FormatUint(h.count, 10))
+	writeSample(out, name+"_sum", labels, formatFloat(h.sum))
+	writeSample(out, name+"_count", labels, strconv.FormatUint(h.count, 10))
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+func newHistogramVec(name string, help string, label string, buckets []float64) *histogramVec {
+	return &histogramVec{name: name, help: help, label: label, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (v *histogramVec) observe(labelValue string, value float64) {
+	v.mu.Lock()
+	key := boundedLabel(v.series, labelValue)
+	h := v.series[key]
+	if h == nil {
+		h = newHistogram(v.buckets)
+		v.series[key] = h
+	}
+	v.mu.Unlock()
+	h.observe(value)
+}
+
+func (v *histogramVec) write(out *bufio.Writer) {
+	writeHeader(out, v.name, v.help, "histogram")
+	v.mu.Lock()
+	keys := sortedKeys(v.series)
+	series := make([]*histogram, len(keys))
+	for i, key := range keys {
+		series[i] = v.series[key]
+	}
+	v.mu.Unlock()
+	for i, key := range keys {
+		labels := ""
+		if v.label != "" {
+			labels = v.label + `="` + key + `"`
+		}
+		series[i].write(out, v.name, labels)
+	}
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	label  string
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name string, help string, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: map[string]uint64{}}
+}
+
+func (v *counterVec) inc(labelValue string) {
+	v.add(labelValue, 1)
+}
+
+func (v *counterVec) add(labelValue string, delta uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[boundedLabel(v.values, labelValue)] += delta
+}
+
+func (v *counterVec) write(out *bufio.Writer) {
+	writeHeader(out, v.name, v.help, "counter")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.values) {
+		writeSample(out, v.name, v.label+`="`+key+`"`, strconv.FormatUint(v.values[key], 10))
+	}
+}
+
+func (c *Counters) WritePrometheus(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	snapshot := c.Snapshot()
+	for _, key := range sortedKeys(snapshot) {
+		name := namespace + key
+		writeHeader(out, name, strings.ReplaceAll(key, "_", " "), "counter")
+		writeSample(out, name, "", strconv.FormatUint(snapshot[key], 10))
+	}
+	c.requestDuration.write(out)
+	c.storageOpDuration.write(out)
+	c.chunkSize.write(out)
+	c.transferSize.write(out)
+	c.quotaBlocked.write(out)
+	c.capabilityRejected.write(out)
+	c.scanVerdicts.write(out)
+	c.securelyErased.write(out)
+	return out.Flush()
+}
+
+func boundedLabel[V any](series map[string]V, value string) string {
+	key := sanitizeLabel(value)
+	if _, ok := series[key]; ok || len(series) < maxLabelValues-1 {
+		return key
+	}
+	return overflowLabel
+}
+
+func sanitizeLabel(value string) string {
+	if value == "" {
+		return unknownLabel
+	}
+	if len(value) > maxLabelLength {
+		value = value[:maxLabelLength]
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("_/{}*.:-", r):
+			return r
+		default:
+			return '_'
+		}
+	}, value)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(out *bufio.Writer, name string, help string, kind string) {
+	out.WriteString("# HELP " + name + " " + help + "\n")
+	out.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+func writeSample(out *bufio.Writer, name string, labels string, value string) {
+	out.WriteString(name)
+	if labels != "" {
+		out.WriteString("{" + labels + "}")
+	}
+	out.WriteString(" " + value + "\n")
+}
+
+func joinLabels(labels string, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheusExposition(t *testing.T) {
+	c := NewCounters()
+	c.IncSessionsCreated()
+	c.ObserveRequest("/v1/transfer/chunk", 30*time.Millisecond)
+	c.ObserveRequest("/v1/transfer/chunk", 2*time.Second)
+	c.ObserveChunkSize(8 << 10)
+	c.IncQuotaBlocked("upload_bytes")
+	c.IncCapabilityRejected("expired")
+	c.IncScanVerdict("clean")
+	c.AddSecurelyErased("overwrite", 4096)
+	c.AddSecurelyErased("overwrite", 100)
+
+	var out bytes.Buffer
+	if err := c.WritePrometheus(&out); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"# TYPE ud_sessions_created_total counter\nud_sessions_created_total 1\n",
+		"# TYPE ud_http_request_duration_seconds histogram\n",
+		`ud_http_request_duration_seconds_bucket{route="/v1/transfer/chunk",le="0.025"} 0`,
+		`ud_http_request_duration_seconds_bucket{route="/v1/transfer/chunk",le="0.05"} 1`,
+		`ud_http_request_duration_seconds_bucket{route="/v1/transfer/chunk",le="+Inf"} 2`,
+		`ud_http_request_duration_seconds_count{route="/v1/transfer/chunk"} 2`,
+		`ud_chunk_size_bytes_bucket{le="16384"} 1`,
+		`ud_quota_blocked_total{scope="upload_bytes"} 1`,
+		`ud_capability_rejected_total{reason="expired"} 1`,
+		`ud_scan_verdicts_total{verdict="clean"} 1`,
+		`ud_securely_erased_bytes_total{method="overwrite"} 4196`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in exposition:\n%s", want, text)
+		}
+	}
+}
+
+func TestLabelValuesAreSanitizedAndBounded(t *testing.T) {
+	c := NewCounters()
+	c.IncQuotaBlocked(`a"b\c` + "\n")
+	for i := 0; i < 2*maxLabelValues; i++ {
+		c.IncQuotaBlocked("scope_" + strconv.Itoa(i))
+	}
+
+	var out bytes.Buffer
+	if err := c.WritePrometheus(&out); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	text := out.String()
+	if !strings.Contains(text, `ud_quota_blocked_total{scope="a_b_c_"} 1`) {
+		t.Fatalf("expected unsafe characters replaced:\n%s", text)
+	}
+	if got := strings.Count(text, "ud_quota_blocked_total{"); got != maxLabelValues {
+		t.Fatalf("expected %d series, got %d", maxLabelValues, got)
+	}
+	if !strings.Contains(text, `ud_quota_blocked_total{scope="other"} `) {
+		t.Fatalf("expected overflow series")
+	}
+}
@@ -0,0 +1,133 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+const snapshotVersion = 1
+
+type snapshot struct {
+	V        int                `json:"v"`
+	SavedAt  time.Time          `json:"saved_at"`
+	Counters map[string]counter `json:"counters"`
+	Owners   map[string]Owner   `json:"owners"`
+}
+
+type FileStore struct {
+	*MemoryStore
+	path    string
+	flushMu sync.Mutex
+}
+
+func NewFileStore(path string, clk clock.Clock) (*FileStore, error) {
+	store := &FileStore{
+		MemoryStore: NewMemoryStore(clk),
+		path:        path,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (f *FileStore) load() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.V != snapshotVersion {
+		return errors.New("unsupported quota snapshot version")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, entry := range snap.Counters {
+		f.counters[key] = entry
+	}
+	for id, owner := range snap.Owners {
+		f.owners[id] = owner
+	}
+	f.prune(f.clock.Now().UTC())
+	return nil
+}
+
+func (f *FileStore) Flush(_ context.Context) error {
+	f.flushMu.Lock()
+	defer f.flushMu.Unlock()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrClosed
+	}
+	now := f.clock.Now().UTC()
+	f.prune(now)
+	snap := snapshot{
+		V:        snapshotVersion,
+		SavedAt:  now,
+		Counters: make(map[string]counter, len(f.counters)),
+		Owners:   make(map[string]Owner, len(f.owners)),
+	}
+	for key, entry := range f.counters {
+		snap.Counters[key] = entry
+	}
+	for id, owner := range f.owners {
+		snap.Owners[id] = owner
+	}
+	f.mu.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *FileStore) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = f.Flush(ctx)
+		}
+	}
+}
+
+func (f *FileStore) Close() error {
+	err := f.Flush(context.Background())
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+	closeErr := f.MemoryStore.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
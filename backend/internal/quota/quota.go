@@ -0,0 +1,244 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+var (
+	ErrClosed   = errors.New("quota store closed")
+	ErrLeaseTTL = errors.New("quota lease requires a positive ttl")
+)
+
+type Owner struct {
+	Keys []string `json:"keys"`
+}
+
+type Store interface {
+	Incr(ctx context.Context, key string, delta int64, limit int64, window time.Duration) (int64, bool, error)
+	Get(ctx context.Context, key string) (int64, error)
+	Set(ctx context.Context, key string, value int64) error
+	SetOwner(ctx context.Context, transferID string, owner Owner) error
+	GetOwner(ctx context.Context, transferID string) (Owner, bool, error)
+	DeleteOwner(ctx context.Context, transferID string) (Owner, bool, error)
+	Owners(ctx context.Context) (map[string]Owner, error)
+	Hold(ctx context.Context, key string, holder string, limit int64, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key string, holder string) error
+	Holders(ctx context.Context, key string) (int64, error)
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+type counter struct {
+	Start  time.Time     `json:"start"`
+	Window time.Duration `json:"window"`
+	Value  int64         `json:"value"`
+}
+
+func (c counter) expired(now time.Time) bool {
+	return c.Window > 0 && now.Sub(c.Start) >= c.Window
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	counters map[string]counter
+	owners   map[string]Owner
+	leases   map[string]map[string]time.Time
+	closed   bool
+}
+
+func NewMemoryStore(clk clock.Clock) *MemoryStore {
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	return &MemoryStore{
+		clock:    clk,
+		counters: map[string]counter{},
+		owners:   map[string]Owner{},
+		leases:   map[string]map[string]time.Time{},
+	}
+}
+
+func (m *MemoryStore) Incr(_ context.Context, key string, delta int64, limit int64, window time.Duration) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, false, ErrClosed
+	}
+	now := m.clock.Now().UTC()
+	entry, ok := m.counters[key]
+	if !ok || entry.expired(now) {
+		entry = counter{Start: now, Window: window}
+	}
+	if delta > 0 && limit > 0 && entry.Value+delta > limit {
+		return entry.Value, false, nil
+	}
+	entry.Value += delta
+	if entry.Value < 0 {
+		entry.Value = 0
+	}
+	if entry.Value == 0 && entry.Window == 0 {
+		delete(m.counters, key)
+		return 0, true, nil
+	}
+	m.counters[key] = entry
+	return entry.Value, true, nil
+}
+
+func (m *MemoryStore) Get(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	entry, ok := m.counters[key]
+	if !ok || entry.expired(m.clock.Now().UTC()) {
+		return 0, nil
+	}
+	return entry.Value, nil
+}
+
+func (m *MemoryStore) Set(_ context.Context, key string, value int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if value <= 0 {
+		delete(m.counters, key)
+		return nil
+	}
+	m.counters[key] = counter{Start: m.clock.Now().UTC(), Value: value}
+	return nil
+}
+
+func (m *MemoryStore) SetOwner(_ context.Context, transferID string, owner Owner) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.owners[transferID] = Owner{Keys: append([]string(nil), owner.Keys...)}
+	return nil
+}
+
+func (m *MemoryStore) GetOwner(_ context.Context, transferID string) (Owner, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return Owner{}, false, ErrClosed
+	}
+	owner, ok := m.owners[transferID]
+	return Owner{Keys: append([]string(nil), owner.Keys...)}, ok, nil
+}
+
+func (m *MemoryStore) DeleteOwner(_ context.Context, transferID string) (Owner, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return Owner{}, false, ErrClosed
+	}
+	owner, ok := m.owners[transferID]
+	delete(m.owners, transferID)
+	return owner, ok, nil
+}
+
+func (m *MemoryStore) Owners(_ context.Context) (map[string]Owner, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	owners := make(map[string]Owner, len(m.owners))
+	for id, owner := range m.owners {
+		owners[id] = Owner{Keys: append([]string(nil), owner.Keys...)}
+	}
+	return owners, nil
+}
+
+func (m *MemoryStore) Hold(_ context.Context, key string, holder string, limit int64, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, ErrLeaseTTL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return false, ErrClosed
+	}
+	now := m.clock.Now().UTC()
+	holders := m.liveHolders(key, now)
+	if _, held := holders[holder]; !held && limit > 0 && int64(len(holders)) >= limit {
+		return false, nil
+	}
+	if holders == nil {
+		holders = map[string]time.Time{}
+		m.leases[key] = holders
+	}
+	holders[holder] = now.Add(ttl)
+	return true, nil
+}
+
+func (m *MemoryStore) Release(_ context.Context, key string, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if holders, ok := m.leases[key]; ok {
+		delete(holders, holder)
+		if len(holders) == 0 {
+			delete(m.leases, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Holders(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	return int64(len(m.liveHolders(key, m.clock.Now().UTC()))), nil
+}
+
+func (m *MemoryStore) liveHolders(key string, now time.Time) map[string]time.Time {
+	holders, ok := m.leases[key]
+	if !ok {
+		return nil
+	}
+	for holder, expiresAt := range holders {
+		if !now.Before(expiresAt) {
+			delete(holders, holder)
+		}
+	}
+	if len(holders) == 0 {
+		delete(m.leases, key)
+		return nil
+	}
+	return holders
+}
+
+func (m *MemoryStore) Flush(_ context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *MemoryStore) prune(now time.Time) {
+	for key, entry := range m.counters {
+		if entry.expired(now) {
+			delete(m.counters, key)
+		}
+	}
+}
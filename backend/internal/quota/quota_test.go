@@ -0,0 +1,152 @@
+package quota
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+func TestMemoryStoreIncrEnforcesLimitAndWindow(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore(clk)
+
+	for i := 0; i < 2; i++ {
+		if _, ok, err := store.Incr(ctx, "k", 1, 2, time.Hour); err != nil || !ok {
+			t.Fatalf("expected increment %d to pass, ok=%v err=%v", i, ok, err)
+		}
+	}
+	if value, ok, _ := store.Incr(ctx, "k", 1, 2, time.Hour); ok || value != 2 {
+		t.Fatalf("expected limit to block at 2, got ok=%v value=%d", ok, value)
+	}
+	if value, ok, _ := store.Incr(ctx, "k", -1, 0, time.Hour); !ok || value != 1 {
+		t.Fatalf("expected decrement to apply, got ok=%v value=%d", ok, value)
+	}
+
+	clk.Advance(59 * time.Minute)
+	if value, _ := store.Get(ctx, "k"); value != 1 {
+		t.Fatalf("expected counter to persist inside window, got %d", value)
+	}
+	clk.Advance(time.Minute)
+	if value, _ := store.Get(ctx, "k"); value != 0 {
+		t.Fatalf("expected counter to reset after window, got %d", value)
+	}
+	if _, ok, _ := store.Incr(ctx, "k", 2, 2, time.Hour); !ok {
+		t.Fatalf("expected fresh window to allow increments")
+	}
+}
+
+func TestMemoryStoreOwners(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(nil)
+	if err := store.SetOwner(ctx, "t1", Owner{Keys: []string{"a", "b"}}); err != nil {
+		t.Fatalf("set owner: %v", err)
+	}
+	owner, ok, err := store.GetOwner(ctx, "t1")
+	if err != nil || !ok || len(owner.Keys) != 2 {
+		t.Fatalf("expected owner, got %+v ok=%v err=%v", owner, ok, err)
+	}
+	if _, ok, _ := store.DeleteOwner(ctx, "t1"); !ok {
+		t.Fatalf("expected owner delete to report existing owner")
+	}
+	if _, ok, _ := store.GetOwner(ctx, "t1"); ok {
+		t.Fatalf("expected owner to be removed")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, _, err := store.Incr(ctx, "k", 1, 0, 0); err != ErrClosed {
+		t.Fatalf("expected closed store to error, got %v", err)
+	}
+}
+
+func TestMemoryStoreLeasesExpireUnlessRenewed(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore(clk)
+	exerciseLeases(t, clk, store, store)
+}
+
+func exerciseLeases(t *testing.T, clk *clock.FakeClock, first Store, second Store) {
+	t.Helper()
+	ctx := context.Background()
+	if held, err := first.Hold(ctx, "c", "t1", 1, time.Minute); err != nil || !held {
+		t.Fatalf("expected first lease, held=%v err=%v", held, err)
+	}
+	if held, _ := second.Hold(ctx, "c", "t2", 1, time.Minute); held {
+		t.Fatalf("expected the limit to apply across stores")
+	}
+	clk.Advance(30 * time.Second)
+	if held, _ := first.Hold(ctx, "c", "t1", 1, time.Minute); !held {
+		t.Fatalf("expected the holder to renew its own lease at the limit")
+	}
+	clk.Advance(45 * time.Second)
+	if held, _ := second.Hold(ctx, "c", "t2", 1, time.Minute); held {
+		t.Fatalf("expected the renewed lease to still count")
+	}
+	clk.Advance(30 * time.Second)
+	if held, _ := second.Hold(ctx, "c", "t2", 1, time.Minute); !held {
+		t.Fatalf("expected an abandoned lease to expire")
+	}
+	if count, err := first.Holders(ctx, "c"); err != nil || count != 1 {
+		t.Fatalf("expected one live holder, got %d err=%v", count, err)
+	}
+	if err := first.Release(ctx, "c", "t2"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if count, _ := second.Holders(ctx, "c"); count != 0 {
+		t.Fatalf("expected release to free the slot, got %d", count)
+	}
+	if _, err := first.Hold(ctx, "c", "t3", 1, 0); err != ErrLeaseTTL {
+		t.Fatalf("expected a lease without ttl to be refused, got %v", err)
+	}
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "quota", "counters.json")
+
+	first, err := NewFileStore(path, clk)
+	if err != nil {
+		t.Fatalf("new file store: %v", err)
+	}
+	if _, ok, err := first.Incr(ctx, "daily", 3, 5, 24*time.Hour); err != nil || !ok {
+		t.Fatalf("incr daily: ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := first.Incr(ctx, "short", 1, 0, time.Minute); err != nil || !ok {
+		t.Fatalf("incr short: ok=%v err=%v", ok, err)
+	}
+	if err := first.SetOwner(ctx, "t1", Owner{Keys: []string{"concurrent"}}); err != nil {
+		t.Fatalf("set owner: %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	second, err := NewFileStore(path, clk)
+	if err != nil {
+		t.Fatalf("reopen file store: %v", err)
+	}
+	defer second.Close()
+	if value, _ := second.Get(ctx, "daily"); value != 3 {
+		t.Fatalf("expected daily counter to survive restart, got %d", value)
+	}
+	if value, _ := second.Get(ctx, "short"); value != 0 {
+		t.Fatalf("expected expired counter to be dropped, got %d", value)
+	}
+	if _, ok, _ := second.Incr(ctx, "daily", 3, 5, 24*time.Hour); ok {
+		t.Fatalf("expected carried-over counter to enforce limit")
+	}
+	if _, ok, _ := second.GetOwner(ctx, "t1"); !ok {
+		t.Fatalf("expected owner to survive restart")
+	}
+
+	clk.Advance(22 * time.Hour)
+	if _, ok, _ := second.Incr(ctx, "daily", 3, 5, 24*time.Hour); !ok {
+		t.Fatalf("expected rolling window to reset 24h after first use")
+	}
+}
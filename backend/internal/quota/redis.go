@@ -0,0 +1,396 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"universaldrop/internal/redis"
+)
+
+const ownersKey = "owners"
+
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+const incrScript = `
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local delta = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+if delta > 0 and limit > 0 and current + delta > limit then
+	return {current, 0}
+end
+local value = current + delta
+if value < 0 then
+	value = 0
+end
+value = redis.call('INCRBY', KEYS[1], value - current)
+if window > 0 and redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], window)
+end
+return {value, 1}
+`
+
+const holdScript = `
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+local holder = ARGV[1]
+local limit = tonumber(ARGV[2])
+local expires = now + tonumber(ARGV[3])
+local entries = redis.call('HGETALL', KEYS[1])
+local others = 0
+local held = false
+local latest = expires
+for i = 1, #entries, 2 do
+	local at = tonumber(entries[i + 1])
+	if at <= now then
+		redis.call('HDEL', KEYS[1], entries[i])
+	elseif entries[i] == holder then
+		held = true
+	else
+		others = others + 1
+		if at > latest then
+			latest = at
+		end
+	end
+end
+if not held and limit > 0 and others >= limit then
+	return 0
+end
+redis.call('HSET', KEYS[1], holder, expires)
+redis.call('PEXPIRE', KEYS[1], latest - now)
+return 1
+`
+
+const holdersScript = `
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+local entries = redis.call('HGETALL', KEYS[1])
+local live = 0
+for i = 2, #entries, 2 do
+	if tonumber(entries[i]) > now then
+		live = live + 1
+	end
+end
+return live
+`
+
+func (r *RedisStore) Incr(ctx context.Context, key string, delta int64, limit int64, window time.Duration) (int64, bool, error) {
+	reply, err := r.client.Do(ctx, "EVAL", incrScript, "1", r.prefix+key,
+		strconv.FormatInt(delta, 10),
+		strconv.FormatInt(limit, 10),
+		strconv.FormatInt(window.Milliseconds(), 10))
+	if err != nil {
+		return 0, false, err
+	}
+	replies, ok := reply.([]any)
+	if !ok || len(replies) != 2 {
+		return 0, false, redis.ErrProtocol
+	}
+	value, err := replyInt(replies[0])
+	if err != nil {
+		return 0, false, err
+	}
+	allowed, err := replyInt(replies[1])
+	if err != nil {
+		return 0, false, err
+	}
+	return value, allowed == 1, nil
+}
+
+func (r *RedisStore) Get(ctx context.Context, key string) (int64, error) {
+	reply, err := r.client.Do(ctx, "GET", r.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	if reply == nil {
+		return 0, nil
+	}
+	value, err := replyInt(reply)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, nil
+	}
+	return value, nil
+}
+
+func (r *RedisStore) Set(ctx context.Context, key string, value int64) error {
+	if value <= 0 {
+		_, err := r.client.Do(ctx, "DEL", r.prefix+key)
+		return err
+	}
+	_, err := r.client.Do(ctx, "SET", r.prefix+key, strconv.FormatInt(value, 10))
+	return err
+}
+
+func (r *RedisStore) SetOwner(ctx context.Context, transferID string, owner Owner) error {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Do(ctx, "HSET", r.prefix+ownersKey, transferID, string(data))
+	return err
+}
+
+func (r *RedisStore) GetOwner(ctx context.Context, transferID string) (Owner, bool, error) {
+	reply, err := r.client.Do(ctx, "HGET", r.prefix+ownersKey, transferID)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	if reply == nil {
+		return Owner{}, false, nil
+	}
+	owner, err := decodeOwner(reply)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	return owner, true, nil
+}
+
+func (r *RedisStore) DeleteOwner(ctx context.Context, transferID string) (Owner, bool, error) {
+	owner, ok, err := r.GetOwner(ctx, transferID)
+	if err != nil || !ok {
+		return Owner{}, false, err
+	}
+	reply, err := r.client.Do(ctx, "HDEL", r.prefix+ownersKey, transferID)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	removed, err := replyInt(reply)
+	if err != nil {
+		return Owner{}, false, err
+	}
+	if removed == 0 {
+		return Owner{}, false, nil
+	}
+	return owner, true, nil
+}
+
+func (r *RedisStore) Owners(ctx context.Context) (map[string]Owner, error) {
+	reply, err := r.client.Do(ctx, "HGETALL", r.prefix+ownersKey)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items)%2 != 0 {
+		return nil, redis.ErrProtocol
+	}
+	owners := make(map[string]Owner, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		id, ok := items[i].(string)
+		if !ok {
+			return nil, redis.ErrProtocol
+		}
+		owner, err := decodeOwner(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		owners[id] = owner
+	}
+	return owners, nil
+}
+
+func (r *RedisStore) Hold(ctx context.Context, key string, holder string, limit int64, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, ErrLeaseTTL
+	}
+	reply, err := r.client.Do(ctx, "EVAL", holdScript, "1", r.prefix+key, holder,
+		strconv.FormatInt(limit, 10),
+		strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	held, err := replyInt(reply)
+	if err != nil {
+		return false, err
+	}
+	return held == 1, nil
+}
+
+func (r *RedisStore) Release(ctx context.Context, key string, holder string) error {
+	_, err := r.client.Do(ctx, "HDEL", r.prefix+key, holder)
+	return err
+}
+
+func (r *RedisStore) Holders(ctx context.Context, key string) (int64, error) {
+	reply, err := r.client.Do(ctx, "EVAL", holdersScript, "1", r.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	return replyInt(reply)
+}
+
+func (r *RedisStore) Flush(_ context.Context) error {
+	return nil
+}
+
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
+
+func decodeOwner(reply any) (Owner, error) {
+	data, ok := reply.(string)
+	if !ok {
+		return Owner{}, redis.ErrProtocol
+	}
+	var owner Owner
+	if err := json.Unmarshal([]byte(data), &owner); err != nil {
+		return Owner{}, err
+	}
+	return owner, nil
+}
+
+func replyInt(reply any) (int64, error) {
+	value, ok := redis.Int64(reply)
+	if !ok {
+		return 0, redis.ErrProtocol
+	}
+	return value, nil
+}
+
+type FailoverStore struct {
+	primary  Store
+	fallback Store
+	onError  func(error)
+
+	mu       sync.Mutex
+	degraded bool
+}
+
+func NewFailoverStore(primary Store, fallback Store, onError func(error)) *FailoverStore {
+	return &FailoverStore{primary: primary, fallback: fallback, onError: onError}
+}
+
+func (f *FailoverStore) Degraded() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.degraded
+}
+
+func (f *FailoverStore) failed(err error) bool {
+	if err == nil || errors.Is(err, ErrClosed) {
+		f.setDegraded(false)
+		return false
+	}
+	f.setDegraded(true)
+	if f.onError != nil {
+		f.onError(err)
+	}
+	return true
+}
+
+func (f *FailoverStore) setDegraded(value bool) {
+	f.mu.Lock()
+	f.degraded = value
+	f.mu.Unlock()
+}
+
+func (f *FailoverStore) Incr(ctx context.Context, key string, delta int64, limit int64, window time.Duration) (int64, bool, error) {
+	value, ok, err := f.primary.Incr(ctx, key, delta, limit, window)
+	if f.failed(err) {
+		return f.fallback.Incr(ctx, key, delta, limit, window)
+	}
+	return value, ok, err
+}
+
+func (f *FailoverStore) Get(ctx context.Context, key string) (int64, error) {
+	value, err := f.primary.Get(ctx, key)
+	if f.failed(err) {
+		return f.fallback.Get(ctx, key)
+	}
+	return value, err
+}
+
+func (f *FailoverStore) Set(ctx context.Context, key string, value int64) error {
+	err := f.primary.Set(ctx, key, value)
+	if f.failed(err) {
+		return f.fallback.Set(ctx, key, value)
+	}
+	return err
+}
+
+func (f *FailoverStore) SetOwner(ctx context.Context, transferID string, owner Owner) error {
+	err := f.primary.SetOwner(ctx, transferID, owner)
+	if f.failed(err) {
+		return f.fallback.SetOwner(ctx, transferID, owner)
+	}
+	return err
+}
+
+func (f *FailoverStore) GetOwner(ctx context.Context, transferID string) (Owner, bool, error) {
+	owner, ok, err := f.primary.GetOwner(ctx, transferID)
+	if f.failed(err) {
+		return f.fallback.GetOwner(ctx, transferID)
+	}
+	if err == nil && !ok {
+		return f.fallback.GetOwner(ctx, transferID)
+	}
+	return owner, ok, err
+}
+
+func (f *FailoverStore) DeleteOwner(ctx context.Context, transferID string) (Owner, bool, error) {
+	owner, ok, err := f.primary.DeleteOwner(ctx, transferID)
+	if f.failed(err) || (err == nil && !ok) {
+		return f.fallback.DeleteOwner(ctx, transferID)
+	}
+	return owner, ok, err
+}
+
+func (f *FailoverStore) Owners(ctx context.Context) (map[string]Owner, error) {
+	owners, err := f.primary.Owners(ctx)
+	if f.failed(err) {
+		return f.fallback.Owners(ctx)
+	}
+	return owners, err
+}
+
+func (f *FailoverStore) Hold(ctx context.Context, key string, holder string, limit int64, ttl time.Duration) (bool, error) {
+	held, err := f.primary.Hold(ctx, key, holder, limit, ttl)
+	if f.failed(err) {
+		return f.fallback.Hold(ctx, key, holder, limit, ttl)
+	}
+	return held, err
+}
+
+func (f *FailoverStore) Release(ctx context.Context, key string, holder string) error {
+	err := f.primary.Release(ctx, key, holder)
+	if f.failed(err) {
+		return f.fallback.Release(ctx, key, holder)
+	}
+	return err
+}
+
+func (f *FailoverStore) Holders(ctx context.Context, key string) (int64, error) {
+	value, err := f.primary.Holders(ctx, key)
+	if f.failed(err) {
+		return f.fallback.Holders(ctx, key)
+	}
+	return value, err
+}
+
+func (f *FailoverStore) Flush(ctx context.Context) error {
+	if err := f.primary.Flush(ctx); err != nil {
+		return err
+	}
+	return f.fallback.Flush(ctx)
+}
+
+func (f *FailoverStore) Close() error {
+	err := f.primary.Close()
+	if fallbackErr := f.fallback.Close(); err == nil {
+		err = fallbackErr
+	}
+	return err
+}
@@ -0,0 +1,225 @@
+package quota
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+	"universaldrop/internal/redis"
+	"universaldrop/internal/redis/redistest"
+)
+
+func newRedisStoreForTest(t *testing.T, clk clock.Clock) (*redistest.Server, *RedisStore, *RedisStore) {
+	t.Helper()
+	server, err := redistest.NewServer(clk)
+	if err != nil {
+		t.Fatalf("redis stand-in: %v", err)
+	}
+	t.Cleanup(server.Close)
+	server.Script(incrScript, emulateIncrScript)
+	server.Script(holdScript, emulateHoldScript)
+	server.Script(holdersScript, emulateHoldersScript)
+	first := NewRedisStore(redis.NewClient(redis.Options{Addr: server.Addr()}), "ud:quota:")
+	second := NewRedisStore(redis.NewClient(redis.Options{Addr: server.Addr()}), "ud:quota:")
+	t.Cleanup(func() {
+		_ = first.Close()
+		_ = second.Close()
+	})
+	return server, first, second
+}
+
+func TestRedisStoreSharesCountersAcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, first, second := newRedisStoreForTest(t, clk)
+
+	if _, ok, err := first.Incr(ctx, "k", 1, 2, time.Hour); err != nil || !ok {
+		t.Fatalf("expected first replica increment to pass, ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := second.Incr(ctx, "k", 1, 2, time.Hour); err != nil || !ok {
+		t.Fatalf("expected second replica increment to pass, ok=%v err=%v", ok, err)
+	}
+	if value, ok, err := first.Incr(ctx, "k", 1, 2, time.Hour); err != nil || ok || value != 2 {
+		t.Fatalf("expected shared limit to block at 2, got ok=%v value=%d err=%v", ok, value, err)
+	}
+	if value, ok, _ := second.Incr(ctx, "k", -5, 0, time.Hour); !ok || value != 0 {
+		t.Fatalf("expected decrement to clamp at zero, got ok=%v value=%d", ok, value)
+	}
+	if _, _, err := second.Incr(ctx, "k", 1, 2, time.Hour); err != nil {
+		t.Fatalf("incr: %v", err)
+	}
+
+	clk.Advance(time.Hour)
+	if value, _ := first.Get(ctx, "k"); value != 0 {
+		t.Fatalf("expected counter to reset after window, got %d", value)
+	}
+
+	if err := first.Set(ctx, "c", 3); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if value, _ := second.Get(ctx, "c"); value != 3 {
+		t.Fatalf("expected shared counter value 3, got %d", value)
+	}
+}
+
+func emulateIncrScript(call func(args ...string) any, keys []string, args []string) any {
+	current := int64(0)
+	if reply := call("GET", keys[0]); reply != nil {
+		current, _ = strconv.ParseInt(string(reply.([]byte)), 10, 64)
+	}
+	delta, _ := strconv.ParseInt(args[0], 10, 64)
+	limit, _ := strconv.ParseInt(args[1], 10, 64)
+	window := args[2]
+	if delta > 0 && limit > 0 && current+delta > limit {
+		return []any{current, int64(0)}
+	}
+	value := current + delta
+	if value < 0 {
+		value = 0
+	}
+	value = call("INCRBY", keys[0], strconv.FormatInt(value-current, 10)).(int64)
+	if window != "0" && call("PTTL", keys[0]).(int64) < 0 {
+		call("PEXPIRE", keys[0], window)
+	}
+	return []any{value, int64(1)}
+}
+
+func scriptNow(call func(args ...string) any) int64 {
+	clock := call("TIME").([]any)
+	sec, _ := strconv.ParseInt(string(clock[0].([]byte)), 10, 64)
+	usec, _ := strconv.ParseInt(string(clock[1].([]byte)), 10, 64)
+	return sec*1000 + usec/1000
+}
+
+func emulateHoldScript(call func(args ...string) any, keys []string, args []string) any {
+	now := scriptNow(call)
+	holder := args[0]
+	limit, _ := strconv.ParseInt(args[1], 10, 64)
+	ttl, _ := strconv.ParseInt(args[2], 10, 64)
+	expires := now + ttl
+	entries := call("HGETALL", keys[0]).([]any)
+	others := int64(0)
+	held := false
+	latest := expires
+	for i := 0; i < len(entries); i += 2 {
+		field := string(entries[i].([]byte))
+		at, _ := strconv.ParseInt(string(entries[i+1].([]byte)), 10, 64)
+		switch {
+		case at <= now:
+			call("HDEL", keys[0], field)
+		case field == holder:
+			held = true
+		default:
+			others++
+			latest = max(latest, at)
+		}
+	}
+	if !held && limit > 0 && others >= limit {
+		return int64(0)
+	}
+	call("HSET", keys[0], holder, strconv.FormatInt(expires, 10))
+	call("PEXPIRE", keys[0], strconv.FormatInt(latest-now, 10))
+	return int64(1)
+}
+
+func emulateHoldersScript(call func(args ...string) any, keys []string, args []string) any {
+	now := scriptNow(call)
+	entries := call("HGETALL", keys[0]).([]any)
+	live := int64(0)
+	for i := 1; i < len(entries); i += 2 {
+		if at, _ := strconv.ParseInt(string(entries[i].([]byte)), 10, 64); at > now {
+			live++
+		}
+	}
+	return live
+}
+
+func TestRedisStoreLeasesExpireUnlessRenewed(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, first, second := newRedisStoreForTest(t, clk)
+	exerciseLeases(t, clk, first, second)
+}
+
+func TestRedisStoreIncrNeverOvershootsTheLimit(t *testing.T) {
+	ctx := context.Background()
+	_, first, second := newRedisStoreForTest(t, nil)
+
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		store := first
+		if i%2 == 1 {
+			store = second
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, ok, err := store.Incr(ctx, "race", 1, 5, time.Hour)
+			if err != nil {
+				t.Errorf("incr: %v", err)
+				return
+			}
+			if value > 5 {
+				t.Errorf("expected counter never to exceed the limit, saw %d", value)
+			}
+			if ok {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := admitted.Load(); got != 5 {
+		t.Fatalf("expected exactly 5 admissions, got %d", got)
+	}
+	if value, _ := first.Get(ctx, "race"); value != 5 {
+		t.Fatalf("expected stored counter 5, got %d", value)
+	}
+}
+
+func TestRedisStoreOwners(t *testing.T) {
+	ctx := context.Background()
+	_, first, second := newRedisStoreForTest(t, nil)
+
+	if err := first.SetOwner(ctx, "t1", Owner{Keys: []string{"a", "b"}}); err != nil {
+		t.Fatalf("set owner: %v", err)
+	}
+	owners, err := second.Owners(ctx)
+	if err != nil || len(owners["t1"].Keys) != 2 {
+		t.Fatalf("expected owner visible to other replica, got %+v err=%v", owners, err)
+	}
+	if _, ok, _ := second.DeleteOwner(ctx, "t1"); !ok {
+		t.Fatalf("expected owner delete to report existing owner")
+	}
+	if _, ok, _ := first.DeleteOwner(ctx, "t1"); ok {
+		t.Fatalf("expected owner to be released only once")
+	}
+}
+
+func TestFailoverStoreFallsBackWhenBackendDown(t *testing.T) {
+	ctx := context.Background()
+	server, primary, _ := newRedisStoreForTest(t, nil)
+	failures := 0
+	store := NewFailoverStore(primary, NewMemoryStore(nil), func(error) { failures++ })
+
+	if _, ok, err := store.Incr(ctx, "k", 1, 1, time.Hour); err != nil || !ok {
+		t.Fatalf("expected shared increment, ok=%v err=%v", ok, err)
+	}
+	server.Close()
+
+	if _, _, err := primary.Incr(ctx, "k", 1, 1, time.Hour); err == nil {
+		t.Fatalf("expected primary to fail once backend is down")
+	}
+	if _, ok, err := store.Incr(ctx, "k", 1, 1, time.Hour); err != nil || !ok {
+		t.Fatalf("expected local fallback to allow, ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := store.Incr(ctx, "k", 1, 1, time.Hour); ok {
+		t.Fatalf("expected local fallback to enforce limit")
+	}
+	if !store.Degraded() || failures == 0 {
+		t.Fatalf("expected failover store to report degraded state")
+	}
+}
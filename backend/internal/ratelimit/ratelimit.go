@@ -7,48 +7,150 @@ import (
 	"universaldrop/internal/clock"
 )
 
-type Limiter struct {
-	mu     sync.Mutex
-	limit  int
-	window time.Duration
-	clock  clock.Clock
-	buckets map[string]bucket
+type Policy struct {
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+type Taker interface {
+	Take(key string) Decision
+	SetPolicy(policy Policy)
+}
+
+type gcra struct {
+	policy    Policy
+	emission  time.Duration
+	tolerance time.Duration
+}
+
+func newGCRA(policy Policy) gcra {
+	if policy.Burst <= 0 {
+		policy.Burst = policy.Limit
+	}
+	g := gcra{policy: policy}
+	if policy.Limit > 0 && policy.Window > 0 {
+		g.emission = policy.Window / time.Duration(policy.Limit)
+		if g.emission <= 0 {
+			g.emission = time.Nanosecond
+		}
+		g.tolerance = g.emission * time.Duration(policy.Burst)
+	}
+	return g
 }
 
-type bucket struct {
-	start time.Time
-	count int
+func (g gcra) disabled() bool {
+	return g.policy.Limit <= 0 || g.emission <= 0
+}
+
+func (g gcra) take(tat time.Time, now time.Time) (time.Time, Decision) {
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(g.emission)
+	decision := Decision{Limit: g.policy.Burst}
+	if next.Sub(now) > g.tolerance {
+		decision.ResetAfter = tat.Sub(now)
+		decision.RetryAfter = next.Sub(now) - g.tolerance
+		return tat, decision
+	}
+	decision.Allowed = true
+	decision.ResetAfter = next.Sub(now)
+	decision.Remaining = int((g.tolerance - next.Sub(now)) / g.emission)
+	return next, decision
+}
+
+type Limiter struct {
+	mu        sync.Mutex
+	gcra      gcra
+	clock     clock.Clock
+	tats      map[string]time.Time
+	lastEvict time.Time
 }
 
 func New(limit int, window time.Duration, clk clock.Clock) *Limiter {
+	return NewWithPolicy(Policy{Limit: limit, Window: window}, clk)
+}
+
+func NewWithPolicy(policy Policy, clk clock.Clock) *Limiter {
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
 	return &Limiter{
-		limit:   limit,
-		window: window,
-		clock:  clk,
-		buckets: map[string]bucket{},
+		gcra:  newGCRA(policy),
+		clock: clk,
+		tats:  map[string]time.Time{},
 	}
 }
 
+func (l *Limiter) Policy() Policy {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gcra.policy
+}
+
+func (l *Limiter) SetPolicy(policy Policy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gcra = newGCRA(policy)
+}
+
 func (l *Limiter) Allow(key string) bool {
-	if l.limit <= 0 {
-		return true
-	}
+	return l.Take(key).Allowed
+}
 
+func (l *Limiter) Take(key string) Decision {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.gcra.disabled() {
+		return Decision{Allowed: true}
+	}
 
 	now := l.clock.Now()
-	entry := l.buckets[key]
-	if entry.start.IsZero() || now.Sub(entry.start) >= l.window {
-		entry = bucket{start: now, count: 0}
+	l.evictLocked(now)
+
+	next, decision := l.gcra.take(l.tats[key], now)
+	if decision.Allowed {
+		l.tats[key] = next
 	}
+	return decision
+}
+
+func (l *Limiter) Evict() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.evictExpiredLocked(l.clock.Now())
+}
 
-	if entry.count >= l.limit {
-		l.buckets[key] = entry
-		return false
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.tats)
+}
+
+func (l *Limiter) evictLocked(now time.Time) {
+	if !l.lastEvict.IsZero() && now.Sub(l.lastEvict) < l.gcra.policy.Window {
+		return
 	}
+	l.evictExpiredLocked(now)
+}
 
-	entry.count++
-	l.buckets[key] = entry
-	return true
+func (l *Limiter) evictExpiredLocked(now time.Time) int {
+	l.lastEvict = now
+	evicted := 0
+	for key, tat := range l.tats {
+		if !tat.After(now) {
+			delete(l.tats, key)
+			evicted++
+		}
+	}
+	return evicted
 }
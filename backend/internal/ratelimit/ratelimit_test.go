@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := NewWithPolicy(Policy{Limit: 6, Window: time.Minute, Burst: 3}, clk)
+
+	for i := 0; i < 3; i++ {
+		decision := limiter.Take("client")
+		if !decision.Allowed {
+			t.Fatalf("expected burst request %d to pass", i)
+		}
+		if decision.Remaining != 2-i {
+			t.Fatalf("expected remaining %d, got %d", 2-i, decision.Remaining)
+		}
+		if decision.Limit != 3 {
+			t.Fatalf("expected limit 3, got %d", decision.Limit)
+		}
+	}
+	denied := limiter.Take("client")
+	if denied.Allowed {
+		t.Fatalf("expected request beyond burst to be denied")
+	}
+	if denied.RetryAfter != 10*time.Second {
+		t.Fatalf("expected retry after 10s, got %v", denied.RetryAfter)
+	}
+	if denied.ResetAfter != 30*time.Second {
+		t.Fatalf("expected reset after 30s, got %v", denied.ResetAfter)
+	}
+
+	clk.Advance(10 * time.Second)
+	if !limiter.Allow("client") {
+		t.Fatalf("expected one token to refill after emission interval")
+	}
+	if limiter.Allow("client") {
+		t.Fatalf("expected only one token to refill")
+	}
+
+	clk.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("client") {
+			t.Fatalf("expected full burst after idle period, request %d", i)
+		}
+	}
+	if !limiter.Allow("other") {
+		t.Fatalf("expected keys to be independent")
+	}
+}
+
+func TestLimiterDefaultsBurstToLimit(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(2, time.Minute, clk)
+	if !limiter.Allow("client") || !limiter.Allow("client") {
+		t.Fatalf("expected limit requests to pass immediately")
+	}
+	if limiter.Allow("client") {
+		t.Fatalf("expected request beyond limit to be denied")
+	}
+	clk.Advance(30 * time.Second)
+	if !limiter.Allow("client") {
+		t.Fatalf("expected refill after half a window")
+	}
+}
+
+func TestLimiterEvictsIdleKeys(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(10, time.Minute, clk)
+	for _, key := range []string{"a", "b", "c"} {
+		limiter.Allow(key)
+	}
+	if limiter.Len() != 3 {
+		t.Fatalf("expected 3 tracked keys, got %d", limiter.Len())
+	}
+
+	clk.Advance(2 * time.Minute)
+	limiter.Allow("d")
+	if limiter.Len() != 1 {
+		t.Fatalf("expected idle keys to be evicted, got %d", limiter.Len())
+	}
+
+	clk.Advance(time.Minute)
+	if evicted := limiter.Evict(); evicted != 1 {
+		t.Fatalf("expected explicit eviction of 1 key, got %d", evicted)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	limiter := New(0, time.Minute, clock.NewFake(time.Now()))
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow("client") {
+			t.Fatalf("expected disabled limiter to allow")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+	"universaldrop/internal/redis"
+)
+
+const (
+	sharedTimeout   = 500 * time.Millisecond
+	sharedRetries   = 5
+	failClosedRetry = time.Second
+	minSharedTTL    = time.Millisecond
+)
+
+var errContended = errors.New("rate limit key contended")
+
+type SharedLimiter struct {
+	client   *redis.Client
+	prefix   string
+	mu       sync.RWMutex
+	gcra     gcra
+	clock    clock.Clock
+	fallback *Limiter
+	onError  func(error)
+}
+
+func NewShared(client *redis.Client, prefix string, policy Policy, clk clock.Clock, fallback *Limiter, onError func(error)) *SharedLimiter {
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	return &SharedLimiter{
+		client:   client,
+		prefix:   prefix,
+		gcra:     newGCRA(policy),
+		clock:    clk,
+		fallback: fallback,
+		onError:  onError,
+	}
+}
+
+func (s *SharedLimiter) Policy() Policy {
+	return s.current().policy
+}
+
+func (s *SharedLimiter) SetPolicy(policy Policy) {
+	s.mu.Lock()
+	s.gcra = newGCRA(policy)
+	s.mu.Unlock()
+	if s.fallback != nil {
+		s.fallback.SetPolicy(policy)
+	}
+}
+
+func (s *SharedLimiter) current() gcra {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gcra
+}
+
+func (s *SharedLimiter) Take(key string) Decision {
+	g := s.current()
+	if g.disabled() {
+		return Decision{Allowed: true}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedTimeout)
+	defer cancel()
+
+	var err error
+	for attempt := 0; attempt < sharedRetries; attempt++ {
+		var decision Decision
+		decision, err = s.take(ctx, g, s.prefix+key)
+		if err == nil {
+			return decision
+		}
+		if !errors.Is(err, errContended) {
+			break
+		}
+	}
+	if s.onError != nil {
+		s.onError(err)
+	}
+	if s.fallback != nil {
+		return s.fallback.Take(key)
+	}
+	return Decision{Limit: g.policy.Burst, RetryAfter: failClosedRetry, ResetAfter: failClosedRetry}
+}
+
+func (s *SharedLimiter) take(ctx context.Context, g gcra, key string) (Decision, error) {
+	var decision Decision
+	err := s.client.WithConn(ctx, func(conn *redis.Conn) error {
+		if _, err := conn.Do(ctx, "WATCH", key); err != nil {
+			return err
+		}
+		var err error
+		decision, err = s.watched(ctx, conn, g, key)
+		if err != nil {
+			_, _ = conn.Do(ctx, "UNWATCH")
+		}
+		return err
+	})
+	return decision, err
+}
+
+func (s *SharedLimiter) watched(ctx context.Context, conn *redis.Conn, g gcra, key string) (Decision, error) {
+	reply, err := conn.Do(ctx, "GET", key)
+	if err != nil {
+		return Decision{}, err
+	}
+	var tat time.Time
+	if reply != nil {
+		nanos, ok := redis.Int64(reply)
+		if !ok {
+			return Decision{}, redis.ErrProtocol
+		}
+		tat = time.Unix(0, nanos)
+	}
+	now := s.clock.Now()
+	next, current := g.take(tat, now)
+	if !current.Allowed {
+		_, err := conn.Do(ctx, "UNWATCH")
+		return current, err
+	}
+	ttl := next.Sub(now)
+	if ttl < minSharedTTL {
+		ttl = minSharedTTL
+	}
+	if _, err := conn.Do(ctx, "MULTI"); err != nil {
+		return Decision{}, err
+	}
+	if _, err := conn.Do(ctx, "SET", key, strconv.FormatInt(next.UnixNano(), 10), "PX", strconv.FormatInt(ttl.Milliseconds()+1, 10)); err != nil {
+		_, _ = conn.Do(ctx, "DISCARD")
+		return Decision{}, err
+	}
+	exec, err := conn.Do(ctx, "EXEC")
+	if err != nil {
+		return Decision{}, err
+	}
+	if exec == nil {
+		return Decision{}, errContended
+	}
+	return current, nil
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"universaldrop/internal/clock"
+	"universaldrop/internal/redis"
+	"universaldrop/internal/redis/redistest"
+)
+
+func TestSharedLimiterEnforcesAcrossReplicas(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, err := redistest.NewServer(clk)
+	if err != nil {
+		t.Fatalf("redis stand-in: %v", err)
+	}
+	defer server.Close()
+	policy := Policy{Limit: 2, Window: time.Minute}
+	first := NewShared(redis.NewClient(redis.Options{Addr: server.Addr()}), "rl:", policy, clk, nil, nil)
+	second := NewShared(redis.NewClient(redis.Options{Addr: server.Addr()}), "rl:", policy, clk, nil, nil)
+
+	if !first.Take("client").Allowed || !second.Take("client").Allowed {
+		t.Fatalf("expected burst to be shared and allowed")
+	}
+	denied := first.Take("client")
+	if denied.Allowed {
+		t.Fatalf("expected third request across replicas to be denied")
+	}
+	if denied.RetryAfter != 30*time.Second {
+		t.Fatalf("expected retry after 30s, got %s", denied.RetryAfter)
+	}
+	if !second.Take("other").Allowed {
+		t.Fatalf("expected other keys to be independent")
+	}
+
+	clk.Advance(30 * time.Second)
+	if !second.Take("client").Allowed {
+		t.Fatalf("expected token to refill after emission interval")
+	}
+}
+
+func TestSharedLimiterFailureModes(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, err := redistest.NewServer(clk)
+	if err != nil {
+		t.Fatalf("redis stand-in: %v", err)
+	}
+	policy := Policy{Limit: 1, Window: time.Minute}
+	failures := 0
+	closed := NewShared(redis.NewClient(redis.Options{Addr: server.Addr()}), "rl:", policy, clk, nil, func(error) { failures++ })
+	local := NewShared(redis.NewClient(redis.Options{Addr: server.Addr()}), "rl:", policy, clk, NewWithPolicy(policy, clk), nil)
+	server.Close()
+
+	decision := closed.Take("client")
+	if decision.Allowed || decision.RetryAfter <= 0 {
+		t.Fatalf("expected fail-closed limiter to deny, got %+v", decision)
+	}
+	if failures != 1 {
+		t.Fatalf("expected backend error to be reported once, got %d", failures)
+	}
+	if !local.Take("client").Allowed {
+		t.Fatalf("expected local fallback to allow first request")
+	}
+	if local.Take("client").Allowed {
+		t.Fatalf("expected local fallback to enforce its own limit")
+	}
+}
+
+func TestSharedLimiterReleasesWatchOnErrors(t *testing.T) {
+	server, err := redistest.NewServer(nil)
+	if err != nil {
+		t.Fatalf("redis stand-in: %v", err)
+	}
+	defer server.Close()
+	ctx := context.Background()
+	client := redis.NewClient(redis.Options{Addr: server.Addr(), MaxIdle: 1})
+	defer client.Close()
+	other := redis.NewClient(redis.Options{Addr: server.Addr()})
+	defer other.Close()
+	limiter := NewShared(client, "rl:", Policy{Limit: 1, Window: time.Minute}, nil, nil, nil)
+
+	if _, err := other.Do(ctx, "SET", "rl:client", "not-a-number"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if limiter.Take("client").Allowed {
+		t.Fatalf("expected malformed shared state to fail closed")
+	}
+	if _, err := other.Do(ctx, "SET", "rl:client", "0"); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+
+	var exec any
+	err = client.WithConn(ctx, func(conn *redis.Conn) error {
+		if _, err := conn.Do(ctx, "MULTI"); err != nil {
+			return err
+		}
+		if _, err := conn.Do(ctx, "SET", "unrelated", "1"); err != nil {
+			return err
+		}
+		var err error
+		exec, err = conn.Do(ctx, "EXEC")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	if exec == nil {
+		t.Fatalf("expected pooled connection to carry no stale WATCH")
+	}
+}
@@ -0,0 +1,309 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDialTimeout = 2 * time.Second
+	DefaultTimeout     = time.Second
+	DefaultMaxIdle     = 8
+)
+
+var ErrClosed = errors.New("redis client closed")
+var ErrProtocol = errors.New("redis protocol error")
+
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+type Options struct {
+	Addr        string
+	Password    string
+	DB          int
+	DialTimeout time.Duration
+	Timeout     time.Duration
+	MaxIdle     int
+}
+
+func ParseURL(raw string) (Options, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Options{}, err
+	}
+	if parsed.Scheme != "redis" {
+		return Options{}, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return Options{}, errors.New("redis address required")
+	}
+	opts := Options{Addr: parsed.Host}
+	if _, _, err := net.SplitHostPort(opts.Addr); err != nil {
+		opts.Addr = net.JoinHostPort(opts.Addr, "6379")
+	}
+	if parsed.User != nil {
+		if password, ok := parsed.User.Password(); ok {
+			opts.Password = password
+		}
+	}
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		value, err := strconv.Atoi(db)
+		if err != nil || value < 0 {
+			return Options{}, fmt.Errorf("invalid redis db %q", db)
+		}
+		opts.DB = value
+	}
+	return opts, nil
+}
+
+type Client struct {
+	opts Options
+
+	mu     sync.Mutex
+	idle   []*Conn
+	closed bool
+}
+
+func NewClient(opts Options) *Client {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = DefaultMaxIdle
+	}
+	return &Client{opts: opts}
+}
+
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	var reply any
+	err := c.WithConn(ctx, func(conn *Conn) error {
+		var err error
+		reply, err = conn.Do(ctx, args...)
+		return err
+	})
+	return reply, err
+}
+
+func (c *Client) WithConn(ctx context.Context, fn func(conn *Conn) error) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(conn)
+	c.put(conn)
+	return err
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, conn := range c.idle {
+		_ = conn.netConn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) get(ctx context.Context) (*Conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+		writer:  bufio.NewWriter(netConn),
+		timeout: c.opts.Timeout,
+	}
+	if c.opts.Password != "" {
+		if _, err := conn.Do(ctx, "AUTH", c.opts.Password); err != nil {
+			_ = netConn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB > 0 {
+		if _, err := conn.Do(ctx, "SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			_ = netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *Client) put(conn *Conn) {
+	if conn.broken {
+		_ = conn.netConn.Close()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= c.opts.MaxIdle {
+		_ = conn.netConn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+type Conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	timeout time.Duration
+	broken  bool
+}
+
+func (c *Conn) Do(ctx context.Context, args ...string) (any, error) {
+	if c.broken {
+		return nil, ErrClosed
+	}
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = c.netConn.SetDeadline(deadline)
+
+	if err := writeCommand(c.writer, args); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	reply, err := readReply(c.reader)
+	if err != nil {
+		var redisErr Error
+		if !errors.As(err, &redisErr) {
+			c.broken = true
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		value, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		return value, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				var redisErr Error
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				items = append(items, redisErr)
+				continue
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, ErrProtocol
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", ErrProtocol
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func Int64(reply any) (int64, bool) {
+	switch value := reply.(type) {
+	case int64:
+		return value, true
+	case string:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return parsed, true
+	default:
+		return 0, false
+	}
+}
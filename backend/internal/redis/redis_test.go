@@ -0,0 +1,70 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"universaldrop/internal/redis/redistest"
+)
+
+func TestParseURL(t *testing.T) {
+	opts, err := ParseURL("redis://:hunter2@cache.internal/3")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if opts.Addr != "cache.internal:6379" || opts.Password != "hunter2" || opts.DB != 3 {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	for _, raw := range []string{"http://cache", "redis://", "redis://cache/db"} {
+		if _, err := ParseURL(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestClientAgainstStandIn(t *testing.T) {
+	server, err := redistest.NewServerWithPassword(nil, "secret")
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	defer server.Close()
+	ctx := context.Background()
+
+	unauthenticated := NewClient(Options{Addr: server.Addr()})
+	defer unauthenticated.Close()
+	if _, err := unauthenticated.Do(ctx, "GET", "k"); err == nil {
+		t.Fatalf("expected unauthenticated command to fail")
+	}
+
+	opts, err := ParseURL(server.URL())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	client := NewClient(opts)
+	defer client.Close()
+
+	if reply, err := client.Do(ctx, "PING"); err != nil || reply != "PONG" {
+		t.Fatalf("expected PONG, got %v err=%v", reply, err)
+	}
+	if reply, err := client.Do(ctx, "GET", "missing"); err != nil || reply != nil {
+		t.Fatalf("expected nil reply, got %v err=%v", reply, err)
+	}
+	if reply, err := client.Do(ctx, "INCRBY", "counter", "5"); err != nil {
+		t.Fatalf("incrby: %v", err)
+	} else if value, ok := Int64(reply); !ok || value != 5 {
+		t.Fatalf("expected 5, got %v", reply)
+	}
+	var redisErr Error
+	if _, err := client.Do(ctx, "NOPE"); !errors.As(err, &redisErr) {
+		t.Fatalf("expected server error, got %v", err)
+	}
+	if reply, err := client.Do(ctx, "GET", "counter"); err != nil || reply != "5" {
+		t.Fatalf("expected connection to stay usable after server error, got %v err=%v", reply, err)
+	}
+
+	server.Close()
+	if _, err := client.Do(ctx, "PING"); err == nil {
+		t.Fatalf("expected error after server shutdown")
+	}
+}
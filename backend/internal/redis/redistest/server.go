@@ -0,0 +1,532 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"universaldrop/internal/clock"
+)
+
+type entry struct {
+	value     string
+	hash      map[string]string
+	expiresAt time.Time
+	version   uint64
+}
+
+type ScriptFunc func(call func(args ...string) any, keys []string, args []string) any
+
+type Server struct {
+	listener net.Listener
+	clock    clock.Clock
+	password string
+
+	mu      sync.Mutex
+	data    map[string]*entry
+	scripts map[string]ScriptFunc
+	version uint64
+	conns   map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewServer(clk clock.Clock) (*Server, error) {
+	return NewServerWithPassword(clk, "")
+}
+
+func NewServerWithPassword(clk clock.Clock, password string) (*Server, error) {
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		clock:    clk,
+		password: password,
+		data:     map[string]*entry{},
+		scripts:  map[string]ScriptFunc{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) URL() string {
+	if s.password != "" {
+		return "redis://:" + s.password + "@" + s.Addr()
+	}
+	return "redis://" + s.Addr()
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) Script(body string, fn ScriptFunc) {
+	s.mu.Lock()
+	s.scripts[body] = fn
+	s.mu.Unlock()
+}
+
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if s.liveLocked(key, now) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+type session struct {
+	authed  bool
+	watched map[string]uint64
+	queued  [][]string
+	inMulti bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	state := &session{authed: s.password == ""}
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		reply := s.dispatch(state, args)
+		writeReply(writer, reply)
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+type errorReply string
+
+func (s *Server) dispatch(state *session, args []string) any {
+	if len(args) == 0 {
+		return errorReply("ERR empty command")
+	}
+	name := strings.ToUpper(args[0])
+	if name == "AUTH" {
+		if len(args) != 2 || args[1] != s.password {
+			return errorReply("WRONGPASS invalid password")
+		}
+		state.authed = true
+		return "OK"
+	}
+	if !state.authed {
+		return errorReply("NOAUTH Authentication required")
+	}
+	switch name {
+	case "MULTI":
+		state.inMulti = true
+		state.queued = nil
+		return "OK"
+	case "DISCARD":
+		state.inMulti = false
+		state.queued = nil
+		state.watched = nil
+		return "OK"
+	case "WATCH":
+		s.mu.Lock()
+		if state.watched == nil {
+			state.watched = map[string]uint64{}
+		}
+		for _, key := range args[1:] {
+			state.watched[key] = s.versionLocked(key)
+		}
+		s.mu.Unlock()
+		return "OK"
+	case "UNWATCH":
+		state.watched = nil
+		return "OK"
+	case "EXEC":
+		if !state.inMulti {
+			return errorReply("ERR EXEC without MULTI")
+		}
+		queued := state.queued
+		watched := state.watched
+		state.inMulti = false
+		state.queued = nil
+		state.watched = nil
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, version := range watched {
+			if s.versionLocked(key) != version {
+				return nilArray{}
+			}
+		}
+		replies := make([]any, 0, len(queued))
+		for _, cmd := range queued {
+			replies = append(replies, s.execLocked(cmd))
+		}
+		return replies
+	}
+	if state.inMulti {
+		state.queued = append(state.queued, args)
+		return "QUEUED"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.execLocked(args)
+}
+
+type nilArray struct{}
+
+func (s *Server) execLocked(args []string) any {
+	now := s.clock.Now()
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "EVAL":
+		if len(args) < 3 {
+			return errorReply("ERR wrong number of arguments")
+		}
+		fn, ok := s.scripts[args[1]]
+		if !ok {
+			return errorReply("ERR script not registered with the stand-in")
+		}
+		numKeys, err := strconv.Atoi(args[2])
+		if err != nil || numKeys < 0 || 3+numKeys > len(args) {
+			return errorReply("ERR Number of keys can't be greater than number of args")
+		}
+		call := func(cmd ...string) any { return s.execLocked(cmd) }
+		return fn(call, args[3:3+numKeys], args[3+numKeys:])
+	case "PING":
+		return "PONG"
+	case "SELECT":
+		return "OK"
+	case "TIME":
+		return []any{[]byte(strconv.FormatInt(now.Unix(), 10)), []byte(strconv.FormatInt(int64(now.Nanosecond()/1000), 10))}
+	case "GET":
+		if len(args) != 2 {
+			return errorReply("ERR wrong number of arguments")
+		}
+		item := s.liveLocked(args[1], now)
+		if item == nil {
+			return nil
+		}
+		if item.hash != nil {
+			return errorReply("WRONGTYPE")
+		}
+		return []byte(item.value)
+	case "SET":
+		if len(args) < 3 {
+			return errorReply("ERR wrong number of arguments")
+		}
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX", "EX":
+				if i+1 >= len(args) {
+					return errorReply("ERR syntax error")
+				}
+				value, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || value <= 0 {
+					return errorReply("ERR invalid expire time")
+				}
+				if strings.ToUpper(args[i]) == "PX" {
+					ttl = time.Duration(value) * time.Millisecond
+				} else {
+					ttl = time.Duration(value) * time.Second
+				}
+				i++
+			default:
+				return errorReply("ERR syntax error")
+			}
+		}
+		if nx && s.liveLocked(args[1], now) != nil {
+			return nil
+		}
+		item := &entry{value: args[2]}
+		if ttl > 0 {
+			item.expiresAt = now.Add(ttl)
+		}
+		s.storeLocked(args[1], item)
+		return "OK"
+	case "DEL":
+		removed := int64(0)
+		for _, key := range args[1:] {
+			if s.liveLocked(key, now) != nil {
+				s.deleteLocked(key)
+				removed++
+			}
+		}
+		return removed
+	case "INCR", "INCRBY", "DECRBY":
+		delta := int64(1)
+		if name != "INCR" {
+			if len(args) != 3 {
+				return errorReply("ERR wrong number of arguments")
+			}
+			value, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return errorReply("ERR value is not an integer or out of range")
+			}
+			delta = value
+			if name == "DECRBY" {
+				delta = -value
+			}
+		}
+		item := s.liveLocked(args[1], now)
+		current := int64(0)
+		expiresAt := time.Time{}
+		if item != nil {
+			if item.hash != nil {
+				return errorReply("WRONGTYPE")
+			}
+			value, err := strconv.ParseInt(item.value, 10, 64)
+			if err != nil {
+				return errorReply("ERR value is not an integer or out of range")
+			}
+			current = value
+			expiresAt = item.expiresAt
+		}
+		current += delta
+		s.storeLocked(args[1], &entry{value: strconv.FormatInt(current, 10), expiresAt: expiresAt})
+		return current
+	case "PEXPIRE":
+		if len(args) != 3 {
+			return errorReply("ERR wrong number of arguments")
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errorReply("ERR value is not an integer or out of range")
+		}
+		item := s.liveLocked(args[1], now)
+		if item == nil {
+			return int64(0)
+		}
+		item.expiresAt = now.Add(time.Duration(ms) * time.Millisecond)
+		s.storeLocked(args[1], item)
+		return int64(1)
+	case "PTTL":
+		item := s.liveLocked(args[1], now)
+		if item == nil {
+			return int64(-2)
+		}
+		if item.expiresAt.IsZero() {
+			return int64(-1)
+		}
+		return item.expiresAt.Sub(now).Milliseconds()
+	case "HSET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return errorReply("ERR wrong number of arguments")
+		}
+		item := s.liveLocked(args[1], now)
+		if item == nil {
+			item = &entry{hash: map[string]string{}}
+		} else if item.hash == nil {
+			return errorReply("WRONGTYPE")
+		}
+		added := int64(0)
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := item.hash[args[i]]; !ok {
+				added++
+			}
+			item.hash[args[i]] = args[i+1]
+		}
+		s.storeLocked(args[1], item)
+		return added
+	case "HGET":
+		if len(args) != 3 {
+			return errorReply("ERR wrong number of arguments")
+		}
+		item := s.liveLocked(args[1], now)
+		if item == nil || item.hash == nil {
+			return nil
+		}
+		value, ok := item.hash[args[2]]
+		if !ok {
+			return nil
+		}
+		return []byte(value)
+	case "HDEL":
+		item := s.liveLocked(args[1], now)
+		if item == nil || item.hash == nil {
+			return int64(0)
+		}
+		removed := int64(0)
+		for _, field := range args[2:] {
+			if _, ok := item.hash[field]; ok {
+				delete(item.hash, field)
+				removed++
+			}
+		}
+		if len(item.hash) == 0 {
+			s.deleteLocked(args[1])
+		} else {
+			s.storeLocked(args[1], item)
+		}
+		return removed
+	case "HGETALL":
+		item := s.liveLocked(args[1], now)
+		if item == nil || item.hash == nil {
+			return []any{}
+		}
+		fields := make([]string, 0, len(item.hash))
+		for field := range item.hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		replies := make([]any, 0, len(fields)*2)
+		for _, field := range fields {
+			replies = append(replies, []byte(field), []byte(item.hash[field]))
+		}
+		return replies
+	default:
+		return errorReply(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+func (s *Server) liveLocked(key string, now time.Time) *entry {
+	item, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !item.expiresAt.IsZero() && !now.Before(item.expiresAt) {
+		s.deleteLocked(key)
+		return nil
+	}
+	return item
+}
+
+func (s *Server) versionLocked(key string) uint64 {
+	item := s.liveLocked(key, s.clock.Now())
+	if item == nil {
+		return 0
+	}
+	return item.version
+}
+
+func (s *Server) storeLocked(key string, item *entry) {
+	s.version++
+	item.version = s.version
+	s.data[key] = item
+}
+
+func (s *Server) deleteLocked(key string) {
+	s.version++
+	delete(s.data, key)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSuffix(header, "\r\n")
+		if !strings.HasPrefix(header, "$") {
+			return nil, io.ErrUnexpectedEOF
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch value := reply.(type) {
+	case nil:
+		_, _ = w.WriteString("$-1\r\n")
+	case nilArray:
+		_, _ = w.WriteString("*-1\r\n")
+	case string:
+		_, _ = w.WriteString("+" + value + "\r\n")
+	case errorReply:
+		_, _ = w.WriteString("-" + string(value) + "\r\n")
+	case int64:
+		_, _ = w.WriteString(":" + strconv.FormatInt(value, 10) + "\r\n")
+	case []byte:
+		_, _ = w.WriteString("$" + strconv.Itoa(len(value)) + "\r\n")
+		_, _ = w.Write(value)
+		_, _ = w.WriteString("\r\n")
+	case []any:
+		_, _ = w.WriteString("*" + strconv.Itoa(len(value)) + "\r\n")
+		for _, item := range value {
+			writeReply(w, item)
+		}
+	}
+}
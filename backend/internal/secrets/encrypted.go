@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedFileVersion = 1
+	encryptedFileKDF     = "scrypt"
+	scryptN              = 1 << 15
+	scryptR              = 8
+	scryptP              = 1
+	scryptSaltBytes      = 16
+)
+
+var ErrDecrypt = errors.New("secret decryption failed")
+
+type encryptedFile struct {
+	V          int    `json:"v"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type EncryptedFileProvider struct {
+	Path       string
+	Passphrase SecretProvider
+	MinBytes   int
+}
+
+func (p EncryptedFileProvider) Load(ctx context.Context) ([]byte, error) {
+	if p.Passphrase == nil {
+		return nil, errors.New("passphrase provider required")
+	}
+	passphrase, err := p.Passphrase.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load passphrase: %w", err)
+	}
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	secret, err := OpenEncrypted(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return checkLength(secret, p.MinBytes)
+}
+
+func SealEncrypted(secret []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase required")
+	}
+	salt := make([]byte, scryptSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := encryptedFile{
+		V:     encryptedFileVersion,
+		KDF:   encryptedFileKDF,
+		N:     scryptN,
+		R:     scryptR,
+		P:     scryptP,
+		Salt:  base64.RawURLEncoding.EncodeToString(salt),
+		Nonce: base64.RawURLEncoding.EncodeToString(nonce),
+	}
+	ciphertext := aead.Seal(nil, nonce, secret, encryptedFileAAD(header))
+	header.Ciphertext = base64.RawURLEncoding.EncodeToString(ciphertext)
+	return json.MarshalIndent(header, "", "  ")
+}
+
+func OpenEncrypted(data []byte, passphrase []byte) ([]byte, error) {
+	var header encryptedFile
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, ErrDecrypt
+	}
+	if header.V != encryptedFileVersion || header.KDF != encryptedFileKDF {
+		return nil, ErrDecrypt
+	}
+	salt, err := base64.RawURLEncoding.DecodeString(header.Salt)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(header.Nonce)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(header.Ciphertext)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	key, err := scrypt.Key(passphrase, salt, header.N, header.R, header.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	secret, err := aead.Open(nil, nonce, ciphertext, encryptedFileAAD(header))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return secret, nil
+}
+
+func WriteEncryptedFile(path string, secret []byte, passphrase []byte) error {
+	data, err := SealEncrypted(secret, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func encryptedFileAAD(header encryptedFile) []byte {
+	return []byte(fmt.Sprintf("v=%d;kdf=%s;n=%d;r=%d;p=%d;salt=%s", header.V, header.KDF, header.N, header.R, header.P, header.Salt))
+}
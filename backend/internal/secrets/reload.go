@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+type Reloader struct {
+	provider SecretProvider
+
+	mu        sync.RWMutex
+	current   []byte
+	listeners []func([]byte)
+}
+
+func NewReloader(ctx context.Context, provider SecretProvider) (*Reloader, error) {
+	secret, err := provider.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Reloader{
+		provider: provider,
+		current:  append([]byte(nil), secret...),
+	}, nil
+}
+
+func (r *Reloader) Current() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]byte(nil), r.current...)
+}
+
+func (r *Reloader) OnChange(fn func([]byte)) {
+	if fn == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+func (r *Reloader) Reload(ctx context.Context) (bool, error) {
+	secret, err := r.provider.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	if bytes.Equal(secret, r.current) {
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.current = append([]byte(nil), secret...)
+	listeners := append([]func([]byte){}, r.listeners...)
+	r.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(append([]byte(nil), secret...))
+	}
+	return true, nil
+}
@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderEnv           = "env"
+	ProviderFile          = "file"
+	ProviderEncryptedFile = "encrypted_file"
+	ProviderCommand       = "command"
+
+	DefaultPassphraseEnv  = "UD_SECRET_PASSPHRASE"
+	DefaultCommandTimeout = 10 * time.Second
+)
+
+var ErrNotFound = errors.New("secret not found")
+var ErrTooShort = errors.New("secret too short")
+
+type SecretProvider interface {
+	Load(ctx context.Context) ([]byte, error)
+}
+
+type Spec struct {
+	Provider      string
+	Env           string
+	Path          string
+	PassphraseEnv string
+	Command       []string
+	MinBytes      int
+	CreateBytes   int
+}
+
+func NewProvider(spec Spec) (SecretProvider, error) {
+	switch spec.Provider {
+	case ProviderEnv:
+		if spec.Env == "" {
+			return nil, errors.New("env secret provider requires a variable name")
+		}
+		return EnvProvider{Key: spec.Env, MinBytes: spec.MinBytes}, nil
+	case ProviderFile:
+		if spec.Path == "" {
+			return nil, errors.New("file secret provider requires a path")
+		}
+		return FileProvider{Path: spec.Path, MinBytes: spec.MinBytes, CreateBytes: spec.CreateBytes}, nil
+	case ProviderEncryptedFile:
+		if spec.Path == "" {
+			return nil, errors.New("encrypted file secret provider requires a path")
+		}
+		passphraseEnv := spec.PassphraseEnv
+		if passphraseEnv == "" {
+			passphraseEnv = DefaultPassphraseEnv
+		}
+		return EncryptedFileProvider{
+			Path:       spec.Path,
+			Passphrase: PassphraseEnvProvider{Key: passphraseEnv},
+			MinBytes:   spec.MinBytes,
+		}, nil
+	case ProviderCommand:
+		if len(spec.Command) == 0 {
+			return nil, errors.New("command secret provider requires a command")
+		}
+		return CommandProvider{Command: spec.Command, MinBytes: spec.MinBytes}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q", spec.Provider)
+	}
+}
+
+type EnvProvider struct {
+	Key      string
+	MinBytes int
+}
+
+func (p EnvProvider) Load(_ context.Context) ([]byte, error) {
+	raw := strings.TrimSpace(os.Getenv(p.Key))
+	if raw == "" {
+		return nil, ErrNotFound
+	}
+	secret, err := DecodeBase64(raw)
+	if err != nil {
+		return nil, err
+	}
+	return checkLength(secret, p.MinBytes)
+}
+
+type PassphraseEnvProvider struct {
+	Key string
+}
+
+func (p PassphraseEnvProvider) Load(_ context.Context) ([]byte, error) {
+	value := os.Getenv(p.Key)
+	if value == "" {
+		return nil, ErrNotFound
+	}
+	return []byte(value), nil
+}
+
+type FileProvider struct {
+	Path        string
+	MinBytes    int
+	CreateBytes int
+}
+
+func (p FileProvider) Load(_ context.Context) ([]byte, error) {
+	secret, err := os.ReadFile(p.Path)
+	if err == nil {
+		return checkLength(secret, p.MinBytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if p.CreateBytes <= 0 {
+		return nil, ErrNotFound
+	}
+	secret = make([]byte, p.CreateBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(p.Path, secret, 0o600); err != nil {
+		return nil, err
+	}
+	return checkLength(secret, p.MinBytes)
+}
+
+type CommandProvider struct {
+	Command  []string
+	Timeout  time.Duration
+	MinBytes int
+}
+
+func (p CommandProvider) Load(ctx context.Context) ([]byte, error) {
+	if len(p.Command) == 0 {
+		return nil, errors.New("secret command not configured")
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("secret command failed: %w", err)
+	}
+	raw := strings.TrimSpace(stdout.String())
+	if raw == "" {
+		return nil, ErrNotFound
+	}
+	secret, err := DecodeBase64(raw)
+	if err != nil {
+		return nil, err
+	}
+	return checkLength(secret, p.MinBytes)
+}
+
+func DecodeBase64(raw string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err == nil {
+		return decoded, nil
+	}
+	return base64.StdEncoding.DecodeString(raw)
+}
+
+func checkLength(secret []byte, minBytes int) ([]byte, error) {
+	if minBytes > 0 && len(secret) < minBytes {
+		return nil, fmt.Errorf("%w: need at least %d bytes", ErrTooShort, minBytes)
+	}
+	return secret, nil
+}
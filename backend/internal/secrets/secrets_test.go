@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvProviderDecodesBase64(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x41}, 32)
+	t.Setenv("UD_TEST_SECRET", base64.RawURLEncoding.EncodeToString(secret))
+
+	got, err := EnvProvider{Key: "UD_TEST_SECRET", MinBytes: 32}.Load(context.Background())
+	if err != nil {
+		t.Fatalf("load env secret: %v", err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Fatalf("unexpected env secret")
+	}
+
+	t.Setenv("UD_TEST_SECRET", base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := (EnvProvider{Key: "UD_TEST_SECRET", MinBytes: 32}).Load(context.Background()); !errors.Is(err, ErrTooShort) {
+		t.Fatalf("expected short secret to be rejected, got %v", err)
+	}
+}
+
+func TestFileProviderCreatesSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", "key")
+	provider := FileProvider{Path: path, MinBytes: 32, CreateBytes: 32}
+
+	first, err := provider.Load(context.Background())
+	if err != nil {
+		t.Fatalf("load file secret: %v", err)
+	}
+	if len(first) != 32 {
+		t.Fatalf("expected 32 byte secret, got %d", len(first))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat secret file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected 0600 permissions, got %v", info.Mode().Perm())
+	}
+	second, err := provider.Load(context.Background())
+	if err != nil {
+		t.Fatalf("reload file secret: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatalf("expected file secret to persist")
+	}
+
+	missing := FileProvider{Path: filepath.Join(t.TempDir(), "missing")}
+	if _, err := missing.Load(context.Background()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected missing file to be not found, got %v", err)
+	}
+}
+
+func TestEncryptedFileProviderRoundTrip(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	path := filepath.Join(t.TempDir(), "token.enc")
+	if err := WriteEncryptedFile(path, secret, []byte("correct horse")); err != nil {
+		t.Fatalf("write encrypted file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read encrypted file: %v", err)
+	}
+	if bytes.Contains(data, secret) {
+		t.Fatalf("expected secret to be encrypted at rest")
+	}
+
+	t.Setenv("UD_TEST_PASSPHRASE", "correct horse")
+	provider, err := NewProvider(Spec{Provider: ProviderEncryptedFile, Path: path, PassphraseEnv: "UD_TEST_PASSPHRASE", MinBytes: 32})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	got, err := provider.Load(context.Background())
+	if err != nil {
+		t.Fatalf("load encrypted secret: %v", err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Fatalf("unexpected decrypted secret")
+	}
+
+	t.Setenv("UD_TEST_PASSPHRASE", "wrong horse")
+	if _, err := provider.Load(context.Background()); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected wrong passphrase to fail, got %v", err)
+	}
+}
+
+func TestCommandProviderReadsStdout(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x43}, 32)
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	provider, err := NewProvider(Spec{Provider: ProviderCommand, Command: []string{"sh", "-c", "echo " + encoded}, MinBytes: 32})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	got, err := provider.Load(context.Background())
+	if err != nil {
+		t.Fatalf("load command secret: %v", err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Fatalf("unexpected command secret")
+	}
+
+	failing := CommandProvider{Command: []string{"sh", "-c", "exit 3"}}
+	if _, err := failing.Load(context.Background()); err == nil {
+		t.Fatalf("expected failing command to error")
+	}
+}
+
+func TestNewProviderRejectsUnknown(t *testing.T) {
+	if _, err := NewProvider(Spec{Provider: "vault"}); err == nil {
+		t.Fatalf("expected unknown provider to error")
+	}
+	if _, err := NewProvider(Spec{Provider: ProviderFile}); err == nil {
+		t.Fatalf("expected file provider without path to error")
+	}
+}
+
+func TestReloaderNotifiesOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	first := bytes.Repeat([]byte{0x01}, 32)
+	if err := os.WriteFile(path, first, 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	reloader, err := NewReloader(context.Background(), FileProvider{Path: path})
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+	var notified [][]byte
+	reloader.OnChange(func(secret []byte) {
+		notified = append(notified, secret)
+	})
+
+	changed, err := reloader.Reload(context.Background())
+	if err != nil || changed {
+		t.Fatalf("expected unchanged reload, got changed=%v err=%v", changed, err)
+	}
+
+	second := bytes.Repeat([]byte{0x02}, 32)
+	if err := os.WriteFile(path, second, 0o600); err != nil {
+		t.Fatalf("rewrite secret: %v", err)
+	}
+	changed, err = reloader.Reload(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("expected changed reload, got changed=%v err=%v", changed, err)
+	}
+	if len(notified) != 1 || !bytes.Equal(notified[0], second) {
+		t.Fatalf("expected listener to receive new secret")
+	}
+	if !bytes.Equal(reloader.Current(), second) {
+		t.Fatalf("expected current secret to update")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove secret: %v", err)
+	}
+	if _, err := reloader.Reload(context.Background()); err == nil {
+		t.Fatalf("expected reload failure when secret is missing")
+	}
+	if !bytes.Equal(reloader.Current(), second) {
+		t.Fatalf("expected failed reload to keep current secret")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package localfs
+
+import (
+	"context"
+
+	"universaldrop/internal/storage"
+)
+
+func (s *Store) Capacity(_ context.Context) (storage.Capacity, error) {
+	return storage.Capacity{}, storage.ErrCapacityUnsupported
+}
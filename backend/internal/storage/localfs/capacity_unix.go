@@ -0,0 +1,24 @@
+//go:build unix
+
+package localfs
+
+import (
+	"context"
+	"syscall"
+
+	"universaldrop/internal/storage"
+)
+
+func (s *Store) Capacity(_ context.Context) (storage.Capacity, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.root, &st); err != nil {
+		return storage.Capacity{}, err
+	}
+	blockSize := uint64(st.Bsize)
+	return storage.Capacity{
+		TotalBytes:  st.Blocks * blockSize,
+		FreeBytes:   st.Bavail * blockSize,
+		TotalInodes: st.Files,
+		FreeInodes:  st.Ffree,
+	}, nil
+}
@@ -0,0 +1,107 @@
+package localfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	EraseOverwrite   = "overwrite"
+	EraseCryptoShred = "crypto_shred"
+
+	eraseBufferSize = 64 << 10
+)
+
+func (s *Store) SetEraseObserver(fn func(method string, bytes int64)) {
+	s.onErase = fn
+}
+
+func (s *Store) remove(path string) error {
+	if s.secureDelete {
+		if err := s.overwrite(path); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+func (s *Store) removeAll(dir string) error {
+	if !s.secureDelete {
+		return os.RemoveAll(dir)
+	}
+	keyPath := filepath.Join(dir, "key.bin")
+	var keyErr error
+	_, err := os.Stat(keyPath)
+	sealed := err == nil
+	if sealed {
+		keyErr = s.overwrite(keyPath)
+	}
+	shred := sealed && keyErr == nil
+	walkErr := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || (sealed && path == keyPath) {
+			return nil
+		}
+		if shred && entry.Name() == "data.bin" {
+			if info, err := entry.Info(); err == nil {
+				s.erased(EraseCryptoShred, info.Size())
+			}
+			return nil
+		}
+		return s.overwrite(path)
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if keyErr != nil {
+		return keyErr
+	}
+	return walkErr
+}
+
+func (s *Store) overwrite(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	zeros := make([]byte, eraseBufferSize)
+	var written int64
+	for written < info.Size() {
+		n := int64(len(zeros))
+		if remaining := info.Size() - written; remaining < n {
+			n = remaining
+		}
+		if _, err := file.WriteAt(zeros[:n], written); err != nil {
+			_ = file.Close()
+			return err
+		}
+		written += n
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.erased(EraseOverwrite, written)
+	return nil
+}
+
+func (s *Store) erased(method string, bytes int64) {
+	if s.onErase != nil && bytes > 0 {
+		s.onErase(method, bytes)
+	}
+}
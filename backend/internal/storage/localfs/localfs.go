@@ -3,6 +3,7 @@ package localfs
 import (
 	"context"
 	"encoding/json"
+	"hash/fnv"
 	"io"
 	"os"
 	"path/filepath"
@@ -12,20 +13,47 @@ import (
 	"sync"
 	"time"
 
+	"universaldrop/internal/atrest"
 	"universaldrop/internal/domain"
 	"universaldrop/internal/storage"
 )
 
+const lockShards = 64
+
+type shardedLocks struct {
+	shards [lockShards]sync.RWMutex
+}
+
+func (l *shardedLocks) get(key string) *sync.RWMutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &l.shards[h.Sum32()%lockShards]
+}
+
 type Store struct {
-	mu           sync.Mutex
-	root         string
-	transfersDir string
-	sessionsDir  string
-	authDir      string
-	scansDir     string
+	transferLocks shardedLocks
+	sessionLocks  shardedLocks
+	scanLocks     shardedLocks
+	root          string
+	transfersDir  string
+	sessionsDir   string
+	authDir       string
+	scansDir      string
+	keys          *atrest.Keyring
+	secureDelete  bool
+	onErase       func(method string, bytes int64)
+}
+
+type Options struct {
+	Keyring      *atrest.Keyring
+	SecureDelete bool
 }
 
 func New(root string) (*Store, error) {
+	return NewWithOptions(root, Options{})
+}
+
+func NewWithOptions(root string, opts Options) (*Store, error) {
 	if root == "" {
 		root = "data"
 	}
@@ -55,30 +83,32 @@ func New(root string) (*Store, error) {
 		sessionsDir:  sessionsDir,
 		authDir:      authDir,
 		scansDir:     scansDir,
+		keys:         opts.Keyring,
+		secureDelete: opts.SecureDelete,
 	}, nil
 }
 
 func (s *Store) HealthCheck(_ context.Context) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
 	_, err := os.Stat(s.root)
 	return err
 }
 
 func (s *Store) SaveManifest(_ context.Context, transferID string, manifest []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.manifestPath(transferID)
-	return writeFileAtomic(path, manifest, 0600)
+	return s.writeFile(path, manifest)
 }
 
 func (s *Store) LoadManifest(_ context.Context, transferID string) ([]byte, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	path := s.manifestPath(transferID)
-	data, err := os.ReadFile(path)
+	data, err := s.readFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, storage.ErrNotFound
@@ -89,19 +119,21 @@ func (s *Store) LoadManifest(_ context.Context, transferID string) ([]byte, erro
 }
 
 func (s *Store) SaveTransferMeta(_ context.Context, transferID string, meta domain.TransferMeta) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.transferMetaPath(transferID)
-	return writeJSONAtomic(path, meta)
+	return s.writeJSON(path, meta)
 }
 
 func (s *Store) GetTransferMeta(_ context.Context, transferID string) (domain.TransferMeta, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	path := s.transferMetaPath(transferID)
-	data, err := os.ReadFile(path)
+	data, err := s.readFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return domain.TransferMeta{}, storage.ErrNotFound
@@ -116,11 +148,12 @@ func (s *Store) GetTransferMeta(_ context.Context, transferID string) (domain.Tr
 }
 
 func (s *Store) DeleteTransferMeta(_ context.Context, transferID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.transferMetaPath(transferID)
-	if err := os.Remove(path); err != nil {
+	if err := s.remove(path); err != nil {
 		if os.IsNotExist(err) {
 			return storage.ErrNotFound
 		}
@@ -134,23 +167,33 @@ func (s *Store) WriteChunk(_ context.Context, transferID string, offset int64, d
 		return storage.ErrInvalidRange
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	if s.keys != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	} else {
+		lock.RLock()
+		defer lock.RUnlock()
+	}
 
 	path := s.dataPath(transferID)
 	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return err
 	}
-	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	sealed, err := s.dataBlocks(transferID, true)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	if _, err := file.Seek(offset, io.SeekStart); err != nil {
-		return err
+	if sealed != nil {
+		return writeSealedAt(file, sealed, data, offset)
 	}
-	_, err = file.Write(data)
+	_, err = file.WriteAt(data, offset)
 	return err
 }
 
@@ -165,8 +208,9 @@ func (s *Store) ReadRange(_ context.Context, transferID string, offset int64, le
 		return nil, storage.ErrInvalidRange
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	path := s.dataPath(transferID)
 	file, err := os.Open(path)
@@ -178,12 +222,15 @@ func (s *Store) ReadRange(_ context.Context, transferID string, offset int64, le
 	}
 	defer file.Close()
 
-	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+	sealed, err := s.dataBlocks(transferID, false)
+	if err != nil {
 		return nil, err
 	}
-
+	if sealed != nil {
+		return readSealedAt(file, sealed, offset, length)
+	}
 	buf := make([]byte, length)
-	n, err := file.Read(buf)
+	n, err := file.ReadAt(buf, offset)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
@@ -191,20 +238,18 @@ func (s *Store) ReadRange(_ context.Context, transferID string, offset int64, le
 }
 
 func (s *Store) DeleteTransfer(_ context.Context, transferID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.transferLocks.get(transferID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.transferDir(transferID)
-	if err := os.RemoveAll(path); err != nil {
+	if err := s.removeAll(path); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (s *Store) SweepExpired(_ context.Context, now time.Time) (storage.SweepResult, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	now = now.UTC()
 	result := storage.SweepResult{}
 
@@ -216,26 +261,13 @@ func (s *Store) SweepExpired(_ context.Context, now time.Time) (storage.SweepRes
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
 			continue
 		}
-		path := filepath.Join(s.sessionsDir, entry.Name())
-		data, err := os.ReadFile(path)
-		if err != nil {
-			continue
-		}
-		var session domain.Session
-		if err := json.Unmarshal(data, &session); err != nil {
+		transferIDs, removed := s.sweepSession(strings.TrimSuffix(entry.Name(), ".json"), now)
+		if !removed {
 			continue
 		}
-		if now.Before(session.ExpiresAt) {
-			continue
-		}
-		_ = os.Remove(path)
 		result.Sessions++
-		s.deleteAuthContextsLocked(session.ID)
-		for _, claim := range session.Claims {
-			if claim.TransferID == "" {
-				continue
-			}
-			_ = os.RemoveAll(s.transferDir(claim.TransferID))
+		for _, transferID := range transferIDs {
+			s.removeTransferDir(transferID)
 			result.Transfers++
 		}
 	}
@@ -245,23 +277,9 @@ func (s *Store) SweepExpired(_ context.Context, now time.Time) (storage.SweepRes
 		return result, err
 	}
 	for _, entry := range transferEntries {
-		if !entry.IsDir() {
-			continue
-		}
-		metaPath := filepath.Join(s.transfersDir, entry.Name(), "meta.json")
-		data, err := os.ReadFile(metaPath)
-		if err != nil {
-			continue
-		}
-		var meta domain.TransferMeta
-		if err := json.Unmarshal(data, &meta); err != nil {
-			continue
-		}
-		if now.Before(meta.ExpiresAt) {
-			continue
+		if entry.IsDir() && s.sweepTransfer(entry.Name(), now) {
+			result.Transfers++
 		}
-		_ = os.RemoveAll(filepath.Join(s.transfersDir, entry.Name()))
-		result.Transfers++
 	}
 
 	scanEntries, err := os.ReadDir(s.scansDir)
@@ -269,31 +287,93 @@ func (s *Store) SweepExpired(_ context.Context, now time.Time) (storage.SweepRes
 		return result, err
 	}
 	for _, entry := range scanEntries {
-		if !entry.IsDir() {
-			continue
+		if entry.IsDir() && s.sweepScan(entry.Name(), now) {
+			result.Scans++
 		}
-		metaPath := filepath.Join(s.scansDir, entry.Name(), "meta.json")
-		data, err := os.ReadFile(metaPath)
-		if err != nil {
-			continue
-		}
-		var scan domain.ScanSession
-		if err := json.Unmarshal(data, &scan); err != nil {
-			continue
-		}
-		if now.Before(scan.ExpiresAt) {
-			continue
-		}
-		_ = os.RemoveAll(filepath.Join(s.scansDir, entry.Name()))
-		result.Scans++
 	}
 
 	return result, nil
 }
 
+func (s *Store) sweepSession(sessionID string, now time.Time) ([]string, bool) {
+	lock := s.sessionLocks.get(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := s.sessionPath(sessionID)
+	data, err := s.readFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var session domain.Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, false
+	}
+	if now.Before(session.ExpiresAt) {
+		return nil, false
+	}
+	_ = s.remove(path)
+	s.deleteAuthContextsLocked(session.ID)
+	var transferIDs []string
+	for _, claim := range session.Claims {
+		if claim.TransferID != "" {
+			transferIDs = append(transferIDs, claim.TransferID)
+		}
+	}
+	return transferIDs, true
+}
+
+func (s *Store) removeTransferDir(transferID string) {
+	lock := s.transferLocks.get(transferID)
+	lock.Lock()
+	defer lock.Unlock()
+	_ = s.removeAll(s.transferDir(transferID))
+}
+
+func (s *Store) sweepTransfer(transferID string, now time.Time) bool {
+	lock := s.transferLocks.get(transferID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, err := s.readFile(s.transferMetaPath(transferID))
+	if err != nil {
+		return false
+	}
+	var meta domain.TransferMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return false
+	}
+	if now.Before(meta.ExpiresAt) {
+		return false
+	}
+	_ = s.removeAll(s.transferDir(transferID))
+	return true
+}
+
+func (s *Store) sweepScan(scanID string, now time.Time) bool {
+	lock := s.scanLocks.get(scanID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, err := s.readFile(s.scanMetaPath(scanID))
+	if err != nil {
+		return false
+	}
+	var scan domain.ScanSession
+	if err := json.Unmarshal(data, &scan); err != nil {
+		return false
+	}
+	if now.Before(scan.ExpiresAt) {
+		return false
+	}
+	_ = s.removeAll(s.scanDir(scanID))
+	return true
+}
+
 func (s *Store) CreateScanSession(_ context.Context, scan domain.ScanSession) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scan.ID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.scanMetaPath(scan.ID)
 	if _, err := os.Stat(path); err == nil {
@@ -301,15 +381,16 @@ func (s *Store) CreateScanSession(_ context.Context, scan domain.ScanSession) er
 	} else if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return writeJSONAtomic(path, scan)
+	return s.writeJSON(path, scan)
 }
 
 func (s *Store) GetScanSession(_ context.Context, scanID string) (domain.ScanSession, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scanID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	path := s.scanMetaPath(scanID)
-	data, err := os.ReadFile(path)
+	data, err := s.readFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return domain.ScanSession{}, storage.ErrNotFound
@@ -324,11 +405,12 @@ func (s *Store) GetScanSession(_ context.Context, scanID string) (domain.ScanSes
 }
 
 func (s *Store) DeleteScanSession(_ context.Context, scanID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scanID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.scanDir(scanID)
-	if err := os.RemoveAll(path); err != nil {
+	if err := s.removeAll(path); err != nil {
 		return err
 	}
 	return nil
@@ -338,16 +420,18 @@ func (s *Store) StoreScanChunk(_ context.Context, scanID string, chunkIndex int,
 	if chunkIndex < 0 {
 		return storage.ErrInvalidRange
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scanID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	chunkPath := filepath.Join(s.scanChunksDir(scanID), strconv.Itoa(chunkIndex)+".bin")
-	return writeFileAtomic(chunkPath, data, 0600)
+	return s.writeFile(chunkPath, data)
 }
 
 func (s *Store) ListScanChunks(_ context.Context, scanID string) ([]int, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scanID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	dir := s.scanChunksDir(scanID)
 	entries, err := os.ReadDir(dir)
@@ -374,11 +458,12 @@ func (s *Store) ListScanChunks(_ context.Context, scanID string) ([]int, error)
 }
 
 func (s *Store) LoadScanChunk(_ context.Context, scanID string, chunkIndex int) ([]byte, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scanID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	chunkPath := filepath.Join(s.scanChunksDir(scanID), strconv.Itoa(chunkIndex)+".bin")
-	data, err := os.ReadFile(chunkPath)
+	data, err := s.readFile(chunkPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, storage.ErrNotFound
@@ -389,19 +474,21 @@ func (s *Store) LoadScanChunk(_ context.Context, scanID string, chunkIndex int)
 }
 
 func (s *Store) DeleteScanChunks(_ context.Context, scanID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.scanLocks.get(scanID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	dir := s.scanChunksDir(scanID)
-	if err := os.RemoveAll(dir); err != nil {
+	if err := s.removeAll(dir); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (s *Store) CreateSession(_ context.Context, session domain.Session) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.sessionLocks.get(session.ID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.sessionPath(session.ID)
 	if _, err := os.Stat(path); err == nil {
@@ -410,15 +497,16 @@ func (s *Store) CreateSession(_ context.Context, session domain.Session) error {
 		return err
 	}
 
-	return writeJSONAtomic(path, session)
+	return s.writeJSON(path, session)
 }
 
 func (s *Store) GetSession(_ context.Context, sessionID string) (domain.Session, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.sessionLocks.get(sessionID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	path := s.sessionPath(sessionID)
-	data, err := os.ReadFile(path)
+	data, err := s.readFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return domain.Session{}, storage.ErrNotFound
@@ -434,8 +522,9 @@ func (s *Store) GetSession(_ context.Context, sessionID string) (domain.Session,
 }
 
 func (s *Store) UpdateSession(_ context.Context, session domain.Session) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.sessionLocks.get(session.ID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.sessionPath(session.ID)
 	if _, err := os.Stat(path); err != nil {
@@ -444,15 +533,16 @@ func (s *Store) UpdateSession(_ context.Context, session domain.Session) error {
 		}
 		return err
 	}
-	return writeJSONAtomic(path, session)
+	return s.writeJSON(path, session)
 }
 
 func (s *Store) DeleteSession(_ context.Context, sessionID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.sessionLocks.get(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.sessionPath(sessionID)
-	if err := os.Remove(path); err != nil {
+	if err := s.remove(path); err != nil {
 		if os.IsNotExist(err) {
 			return storage.ErrNotFound
 		}
@@ -462,19 +552,21 @@ func (s *Store) DeleteSession(_ context.Context, sessionID string) error {
 }
 
 func (s *Store) SaveSessionAuthContext(_ context.Context, auth domain.SessionAuthContext) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.sessionLocks.get(auth.SessionID)
+	lock.Lock()
+	defer lock.Unlock()
 
 	path := s.authPath(auth.SessionID, auth.ClaimID)
-	return writeJSONAtomic(path, auth)
+	return s.writeJSON(path, auth)
 }
 
 func (s *Store) GetSessionAuthContext(_ context.Context, sessionID string, claimID string) (domain.SessionAuthContext, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock := s.sessionLocks.get(sessionID)
+	lock.RLock()
+	defer lock.RUnlock()
 
 	path := s.authPath(sessionID, claimID)
-	data, err := os.ReadFile(path)
+	data, err := s.readFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return domain.SessionAuthContext{}, storage.ErrNotFound
@@ -501,6 +593,10 @@ func (s *Store) dataPath(transferID string) string {
 	return filepath.Join(s.transferDir(transferID), "data.bin")
 }
 
+func (s *Store) dataKeyPath(transferID string) string {
+	return filepath.Join(s.transferDir(transferID), "key.bin")
+}
+
 func (s *Store) transferMetaPath(transferID string) string {
 	return filepath.Join(s.transferDir(transferID), "meta.json")
 }
@@ -539,7 +635,7 @@ func (s *Store) deleteAuthContextsLocked(sessionID string) {
 		if !strings.HasPrefix(entry.Name(), prefix) {
 			continue
 		}
-		_ = os.Remove(filepath.Join(s.authDir, entry.Name()))
+		_ = s.remove(filepath.Join(s.authDir, entry.Name()))
 	}
 }
 
@@ -548,17 +644,246 @@ func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, mode); err != nil {
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
 		return err
 	}
-	return os.Rename(tmp, path)
+	tmpPath := tmp.Name()
+	if err := writeAndSync(tmp, data, mode); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
 
-func writeJSONAtomic(path string, payload any) error {
+func writeAndSync(file *os.File, data []byte, mode os.FileMode) error {
+	if err := file.Chmod(mode); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+const sealedDataVersion = 1
+
+type sealedData struct {
+	blocks *atrest.Blocks
+	base   int64
+}
+
+func (s *Store) dataBlocks(transferID string, create bool) (*sealedData, error) {
+	if s.keys == nil {
+		return nil, nil
+	}
+	path := s.dataKeyPath(transferID)
+	key, err := s.readFile(path)
+	if err == nil {
+		return openSealedData(key)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !create {
+		return nil, nil
+	}
+	if _, err := os.Stat(s.dataPath(transferID)); err == nil {
+		return nil, nil
+	}
+	key, err = atrest.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	key = append([]byte{sealedDataVersion}, key...)
+	if err := s.writeFile(path, key); err != nil {
+		return nil, err
+	}
+	return openSealedData(key)
+}
+
+func openSealedData(key []byte) (*sealedData, error) {
+	base := int64(0)
+	if len(key) == atrest.KeySize+1 && key[0] == sealedDataVersion {
+		key = key[1:]
+		base = atrest.ExtentSize
+	}
+	blocks, err := atrest.NewBlocks(key)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedData{blocks: blocks, base: base}, nil
+}
+
+func (d *sealedData) extent(file *os.File) (int64, error) {
+	if d.base == 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		records := (info.Size() + atrest.RecordSize - 1) / atrest.RecordSize
+		if records == 0 {
+			return 0, nil
+		}
+		last, err := d.readBlock(file, records-1)
+		if err != nil {
+			return 0, err
+		}
+		return (records-1)*atrest.BlockSize + int64(len(last)), nil
+	}
+	record := make([]byte, atrest.ExtentSize)
+	n, err := file.ReadAt(record, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return d.blocks.OpenExtent(record[:n])
+}
+
+func (d *sealedData) readBlock(file *os.File, index int64) ([]byte, error) {
+	record := make([]byte, atrest.RecordSize)
+	n, err := file.ReadAt(record, d.base+index*atrest.RecordSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return d.blocks.Open(index, record[:n])
+}
+
+func (d *sealedData) writeBlock(file *os.File, index int64, plaintext []byte) error {
+	record, err := d.blocks.Seal(index, plaintext)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(record, d.base+index*atrest.RecordSize)
+	return err
+}
+
+func writeSealedAt(file *os.File, data *sealedData, chunk []byte, offset int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var size int64
+	if info.Size() > 0 {
+		if size, err = data.extent(file); err != nil {
+			return err
+		}
+	}
+	end := offset + int64(len(chunk))
+	for index := (size + atrest.BlockSize - 1) / atrest.BlockSize; index < offset/atrest.BlockSize; index++ {
+		if err := data.writeBlock(file, index, nil); err != nil {
+			return err
+		}
+	}
+	for len(chunk) > 0 {
+		index := offset / atrest.BlockSize
+		within := int(offset % atrest.BlockSize)
+		var plaintext []byte
+		if index*atrest.BlockSize < size {
+			if plaintext, err = data.readBlock(file, index); err != nil {
+				return err
+			}
+		}
+		n := atrest.BlockSize - within
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		if len(plaintext) < within+n {
+			plaintext = append(plaintext, make([]byte, within+n-len(plaintext))...)
+		}
+		copy(plaintext[within:], chunk[:n])
+		if err := data.writeBlock(file, index, plaintext); err != nil {
+			return err
+		}
+		chunk = chunk[n:]
+		offset += int64(n)
+	}
+	if data.base == 0 || (end <= size && info.Size() > 0) {
+		return nil
+	}
+	record, err := data.blocks.SealExtent(max(size, end))
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(record, 0)
+	return err
+}
+
+func readSealedAt(file *os.File, data *sealedData, offset int64, length int64) ([]byte, error) {
+	size, err := data.extent(file)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= size {
+		return []byte{}, nil
+	}
+	end := min(offset+length, size)
+	out := make([]byte, 0, end-offset)
+	for offset < end {
+		index := offset / atrest.BlockSize
+		plaintext, err := data.readBlock(file, index)
+		if err != nil {
+			return nil, err
+		}
+		blockLen := min(atrest.BlockSize, size-index*atrest.BlockSize)
+		if int64(len(plaintext)) > blockLen {
+			return nil, atrest.ErrCorrupt
+		}
+		plaintext = append(plaintext, make([]byte, blockLen-int64(len(plaintext)))...)
+		within := offset % atrest.BlockSize
+		n := min(blockLen-within, end-offset)
+		out = append(out, plaintext[within:within+n]...)
+		offset += n
+	}
+	return out, nil
+}
+
+func (s *Store) writeJSON(path string, payload any) error {
 	data, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
 		return err
 	}
+	return s.writeFile(path, data)
+}
+
+func (s *Store) writeFile(path string, data []byte) error {
+	if s.keys != nil {
+		sealed, err := s.keys.Seal(s.objectName(path), data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
 	return writeFileAtomic(path, data, 0600)
 }
+
+func (s *Store) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !atrest.IsSealed(data) {
+		return data, nil
+	}
+	if s.keys == nil {
+		return nil, atrest.ErrNoKeyring
+	}
+	return s.keys.Open(s.objectName(path), data)
+}
+
+func (s *Store) objectName(path string) string {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
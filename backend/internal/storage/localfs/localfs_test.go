@@ -1,13 +1,20 @@
 package localfs
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"universaldrop/internal/atrest"
 	"universaldrop/internal/domain"
+	"universaldrop/internal/storage"
 )
 
 func TestSweepExpiredRemovesSessionsAndTransfers(t *testing.T) {
@@ -66,3 +73,502 @@ func TestSweepExpiredRemovesSessionsAndTransfers(t *testing.T) {
 		t.Fatalf("expected transfer directory removed")
 	}
 }
+
+func TestCapacityReportsFilesystemSpace(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	capacity, err := store.Capacity(context.Background())
+	if err != nil {
+		t.Fatalf("capacity: %v", err)
+	}
+	if capacity.TotalBytes == 0 || capacity.FreeBytes > capacity.TotalBytes {
+		t.Fatalf("unexpected capacity %+v", capacity)
+	}
+	if capacity.FreeInodes > capacity.TotalInodes {
+		t.Fatalf("unexpected inode counts %+v", capacity)
+	}
+}
+
+func TestConcurrentTransfersSessionsAndSweeps(t *testing.T) {
+	ctx := context.Background()
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+
+	const (
+		transfers = 8
+		chunks    = 16
+		chunkSize = 1024
+	)
+	now := time.Now().UTC()
+	var wg sync.WaitGroup
+	for i := 0; i < transfers; i++ {
+		transferID := fmt.Sprintf("trans%d", i)
+		sessionID := fmt.Sprintf("sess%d", i)
+		if err := store.CreateSession(ctx, domain.Session{ID: sessionID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("create session: %v", err)
+		}
+		for c := 0; c < chunks; c++ {
+			wg.Add(1)
+			go func(c int) {
+				defer wg.Done()
+				data := bytes.Repeat([]byte{byte(c)}, chunkSize)
+				if err := store.WriteChunk(ctx, transferID, int64(c*chunkSize), data); err != nil {
+					t.Errorf("write chunk: %v", err)
+					return
+				}
+				meta := domain.TransferMeta{BytesReceived: int64((c + 1) * chunkSize), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+				if err := store.SaveTransferMeta(ctx, transferID, meta); err != nil {
+					t.Errorf("save meta: %v", err)
+				}
+				if _, err := store.GetTransferMeta(ctx, transferID); err != nil {
+					t.Errorf("get meta: %v", err)
+				}
+				session := domain.Session{ID: sessionID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+				if err := store.UpdateSession(ctx, session); err != nil {
+					t.Errorf("update session: %v", err)
+				}
+				if _, err := store.ReadRange(ctx, transferID, int64(c*chunkSize), chunkSize); err != nil {
+					t.Errorf("read range: %v", err)
+				}
+			}(c)
+		}
+	}
+	var sweeps atomic.Int64
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			result, err := store.SweepExpired(ctx, now)
+			if err != nil {
+				t.Errorf("sweep: %v", err)
+				return
+			}
+			sweeps.Add(int64(result.Total()))
+		}
+	}()
+	wg.Wait()
+
+	if sweeps.Load() != 0 {
+		t.Fatalf("expected live entries to survive sweeps, removed %d", sweeps.Load())
+	}
+	for i := 0; i < transfers; i++ {
+		transferID := fmt.Sprintf("trans%d", i)
+		data, err := store.ReadRange(ctx, transferID, 0, chunks*chunkSize)
+		if err != nil {
+			t.Fatalf("read back: %v", err)
+		}
+		for c := 0; c < chunks; c++ {
+			want := bytes.Repeat([]byte{byte(c)}, chunkSize)
+			if !bytes.Equal(data[c*chunkSize:(c+1)*chunkSize], want) {
+				t.Fatalf("chunk %d of %s corrupted", c, transferID)
+			}
+		}
+		if _, err := store.GetTransferMeta(ctx, transferID); err != nil {
+			t.Fatalf("meta of %s unreadable: %v", transferID, err)
+		}
+	}
+	leftovers, err := filepath.Glob(filepath.Join(store.transfersDir, "*", "*.tmp"))
+	if err != nil || len(leftovers) != 0 {
+		t.Fatalf("expected no temp files left behind, got %v %v", leftovers, err)
+	}
+}
+
+func BenchmarkParallelWriteChunk(b *testing.B) {
+	ctx := context.Background()
+	store, err := New(b.TempDir())
+	if err != nil {
+		b.Fatalf("new store: %v", err)
+	}
+	data := bytes.Repeat([]byte{1}, 64<<10)
+	var next atomic.Int64
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		transferID := fmt.Sprintf("trans%d", next.Add(1))
+		var offset int64
+		for pb.Next() {
+			if err := store.WriteChunk(ctx, transferID, offset, data); err != nil {
+				b.Errorf("write chunk: %v", err)
+				return
+			}
+			offset = (offset + int64(len(data))) % (16 << 20)
+		}
+	})
+}
+
+func BenchmarkParallelReadRange(b *testing.B) {
+	ctx := context.Background()
+	store, err := New(b.TempDir())
+	if err != nil {
+		b.Fatalf("new store: %v", err)
+	}
+	const size = 4 << 20
+	const length = 64 << 10
+	data := bytes.Repeat([]byte{1}, size)
+	var next atomic.Int64
+	for i := 1; i <= 64; i++ {
+		if err := store.WriteChunk(ctx, fmt.Sprintf("trans%d", i), 0, data); err != nil {
+			b.Fatalf("write chunk: %v", err)
+		}
+	}
+	b.SetBytes(length)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		transferID := fmt.Sprintf("trans%d", (next.Add(1)-1)%64+1)
+		var offset int64
+		for pb.Next() {
+			if _, err := store.ReadRange(ctx, transferID, offset, length); err != nil {
+				b.Errorf("read range: %v", err)
+				return
+			}
+			offset = (offset + length) % size
+		}
+	})
+}
+
+func TestRewrapHoldsTheSessionLockForAuthContexts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldKey := bytes.Repeat([]byte{1}, atrest.KeySize)
+	newKey := bytes.Repeat([]byte{2}, atrest.KeySize)
+
+	keys, err := atrest.NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	store, err := NewWithOptions(dir, Options{Keyring: keys})
+	if err != nil {
+		t.Fatalf("new sealed store: %v", err)
+	}
+	auth := domain.SessionAuthContext{SessionID: "sess_a-b", ClaimID: "claim_1"}
+	if err := store.SaveSessionAuthContext(ctx, auth); err != nil {
+		t.Fatalf("save auth context: %v", err)
+	}
+
+	rotatedKeys, err := atrest.NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("rotated keyring: %v", err)
+	}
+	rotated, err := NewWithOptions(dir, Options{Keyring: rotatedKeys})
+	if err != nil {
+		t.Fatalf("rotated store: %v", err)
+	}
+	lock := rotated.sessionLocks.get(auth.SessionID)
+	lock.Lock()
+	done := make(chan int, 1)
+	go func() {
+		count, err := rotated.Rewrap(ctx)
+		if err != nil {
+			t.Errorf("rewrap: %v", err)
+		}
+		done <- count
+	}()
+	select {
+	case <-done:
+		lock.Unlock()
+		t.Fatalf("expected rewrap to wait for the session lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Unlock()
+	if count := <-done; count != 1 {
+		t.Fatalf("expected the auth context to be rewrapped, got %d", count)
+	}
+	if _, err := rotated.GetSessionAuthContext(ctx, auth.SessionID, auth.ClaimID); err != nil {
+		t.Fatalf("read rewrapped auth context: %v", err)
+	}
+}
+
+func TestSealedStoreEncryptsFilesAndRewrapsOnRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldKey := bytes.Repeat([]byte{1}, atrest.KeySize)
+	newKey := bytes.Repeat([]byte{2}, atrest.KeySize)
+
+	legacy, err := New(dir)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	now := time.Now().UTC()
+	if err := legacy.CreateSession(ctx, domain.Session{ID: "legacy", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("create legacy session: %v", err)
+	}
+
+	keys, err := atrest.NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	store, err := NewWithOptions(dir, Options{Keyring: keys})
+	if err != nil {
+		t.Fatalf("new sealed store: %v", err)
+	}
+	session := domain.Session{
+		ID:        "sess1",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+		Claims:    []domain.SessionClaim{{ID: "claim1", SenderLabel: "alice-laptop", SenderPubKeyB64: "cHVibGlja2V5"}},
+	}
+	if err := store.CreateSession(ctx, session); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if err := store.SaveManifest(ctx, "trans1", []byte("manifest-secret")); err != nil {
+		t.Fatalf("save manifest: %v", err)
+	}
+	payload := make([]byte, atrest.BlockSize*2+100)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	split := atrest.BlockSize + 17
+	if err := store.WriteChunk(ctx, "trans1", 0, payload[:split]); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	if err := store.WriteChunk(ctx, "trans1", int64(split), payload[split:]); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	got, err := store.ReadRange(ctx, "trans1", 10, int64(len(payload)))
+	if err != nil || !bytes.Equal(got, payload[10:]) {
+		t.Fatalf("expected sealed data to read back, got %d bytes %v", len(got), err)
+	}
+
+	for _, path := range []string{
+		filepath.Join(dir, "sessions", "sess1.json"),
+		filepath.Join(dir, "transfers", "trans1", "manifest.json"),
+		filepath.Join(dir, "transfers", "trans1", "data.bin"),
+	} {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		for _, secret := range [][]byte{[]byte("alice-laptop"), []byte("cHVibGlja2V5"), []byte("manifest-secret"), payload[:64]} {
+			if bytes.Contains(raw, secret) {
+				t.Fatalf("expected %s to hold no plaintext", path)
+			}
+		}
+	}
+	if _, err := legacy.GetSession(ctx, "sess1"); !errors.Is(err, atrest.ErrNoKeyring) {
+		t.Fatalf("expected sealed files to need a key, got %v", err)
+	}
+	if _, err := store.GetSession(ctx, "legacy"); err != nil {
+		t.Fatalf("expected plaintext files from before sealing to stay readable: %v", err)
+	}
+
+	rotatedKeys, err := atrest.NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("rotated keyring: %v", err)
+	}
+	rotated, err := NewWithOptions(dir, Options{Keyring: rotatedKeys})
+	if err != nil {
+		t.Fatalf("rotated store: %v", err)
+	}
+	count, err := rotated.Rewrap(ctx)
+	if err != nil {
+		t.Fatalf("rewrap: %v", err)
+	}
+	if count != 4 {
+		t.Fatalf("expected both sessions, the manifest and the data key to be rewrapped, got %d", count)
+	}
+	if count, err := rotated.Rewrap(ctx); err != nil || count != 0 {
+		t.Fatalf("expected a second rewrap to be a no-op, got %d %v", count, err)
+	}
+
+	newOnlyKeys, err := atrest.NewKeyring(newKey)
+	if err != nil {
+		t.Fatalf("new keyring: %v", err)
+	}
+	newOnly, err := NewWithOptions(dir, Options{Keyring: newOnlyKeys})
+	if err != nil {
+		t.Fatalf("new-key store: %v", err)
+	}
+	loaded, err := newOnly.GetSession(ctx, "sess1")
+	if err != nil || loaded.Claims[0].SenderLabel != "alice-laptop" {
+		t.Fatalf("expected the old key to be retired after rewrap, got %+v %v", loaded, err)
+	}
+	if _, err := newOnly.GetSession(ctx, "legacy"); err != nil {
+		t.Fatalf("expected legacy session to be sealed by rewrap: %v", err)
+	}
+	got, err = newOnly.ReadRange(ctx, "trans1", 0, int64(len(payload)))
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("expected data to survive a key rotation without re-encrypting, got %v", err)
+	}
+}
+
+func TestSealedDataRejectsTruncationAndBlankedRecords(t *testing.T) {
+	ctx := context.Background()
+	keys, err := atrest.NewKeyring(bytes.Repeat([]byte{3}, atrest.KeySize))
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	store, err := NewWithOptions(t.TempDir(), Options{Keyring: keys})
+	if err != nil {
+		t.Fatalf("new sealed store: %v", err)
+	}
+	payload := bytes.Repeat([]byte("0123456789"), atrest.BlockSize/5)
+	if err := store.WriteChunk(ctx, "sparse", int64(atrest.BlockSize*2), payload[:10]); err != nil {
+		t.Fatalf("write sparse chunk: %v", err)
+	}
+	got, err := store.ReadRange(ctx, "sparse", 0, int64(atrest.BlockSize*3))
+	if err != nil || len(got) != atrest.BlockSize*2+10 || !bytes.Equal(got[:atrest.BlockSize*2], make([]byte, atrest.BlockSize*2)) {
+		t.Fatalf("expected the unwritten range to read as zeros, got %d bytes %v", len(got), err)
+	}
+
+	for name, damage := range map[string]func(path string) error{
+		"truncated": func(path string) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			return os.Truncate(path, info.Size()-atrest.RecordSize)
+		},
+		"blanked": func(path string) error {
+			file, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = file.WriteAt(make([]byte, atrest.RecordSize), atrest.ExtentSize)
+			return err
+		},
+		"unheaded": func(path string) error {
+			file, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = file.WriteAt(make([]byte, atrest.ExtentSize), 0)
+			return err
+		},
+	} {
+		if err := store.WriteChunk(ctx, name, 0, payload); err != nil {
+			t.Fatalf("%s: write chunk: %v", name, err)
+		}
+		if err := damage(filepath.Join(store.root, "transfers", name, "data.bin")); err != nil {
+			t.Fatalf("%s: damage: %v", name, err)
+		}
+		if _, err := store.ReadRange(ctx, name, 0, int64(len(payload))); !errors.Is(err, atrest.ErrCorrupt) {
+			t.Fatalf("%s: expected the damaged data to fail authentication, got %v", name, err)
+		}
+	}
+}
+
+func TestSecureDeleteOverwritesOrShredsBeforeUnlinking(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys, err := atrest.NewKeyring(bytes.Repeat([]byte{7}, atrest.KeySize))
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	plain, err := NewWithOptions(dir, Options{SecureDelete: true})
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	sealed, err := NewWithOptions(dir, Options{Keyring: keys, SecureDelete: true})
+	if err != nil {
+		t.Fatalf("new sealed store: %v", err)
+	}
+	erased := map[string]int64{}
+	plain.SetEraseObserver(func(method string, n int64) { erased[method] += n })
+	sealed.SetEraseObserver(func(method string, n int64) { erased[method] += n })
+
+	payload := bytes.Repeat([]byte("secret"), 1000)
+	if err := plain.WriteChunk(ctx, "plain", 0, payload); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	if err := sealed.WriteChunk(ctx, "sealed", 0, payload); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	now := time.Now().UTC()
+	if err := plain.CreateSession(ctx, domain.Session{ID: "sess1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if err := plain.StoreScanChunk(ctx, "scan1", 0, payload); err != nil {
+		t.Fatalf("store scan chunk: %v", err)
+	}
+
+	witness := filepath.Join(t.TempDir(), "witness")
+	if err := os.Link(filepath.Join(dir, "transfers", "plain", "data.bin"), witness); err != nil {
+		t.Skipf("hard links unsupported: %v", err)
+	}
+	sessionPath := filepath.Join(dir, "sessions", "sess1.json")
+	sessionInfo, err := os.Stat(sessionPath)
+	if err != nil {
+		t.Fatalf("stat session: %v", err)
+	}
+	sealedData, err := os.Stat(filepath.Join(dir, "transfers", "sealed", "data.bin"))
+	if err != nil {
+		t.Fatalf("stat sealed data: %v", err)
+	}
+	sealedKey, err := os.Stat(filepath.Join(dir, "transfers", "sealed", "key.bin"))
+	if err != nil {
+		t.Fatalf("stat sealed key: %v", err)
+	}
+
+	if err := plain.DeleteTransfer(ctx, "plain"); err != nil {
+		t.Fatalf("delete transfer: %v", err)
+	}
+	if err := sealed.DeleteTransfer(ctx, "sealed"); err != nil {
+		t.Fatalf("delete sealed transfer: %v", err)
+	}
+	if err := plain.DeleteSession(ctx, "sess1"); err != nil {
+		t.Fatalf("delete session: %v", err)
+	}
+	if err := plain.DeleteScanSession(ctx, "scan1"); err != nil {
+		t.Fatalf("delete scan: %v", err)
+	}
+	if err := plain.DeleteSession(ctx, "sess1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected a missing session to stay not found, got %v", err)
+	}
+
+	left, err := os.ReadFile(witness)
+	if err != nil {
+		t.Fatalf("read witness: %v", err)
+	}
+	if !bytes.Equal(left, make([]byte, len(payload))) {
+		t.Fatalf("expected data to be overwritten before unlinking")
+	}
+	wantOverwrite := int64(2*len(payload)) + sessionInfo.Size() + sealedKey.Size()
+	if erased[EraseOverwrite] != wantOverwrite {
+		t.Fatalf("expected %d overwritten bytes, got %d", wantOverwrite, erased[EraseOverwrite])
+	}
+	if erased[EraseCryptoShred] != sealedData.Size() {
+		t.Fatalf("expected sealed data to be crypto-shredded, got %d", erased[EraseCryptoShred])
+	}
+	for _, path := range []string{"transfers/plain", "transfers/sealed", "sessions/sess1.json", "scans/scan1"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", path)
+		}
+	}
+}
+
+func TestSecureDeleteOverwritesDataWhenTheKeyCannotBeShredded(t *testing.T) {
+	store, err := NewWithOptions(t.TempDir(), Options{SecureDelete: true})
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	erased := map[string]int64{}
+	store.SetEraseObserver(func(method string, n int64) { erased[method] += n })
+
+	dir := filepath.Join(t.TempDir(), "transfer")
+	if err := os.MkdirAll(filepath.Join(dir, "key.bin"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	payload := bytes.Repeat([]byte("secret"), 100)
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), payload, 0o600); err != nil {
+		t.Fatalf("write data: %v", err)
+	}
+
+	if err := store.removeAll(dir); err == nil {
+		t.Fatalf("expected the failed key overwrite to be reported")
+	}
+	if erased[EraseCryptoShred] != 0 {
+		t.Fatalf("expected no crypto-shredded bytes without a destroyed key, got %d", erased[EraseCryptoShred])
+	}
+	if erased[EraseOverwrite] != int64(len(payload)) {
+		t.Fatalf("expected data to be overwritten instead, got %d", erased[EraseOverwrite])
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected the transfer directory to be removed")
+	}
+}
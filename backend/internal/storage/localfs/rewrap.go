@@ -0,0 +1,135 @@
+package localfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"universaldrop/internal/atrest"
+	"universaldrop/internal/domain"
+)
+
+func (s *Store) Rewrap(ctx context.Context) (int, error) {
+	if s.keys == nil {
+		return 0, nil
+	}
+	rewrapped := 0
+	walk := func(dir string, lockFor func(name string) func()) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := s.rewrapEntry(filepath.Join(dir, entry.Name()), entry, lockFor)
+			rewrapped += n
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(s.transfersDir, func(name string) func() {
+		return lockExclusive(s.transferLocks.get(name))
+	}); err != nil {
+		return rewrapped, err
+	}
+	if err := walk(s.scansDir, func(name string) func() {
+		return lockExclusive(s.scanLocks.get(name))
+	}); err != nil {
+		return rewrapped, err
+	}
+	if err := walk(s.sessionsDir, func(name string) func() {
+		return lockExclusive(s.sessionLocks.get(strings.TrimSuffix(name, ".json")))
+	}); err != nil {
+		return rewrapped, err
+	}
+	if err := walk(s.authDir, func(name string) func() {
+		return lockExclusive(s.sessionLocks.get(s.authSessionID(name)))
+	}); err != nil {
+		return rewrapped, err
+	}
+	return rewrapped, nil
+}
+
+func (s *Store) authSessionID(name string) string {
+	if data, err := s.readFile(filepath.Join(s.authDir, name)); err == nil {
+		var auth domain.SessionAuthContext
+		if err := json.Unmarshal(data, &auth); err == nil && auth.SessionID != "" {
+			return auth.SessionID
+		}
+	}
+	sessionID, _, _ := strings.Cut(strings.TrimSuffix(name, ".json"), "_")
+	return sessionID
+}
+
+func lockExclusive(lock *sync.RWMutex) func() {
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (s *Store) rewrapEntry(path string, entry os.DirEntry, lockFor func(name string) func()) (int, error) {
+	unlock := lockFor(entry.Name())
+	defer unlock()
+
+	if !entry.IsDir() {
+		changed, err := s.rewrapFile(path)
+		if changed {
+			return 1, err
+		}
+		return 0, err
+	}
+	rewrapped := 0
+	err := filepath.WalkDir(path, func(file string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		changed, err := s.rewrapFile(file)
+		if changed {
+			rewrapped++
+		}
+		return err
+	})
+	return rewrapped, err
+}
+
+func (s *Store) rewrapFile(path string) (bool, error) {
+	base := filepath.Base(path)
+	if base == "data.bin" || strings.HasSuffix(base, ".tmp") {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if id, ok := atrest.SealedWith(data); ok && id == s.keys.Primary() {
+		return false, nil
+	}
+	plaintext := data
+	if atrest.IsSealed(data) {
+		plaintext, err = s.keys.Open(s.objectName(path), data)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", s.objectName(path), err)
+		}
+	}
+	if err := s.writeFile(path, plaintext); err != nil {
+		return false, err
+	}
+	return true, nil
+}
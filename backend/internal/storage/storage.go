@@ -11,6 +11,7 @@ import (
 var ErrNotFound = errors.New("not found")
 var ErrInvalidRange = errors.New("invalid range")
 var ErrConflict = errors.New("conflict")
+var ErrCapacityUnsupported = errors.New("capacity reporting unsupported")
 
 type Storage interface {
 	SaveManifest(ctx context.Context, transferID string, manifest []byte) error
@@ -40,6 +41,17 @@ type Storage interface {
 	DeleteScanChunks(ctx context.Context, scanID string) error
 }
 
+type Capacity struct {
+	TotalBytes  uint64
+	FreeBytes   uint64
+	TotalInodes uint64
+	FreeInodes  uint64
+}
+
+type CapacityReporter interface {
+	Capacity(ctx context.Context) (Capacity, error)
+}
+
 type SweepResult struct {
 	Sessions  int
 	Transfers int
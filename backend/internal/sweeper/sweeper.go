@@ -2,10 +2,11 @@ package sweeper
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"strconv"
 	"time"
 
+	"universaldrop/internal/audit"
 	"universaldrop/internal/clock"
 	"universaldrop/internal/logging"
 	"universaldrop/internal/metrics"
@@ -16,12 +17,14 @@ type Sweeper struct {
 	store    storage.Storage
 	clock    clock.Clock
 	interval time.Duration
-	logger   *log.Logger
+	logger   *slog.Logger
 	liveness *Liveness
 	metrics  *metrics.Counters
+	audit    audit.Recorder
+	done     chan struct{}
 }
 
-func New(store storage.Storage, clk clock.Clock, interval time.Duration, logger *log.Logger, liveness *Liveness, counters *metrics.Counters) *Sweeper {
+func New(store storage.Storage, clk clock.Clock, interval time.Duration, logger *slog.Logger, liveness *Liveness, counters *metrics.Counters, auditLog audit.Recorder) *Sweeper {
 	return &Sweeper{
 		store:    store,
 		clock:    clk,
@@ -29,15 +32,19 @@ func New(store storage.Storage, clk clock.Clock, interval time.Duration, logger
 		logger:   logger,
 		liveness: liveness,
 		metrics:  counters,
+		audit:    auditLog,
+		done:     make(chan struct{}),
 	}
 }
 
 func (s *Sweeper) Start(ctx context.Context) {
 	if s.interval <= 0 {
+		close(s.done)
 		return
 	}
 	ticker := time.NewTicker(s.interval)
 	go func() {
+		defer close(s.done)
 		defer ticker.Stop()
 		for {
 			select {
@@ -50,6 +57,10 @@ func (s *Sweeper) Start(ctx context.Context) {
 	}()
 }
 
+func (s *Sweeper) Wait() {
+	<-s.done
+}
+
 func (s *Sweeper) SweepOnce(ctx context.Context) {
 	s.sweep(ctx)
 }
@@ -73,9 +84,18 @@ func (s *Sweeper) sweep(ctx context.Context) {
 		s.liveness.Mark(s.clock.Now())
 	}
 	if total := result.Total(); total > 0 {
-		logging.Allowlist(s.logger, map[string]string{
+		fields := map[string]string{
 			"event": "sweep_complete",
 			"count": strconv.Itoa(total),
-		})
+		}
+		logging.Allowlist(s.logger, fields)
+		if s.audit != nil {
+			if err := s.audit.Record(fields); err != nil {
+				logging.Allowlist(s.logger, map[string]string{
+					"event": "audit_write_failed",
+					"error": "audit_error",
+				})
+			}
+		}
 	}
 }
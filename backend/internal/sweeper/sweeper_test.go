@@ -0,0 +1,36 @@
+package sweeper
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"universaldrop/internal/audit"
+	"universaldrop/internal/clock"
+	"universaldrop/internal/domain"
+	"universaldrop/internal/storage/localfs"
+)
+
+func TestSweepWithAuditDisabled(t *testing.T) {
+	ctx := context.Background()
+	store, err := localfs.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	clk := clock.NewFake(time.Now().UTC())
+	session := domain.Session{ID: "sess1", CreatedAt: clk.Now().Add(-2 * time.Hour), ExpiresAt: clk.Now().Add(-time.Hour)}
+	if err := store.CreateSession(ctx, session); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	var disabled *audit.Log
+	for _, recorder := range []audit.Recorder{nil, disabled} {
+		s := New(store, clk, 0, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil, recorder)
+		s.SweepOnce(ctx)
+	}
+	if _, err := store.GetSession(ctx, "sess1"); err == nil {
+		t.Fatalf("expected expired session to be swept")
+	}
+}
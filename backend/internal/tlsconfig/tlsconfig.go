@@ -0,0 +1,128 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNoClientCAs = errors.New("client CA file contains no certificates")
+
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certStat fileStamp
+	keyStat  fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) Reload() (bool, error) {
+	certStat, err := stamp(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyStat, err := stamp(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && certStat == r.certStat && keyStat == r.keyStat
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.certStat = certStat
+	r.keyStat = keyStat
+	r.mu.Unlock()
+	return true, nil
+}
+
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if (changed || err != nil) && onReload != nil {
+				onReload(err)
+			}
+		}
+	}
+}
+
+func Server(certs *CertReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		NextProtos:       []string{"h2", "http/1.1"},
+	}
+}
+
+func RequireClientCerts(cfg *tls.Config, caFile string) (*tls.Config, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrNoClientCAs
+	}
+	out := cfg.Clone()
+	out.ClientCAs = pool
+	out.ClientAuth = tls.RequireAndVerifyClientCert
+	return out, nil
+}
+
+func stamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
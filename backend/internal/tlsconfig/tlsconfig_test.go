@@ -0,0 +1,215 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func issueCert(t *testing.T, serial int64, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "universaldrop-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeCert(t *testing.T, dir string, c *testCert) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, c.certPEM, 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func serveTLS(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig:         cfg,
+		ReadHeaderTimeout: time.Second,
+	}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { _ = server.Close() })
+	return "https://" + listener.Addr().String()
+}
+
+func client(roots *x509.CertPool, certs ...tls.Certificate) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			ForceAttemptHTTP2: true,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func TestServerReloadsCertificateAndNegotiatesHTTP2(t *testing.T) {
+	ca := issueCert(t, 1, nil, x509.ExtKeyUsageServerAuth)
+	first := issueCert(t, 10, ca, x509.ExtKeyUsageServerAuth)
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, first)
+
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load certs: %v", err)
+	}
+	url := serveTLS(t, Server(certs))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	resp, err := client(roots).Get(url)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+	if got := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); got != 10 {
+		t.Fatalf("expected first certificate, got serial %d", got)
+	}
+
+	if changed, err := certs.Reload(); err != nil || changed {
+		t.Fatalf("expected unchanged files to be skipped, changed=%v err=%v", changed, err)
+	}
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	if _, err := certs.Reload(); err == nil {
+		t.Fatalf("expected a broken key pair to be rejected")
+	}
+
+	second := issueCert(t, 20, ca, x509.ExtKeyUsageServerAuth)
+	writeCert(t, dir, second)
+	if changed, err := certs.Reload(); err != nil || !changed {
+		t.Fatalf("expected rotated certificate to load, changed=%v err=%v", changed, err)
+	}
+	resp, err = client(roots).Get(url)
+	if err != nil {
+		t.Fatalf("get after reload: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); got != 20 {
+		t.Fatalf("expected rotated certificate, got serial %d", got)
+	}
+
+	legacy := client(roots)
+	legacy.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS11
+	if _, err := legacy.Get(url); err == nil {
+		t.Fatalf("expected TLS 1.1 to be refused")
+	}
+}
+
+func TestRequireClientCerts(t *testing.T) {
+	ca := issueCert(t, 1, nil, x509.ExtKeyUsageServerAuth)
+	serverCert := issueCert(t, 2, ca, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := writeCert(t, t.TempDir(), serverCert)
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load certs: %v", err)
+	}
+	clientCA := issueCert(t, 3, nil, x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(t.TempDir(), "clients.pem")
+	if err := os.WriteFile(caFile, clientCA.certPEM, 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+	cfg, err := RequireClientCerts(Server(certs), caFile)
+	if err != nil {
+		t.Fatalf("client CAs: %v", err)
+	}
+	url := serveTLS(t, cfg)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	if resp, err := client(roots).Get(url); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected a request without a client certificate to fail")
+	}
+	stranger := issueCert(t, 4, nil, x509.ExtKeyUsageClientAuth)
+	strangerPair, _ := tls.X509KeyPair(stranger.certPEM, stranger.keyPEM)
+	if resp, err := client(roots, strangerPair).Get(url); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected a certificate from an unknown CA to fail")
+	}
+	member := issueCert(t, 5, clientCA, x509.ExtKeyUsageClientAuth)
+	memberPair, _ := tls.X509KeyPair(member.certPEM, member.keyPEM)
+	resp, err := client(roots, memberPair).Get(url)
+	if err != nil {
+		t.Fatalf("expected trusted client certificate to succeed: %v", err)
+	}
+	resp.Body.Close()
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("nothing here"), 0o600); err != nil {
+		t.Fatalf("write empty: %v", err)
+	}
+	if _, err := RequireClientCerts(Server(certs), empty); !errors.Is(err, ErrNoClientCAs) {
+		t.Fatalf("expected empty CA file to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrExportRejected = errors.New("trace export rejected")
+
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+type fileSpan struct {
+	Name         string            `json:"name"`
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationMS   float64           `json:"duration_ms"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        bool              `json:"error,omitempty"`
+}
+
+type JSONFileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONFileExporter(path string) (*JSONFileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileExporter{file: file}, nil
+}
+
+func (e *JSONFileExporter) Export(_ context.Context, spans []SpanData) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, span := range spans {
+		record := fileSpan{
+			Name:       span.Name,
+			TraceID:    span.TraceID.String(),
+			SpanID:     span.SpanID.String(),
+			Start:      span.Start.UTC(),
+			End:        span.End.UTC(),
+			DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			Attributes: span.Attributes,
+			Error:      span.Error,
+		}
+		if span.ParentSpanID.IsValid() {
+			record.ParentSpanID = span.ParentSpanID.String()
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(buf.Bytes())
+	return err
+}
+
+func (e *JSONFileExporter) Shutdown(_ context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+type OTLPOptions struct {
+	Endpoint    string
+	ServiceName string
+	Client      *http.Client
+}
+
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+func NewOTLPExporter(opts OTLPOptions) *OTLPExporter {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	service := opts.ServiceName
+	if service == "" {
+		service = "universaldrop"
+	}
+	return &OTLPExporter{endpoint: opts.Endpoint, service: service, client: client}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		item := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if span.ParentSpanID.IsValid() {
+			item.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Server {
+			item.Kind = otlpSpanKindServer
+		}
+		if span.Error {
+			item.Status.Code = otlpStatusError
+		}
+		keys := make([]string, 0, len(span.Attributes))
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			item.Attributes = append(item.Attributes, otlpKeyValue{Key: key, Value: otlpValue{StringValue: span.Attributes[key]}})
+		}
+		converted = append(converted, item)
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpValue{StringValue: e.service}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "universaldrop/internal/tracing"},
+			Spans: converted,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: status %d", ErrExportRejected, resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(_ context.Context) error {
+	return nil
+}
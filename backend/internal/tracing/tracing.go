@@ -0,0 +1,356 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TraceparentHeader    = "traceparent"
+	DefaultBatchSize     = 256
+	DefaultQueueSize     = 4096
+	DefaultFlushInterval = 5 * time.Second
+)
+
+var allowedAttributes = map[string]struct{}{
+	"method":           {},
+	"route":            {},
+	"status":           {},
+	"op":               {},
+	"scope":            {},
+	"reason":           {},
+	"error":            {},
+	"bytes":            {},
+	"outcome":          {},
+	"session_id_hash":  {},
+	"claim_id_hash":    {},
+	"transfer_id_hash": {},
+	"scan_id_hash":     {},
+}
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if !decodeHex(parts[1], sc.TraceID[:]) || !decodeHex(parts[2], sc.SpanID[:]) {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if !decodeHex(parts[3], flags[:]) || !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+func Extract(r *http.Request) (SpanContext, bool) {
+	return ParseTraceparent(r.Header.Get(TraceparentHeader))
+}
+
+func decodeHex(value string, out []byte) bool {
+	if len(value) != 2*len(out) || strings.ToLower(value) != value {
+		return false
+	}
+	_, err := hex.Decode(out, []byte(value))
+	return err == nil
+}
+
+type SpanData struct {
+	Name         string
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Server       bool
+	Error        bool
+}
+
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: true}
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil || value == "" {
+		return
+	}
+	if _, ok := allowedAttributes[key]; !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Attributes[key] = value
+}
+
+func (s *Span) SetError(code string) {
+	if s == nil {
+		return
+	}
+	s.SetAttribute("error", code)
+	s.mu.Lock()
+	s.data.Error = true
+	s.mu.Unlock()
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
+
+type spanKey struct{}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, parent.Context())
+}
+
+type Options struct {
+	SamplePercent int
+	BatchSize     int
+	QueueSize     int
+	FlushInterval time.Duration
+	OnDrop        func(int)
+}
+
+type Tracer struct {
+	exporter Exporter
+	opts     Options
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	closed   atomic.Bool
+	wg       sync.WaitGroup
+}
+
+func New(exporter Exporter, opts Options) *Tracer {
+	if opts.SamplePercent <= 0 || opts.SamplePercent > 100 {
+		opts.SamplePercent = 100
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	t := &Tracer{
+		exporter: exporter,
+		opts:     opts,
+		queue:    make(chan SpanData, opts.QueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+func (t *Tracer) StartRequest(ctx context.Context, name string, r *http.Request) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	var draw TraceID
+	_, _ = rand.Read(draw[:])
+	if !t.sampled(draw) {
+		return ctx, nil
+	}
+	remote, ok := Extract(r)
+	if !ok {
+		remote = SpanContext{TraceID: draw}
+	}
+	ctx, span := t.start(ctx, name, remote)
+	if span != nil {
+		span.data.Server = true
+	}
+	return ctx, span
+}
+
+func (t *Tracer) start(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	if t == nil || t.closed.Load() {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, data: SpanData{
+		Name:         name,
+		TraceID:      parent.TraceID,
+		ParentSpanID: parent.SpanID,
+		Start:        time.Now(),
+		Attributes:   map[string]string{},
+	}}
+	_, _ = rand.Read(span.data.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) sampled(id TraceID) bool {
+	if t.opts.SamplePercent >= 100 {
+		return true
+	}
+	return binary.BigEndian.Uint64(id[8:])%100 < uint64(t.opts.SamplePercent)
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	if t.closed.Load() {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		if t.opts.OnDrop != nil {
+			t.opts.OnDrop(1)
+		}
+	}
+}
+
+func (t *Tracer) Flush() {
+	if t == nil || t.closed.Load() {
+		return
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+		<-ack
+	case <-t.done:
+	}
+}
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || !t.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(t.done)
+	t.wg.Wait()
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, t.opts.BatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), t.opts.FlushInterval)
+		if err := t.exporter.Export(ctx, batch); err != nil && t.opts.OnDrop != nil {
+			t.opts.OnDrop(len(batch))
+		}
+		cancel()
+		batch = make([]SpanData, 0, t.opts.BatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+				if len(batch) >= t.opts.BatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.opts.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-t.flush:
+			drain()
+			close(ack)
+		case <-t.done:
+			drain()
+			return
+		}
+	}
+}
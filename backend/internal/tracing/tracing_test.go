@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !sc.Sampled {
+		t.Fatalf("expected valid sampled traceparent")
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected ids %s %s", sc.TraceID, sc.SpanID)
+	}
+	if sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("expected round trip, got %s", sc.Traceparent())
+	}
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestSpansLinkToRemoteParentAndDropUnknownAttributes(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := New(exporter, Options{})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, root := tracer.StartRequest(context.Background(), "http.request", req)
+	root.SetAttribute("route", "/v1/transfer/chunk")
+	root.SetAttribute("session_id", "raw-session")
+	_, child := Start(ctx, "storage.write_chunk")
+	child.SetError("not_found")
+	child.End()
+	root.End()
+	tracer.Flush()
+
+	exporter.mu.Lock()
+	spans := append([]SpanData(nil), exporter.spans...)
+	exporter.mu.Unlock()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	childData, rootData := spans[0], spans[1]
+	if rootData.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || rootData.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected root to continue the client trace")
+	}
+	if !rootData.Server || childData.Server {
+		t.Fatalf("expected only the request span to be a server span")
+	}
+	if childData.TraceID != rootData.TraceID || childData.ParentSpanID != rootData.SpanID {
+		t.Fatalf("expected child to be parented to root")
+	}
+	if _, ok := rootData.Attributes["session_id"]; ok {
+		t.Fatalf("expected non-allowlisted attribute to be dropped")
+	}
+	if rootData.Attributes["route"] != "/v1/transfer/chunk" {
+		t.Fatalf("expected route attribute")
+	}
+	if !childData.Error || childData.Attributes["error"] != "not_found" {
+		t.Fatalf("expected child error code")
+	}
+
+	unsampled := httptest.NewRequest(http.MethodGet, "/", nil)
+	unsampled.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if _, span := tracer.StartRequest(context.Background(), "http.request", unsampled); span == nil || span.data.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the local sample rate to record an unsampled parent")
+	}
+	if _, span := Start(context.Background(), "orphan"); span != nil {
+		t.Fatalf("expected no span without a request parent")
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestClientTraceFlagsDoNotOverrideSamplePercent(t *testing.T) {
+	tracer := New(&memoryExporter{}, Options{SamplePercent: 10})
+	defer tracer.Shutdown(context.Background())
+	recorded := 0
+	for i := 0; i < 1000; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		if _, span := tracer.StartRequest(context.Background(), "http.request", req); span != nil {
+			recorded++
+		}
+	}
+	if recorded == 0 || recorded > 300 {
+		t.Fatalf("expected about 10%% of forced-sampled requests to be recorded, got %d of 1000", recorded)
+	}
+}
+
+func TestExporters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces", "spans.jsonl")
+	fileExporter, err := NewJSONFileExporter(path)
+	if err != nil {
+		t.Fatalf("file exporter: %v", err)
+	}
+
+	var mu sync.Mutex
+	var received []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = body
+		mu.Unlock()
+	}))
+	defer collector.Close()
+	otlp := NewOTLPExporter(OTLPOptions{Endpoint: collector.URL + "/v1/traces"})
+
+	for _, exporter := range []Exporter{fileExporter, otlp} {
+		tracer := New(exporter, Options{})
+		ctx, root := tracer.StartRequest(context.Background(), "http.request", httptest.NewRequest(http.MethodGet, "/", nil))
+		root.SetAttribute("route", "/healthz")
+		_, child := Start(ctx, "auth.check_capability")
+		child.End()
+		root.End()
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			t.Fatalf("shutdown: %v", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open spans: %v", err)
+	}
+	defer file.Close()
+	var lines []fileSpan
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record fileSpan
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decode span: %v", err)
+		}
+		lines = append(lines, record)
+	}
+	if len(lines) != 2 || lines[0].ParentSpanID != lines[1].SpanID || lines[1].Attributes["route"] != "/healthz" {
+		t.Fatalf("unexpected file spans %+v", lines)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var payload otlpRequest
+	if err := json.Unmarshal(received, &payload); err != nil {
+		t.Fatalf("decode otlp payload: %v", err)
+	}
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[1].Kind != otlpSpanKindServer || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Fatalf("unexpected otlp spans %+v", spans)
+	}
+	if payload.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "universaldrop" {
+		t.Fatalf("expected service name resource attribute")
+	}
+}
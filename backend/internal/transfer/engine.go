@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
@@ -14,6 +15,7 @@ import (
 	"universaldrop/internal/domain"
 	"universaldrop/internal/scanner"
 	"universaldrop/internal/storage"
+	"universaldrop/internal/tracing"
 )
 
 var ErrInvalidInput = errors.New("invalid input")
@@ -45,6 +47,8 @@ func (e *Engine) CreateTransferWithID(ctx context.Context, transferID string, ma
 	if transferID == "" || len(manifest) == 0 || totalBytes < 0 {
 		return ErrInvalidInput
 	}
+	ctx, span := tracing.Start(ctx, "transfer.create")
+	defer span.End()
 	if _, err := e.store.LoadManifest(ctx, transferID); err == nil {
 		return storage.ErrConflict
 	} else if err != nil && err != storage.ErrNotFound {
@@ -65,29 +69,36 @@ func (e *Engine) CreateTransferWithID(ctx context.Context, transferID string, ma
 	return e.store.SaveManifest(ctx, transferID, manifest)
 }
 
-func (e *Engine) AcceptChunk(ctx context.Context, transferID string, offset int64, data []byte) error {
+func (e *Engine) AcceptChunk(ctx context.Context, transferID string, offset int64, data []byte) (int64, error) {
 	if transferID == "" || offset < 0 {
-		return ErrInvalidInput
+		return 0, ErrInvalidInput
 	}
+	ctx, span := tracing.Start(ctx, "transfer.accept_chunk")
+	defer span.End()
+	span.SetAttribute("bytes", strconv.Itoa(len(data)))
 	existing, err := e.store.ReadRange(ctx, transferID, offset, int64(len(data)))
 	if err == nil {
 		if len(existing) == len(data) {
 			if bytes.Equal(existing, data) {
-				return e.updateBytesReceived(ctx, transferID, offset, int64(len(data)))
+				span.SetAttribute("outcome", "duplicate")
+				return 0, e.updateBytesReceived(ctx, transferID, offset, int64(len(data)))
 			}
-			return ErrChunkConflict
+			span.SetError("chunk_conflict")
+			return 0, ErrChunkConflict
 		}
 		if len(existing) > 0 && !bytes.Equal(existing, data[:len(existing)]) {
-			return ErrChunkConflict
+			span.SetError("chunk_conflict")
+			return 0, ErrChunkConflict
 		}
 	} else if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrInvalidRange) {
-		return err
+		return 0, err
 	}
 
 	if err := e.store.WriteChunk(ctx, transferID, offset, data); err != nil {
-		return err
+		return 0, err
 	}
-	return e.updateBytesReceived(ctx, transferID, offset, int64(len(data)))
+	written := int64(len(data) - len(existing))
+	return written, e.updateBytesReceived(ctx, transferID, offset, int64(len(data)))
 }
 
 func (e *Engine) FinalizeTransfer(_ context.Context, transferID string) error {
@@ -108,6 +119,8 @@ func (e *Engine) ReadRange(ctx context.Context, transferID string, offset int64,
 	if transferID == "" {
 		return nil, ErrInvalidInput
 	}
+	ctx, span := tracing.Start(ctx, "transfer.read_range")
+	defer span.End()
 	return e.store.ReadRange(ctx, transferID, offset, length)
 }
 
@@ -115,6 +128,8 @@ func (e *Engine) DeleteOnReceipt(ctx context.Context, transferID string) error {
 	if transferID == "" {
 		return ErrInvalidInput
 	}
+	ctx, span := tracing.Start(ctx, "transfer.delete_on_receipt")
+	defer span.End()
 	return e.store.DeleteTransfer(ctx, transferID)
 }
 
@@ -174,10 +189,15 @@ func (e *Engine) StoreScanChunk(ctx context.Context, scanID string, chunkIndex i
 	return e.store.StoreScanChunk(ctx, scanID, chunkIndex, data)
 }
 
-func (e *Engine) FinalizeScan(ctx context.Context, scanID string, scan scanner.Scanner, maxBytes int64, maxDuration time.Duration) (domain.ScanStatus, error) {
+func (e *Engine) FinalizeScan(ctx context.Context, scanID string, scan scanner.Scanner, maxBytes int64, maxDuration time.Duration) (status domain.ScanStatus, err error) {
 	if scanID == "" {
 		return domain.ScanStatusUnavailable, ErrInvalidInput
 	}
+	ctx, span := tracing.Start(ctx, "transfer.finalize_scan")
+	defer func() {
+		span.SetAttribute("outcome", string(status))
+		span.End()
+	}()
 	scanSession, err := e.store.GetScanSession(ctx, scanID)
 	if err != nil {
 		return domain.ScanStatusUnavailable, err
@@ -230,7 +250,10 @@ func (e *Engine) FinalizeScan(ctx context.Context, scanID string, scan scanner.S
 		scanCtx, cancel = context.WithTimeout(ctx, maxDuration)
 		defer cancel()
 	}
+	_, scanSpan := tracing.Start(scanCtx, "scanner.scan")
+	scanSpan.SetAttribute("bytes", strconv.Itoa(len(plaintext)))
 	result, err := scan.Scan(scanCtx, plaintext)
+	scanSpan.End()
 	if err != nil {
 		if errors.Is(err, scanner.ErrUnavailable) {
 			return domain.ScanStatusUnavailable, nil